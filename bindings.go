@@ -0,0 +1,279 @@
+package fynewidgets
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/driver/desktop"
+	"github.com/pkg/errors"
+)
+
+// preference holding input bindings saved with InputBindings.Save, as JSON
+const InputBindingsPreference = "fynewidgets.inputbindings"
+
+// InputAction names something a PanZoomCanvas does in response to input
+type InputAction string
+
+const (
+	ActionNone InputAction = "" // input is ignored
+
+	ActionPan               InputAction = "pan"    // mouse button: drag the image with the mouse, carrying on after a fast drag
+	ActionZoom              InputAction = "zoom"   // wheel: zoom a tick about the mouse
+	ActionWheelPan          InputAction = "scroll" // wheel: move the image the distance scrolled, as mapping apps do
+	ActionPanLeft           InputAction = "pan-left"
+	ActionPanRight          InputAction = "pan-right"
+	ActionPanUp             InputAction = "pan-up"
+	ActionPanDown           InputAction = "pan-down"
+	ActionZoomIn            InputAction = "zoom-in"  // a tick
+	ActionZoomOut           InputAction = "zoom-out" // a tick
+	ActionZoomInOctave      InputAction = "zoom-in-octave"
+	ActionZoomOutOctave     InputAction = "zoom-out-octave"
+	ActionDoubleScale       InputAction = "double-scale"
+	ActionHalveScale        InputAction = "halve-scale"
+	ActionFit               InputAction = "fit"
+	ActionActualSize        InputAction = "actual-size" // 100%, one image pixel per physical pixel
+	ActionTurnClockwise     InputAction = "turn-clockwise"
+	ActionTurnAnticlockwise InputAction = "turn-anticlockwise"
+	ActionTiltClockwise     InputAction = "tilt-clockwise" // 15 degrees
+	ActionTiltAnticlockwise InputAction = "tilt-anticlockwise"
+	ActionFlipHorizontal    InputAction = "flip-horizontal"
+	ActionFlipVertical      InputAction = "flip-vertical"
+	ActionUpright           InputAction = "upright"
+	ActionBack              InputAction = "back"
+	ActionForward           InputAction = "forward"
+)
+
+// every action, for checking bindings read from preferences
+var inputActions = map[InputAction]bool{
+	ActionNone: true, ActionPan: true, ActionZoom: true, ActionWheelPan: true,
+	ActionPanLeft: true, ActionPanRight: true, ActionPanUp: true, ActionPanDown: true,
+	ActionZoomIn: true, ActionZoomOut: true, ActionZoomInOctave: true, ActionZoomOutOctave: true,
+	ActionDoubleScale: true, ActionHalveScale: true, ActionFit: true, ActionActualSize: true,
+	ActionTurnClockwise: true, ActionTurnAnticlockwise: true, ActionTiltClockwise: true, ActionTiltAnticlockwise: true,
+	ActionFlipHorizontal: true, ActionFlipVertical: true, ActionUpright: true, ActionBack: true, ActionForward: true,
+}
+
+// InputBindings maps inputs to the actions a PanZoomCanvas takes for them. An input is named by its modifiers, each
+// followed by "+", in the order Ctrl, Alt, Shift, Super, then one of:
+//
+//	Primary, Secondary, Tertiary   a mouse button, clicked or, if bound to ActionPan, dragged
+//	Wheel                          the mouse wheel or trackpad
+//	a single character             a character typed, such as "w" or "+", without modifiers
+//	a fyne key name                a key such as "Left", "Home" or "Prior" (fyne.KeyPageUp), or a letter with Ctrl,
+//	                               Alt or Super, such as "Ctrl+A" or "Alt+F"
+//
+// so "Ctrl+Wheel", "Alt+Left" and "Shift+Primary" are all inputs. Use MouseInput, WheelInput and KeyInput to name them.
+// Keys with names cannot be bound with Shift alone, as fyne gives them without it: Shift+Left does what Left does.
+// On macOS, fyne's standard shortcuts such as copy and select all use Super rather than Ctrl, so are "Super+C" and
+// "Super+A" there.
+type InputBindings map[string]InputAction
+
+// bindings for the mouse and keyboard as the README describes them: dragging pans, the wheel zooms
+func StandardInputBindings() InputBindings {
+	return InputBindings{
+		"Primary": ActionPan, "Secondary": ActionFit,
+		"Wheel": ActionZoom,
+		"Left":  ActionPanLeft, "Right": ActionPanRight, "Up": ActionPanUp, "Down": ActionPanDown,
+		"a": ActionPanLeft, "d": ActionPanRight, "w": ActionPanUp, "s": ActionPanDown,
+		"A": ActionPanLeft, "D": ActionPanRight, "W": ActionPanUp, "S": ActionPanDown,
+		"+": ActionZoomIn, "=": ActionZoomIn, "-": ActionZoomOut, "_": ActionZoomOut,
+		string(fyne.KeyPageUp): ActionZoomInOctave, string(fyne.KeyPageDown): ActionZoomOutOctave,
+		"2": ActionDoubleScale, "1": ActionHalveScale,
+		"Home": ActionFit, "f": ActionFit, "F": ActionFit, "0": ActionActualSize,
+		"r": ActionTurnClockwise, "R": ActionTurnAnticlockwise, "]": ActionTiltClockwise, "[": ActionTiltAnticlockwise,
+		"h": ActionFlipHorizontal, "v": ActionFlipVertical, "u": ActionUpright,
+		"Alt+Left": ActionBack, "Alt+Right": ActionForward,
+	}
+}
+
+// the standard bindings, except that the wheel pans and Ctrl+wheel zooms, as in mapping apps
+func MapInputBindings() InputBindings {
+	b := StandardInputBindings()
+	b["Wheel"] = ActionWheelPan
+	b["Ctrl+Wheel"] = ActionZoom
+	return b
+}
+
+var defaultbindings = StandardInputBindings()
+var defaultbindingsmu sync.Mutex
+
+// the bindings used by every widget not given its own with PanZoomCanvas.SetInputBindings
+func DefaultInputBindings() InputBindings {
+	defaultbindingsmu.Lock()
+	defer defaultbindingsmu.Unlock()
+	return defaultbindings.clone()
+}
+
+// changes the bindings returned by DefaultInputBindings, for every widget that has no bindings of its own
+func SetDefaultInputBindings(b InputBindings) {
+	defaultbindingsmu.Lock()
+	defer defaultbindingsmu.Unlock()
+	defaultbindings = b.clone()
+}
+
+// bindings saved in the app's preferences, such as fyne.CurrentApp().Preferences(), or the standard ones if none
+// have been saved
+func LoadInputBindings(prefs fyne.Preferences) (InputBindings, error) {
+	data := prefs.String(InputBindingsPreference)
+	if data == "" {
+		return StandardInputBindings(), nil
+	}
+	b := InputBindings{}
+	if err := json.Unmarshal([]byte(data), &b); err != nil {
+		return nil, errors.Wrap(err, "f: LoadInputBindings")
+	}
+	for input, action := range b {
+		if !inputActions[action] {
+			return nil, errors.Errorf("f: LoadInputBindings - %q is bound to unknown action %q", input, action)
+		}
+	}
+	return b, nil
+}
+
+// saves the bindings in the app's preferences, for LoadInputBindings
+func (b InputBindings) Save(prefs fyne.Preferences) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return errors.Wrap(err, "f: InputBindings.Save")
+	}
+	prefs.SetString(InputBindingsPreference, string(data))
+	return nil
+}
+
+func (b InputBindings) clone() InputBindings {
+	c := make(InputBindings, len(b))
+	for input, action := range b {
+		c[input] = action
+	}
+	return c
+}
+
+// names a mouse button pressed with modifiers
+func MouseInput(button desktop.MouseButton, modifier fyne.KeyModifier) string {
+	name := ""
+	switch button {
+	case desktop.MouseButtonPrimary:
+		name = "Primary"
+	case desktop.MouseButtonSecondary:
+		name = "Secondary"
+	case desktop.MouseButtonTertiary:
+		name = "Tertiary"
+	}
+	return modifierPrefix(modifier) + name
+}
+
+// names the wheel turned with modifiers
+func WheelInput(modifier fyne.KeyModifier) string {
+	return modifierPrefix(modifier) + "Wheel"
+}
+
+// names a key, such as fyne.KeyLeft or "+", pressed with modifiers
+func KeyInput(key fyne.KeyName, modifier fyne.KeyModifier) string {
+	return modifierPrefix(modifier) + string(key)
+}
+
+func modifierPrefix(modifier fyne.KeyModifier) string {
+	var prefix strings.Builder
+	for _, m := range []struct {
+		modifier fyne.KeyModifier
+		name     string
+	}{{fyne.KeyModifierControl, "Ctrl+"}, {fyne.KeyModifierAlt, "Alt+"}, {fyne.KeyModifierShift, "Shift+"}, {fyne.KeyModifierSuper, "Super+"}} {
+		if modifier&m.modifier != 0 {
+			prefix.WriteString(m.name)
+		}
+	}
+	return prefix.String()
+}
+
+// gives the widget its own bindings, or, if nil, has it use DefaultInputBindings again
+func (p *PanZoomCanvas) SetInputBindings(b InputBindings) {
+	if b != nil {
+		b = b.clone()
+	}
+	p.mu.Lock()
+	p.bindings = b
+	p.mu.Unlock()
+}
+
+// the action bound to an input for this widget
+func (p *PanZoomCanvas) action(input string) InputAction {
+	p.mu.Lock()
+	b := p.bindings
+	p.mu.Unlock()
+	if b != nil {
+		return b[input]
+	}
+	defaultbindingsmu.Lock()
+	defer defaultbindingsmu.Unlock()
+	return defaultbindings[input]
+}
+
+// modifiers held now, which fyne does not give with a scroll
+func (p *PanZoomCanvas) modifiers() fyne.KeyModifier {
+	if fyne.CurrentApp() == nil {
+		return 0
+	}
+	if d, ok := fyne.CurrentApp().Driver().(desktop.Driver); ok {
+		return d.CurrentKeyModifiers()
+	}
+	return 0
+}
+
+// takes an action that needs no more than a position, such as one bound to a key or a click, about a device position.
+// Reports whether there was anything to do.
+func (p *PanZoomCanvas) perform(a InputAction, at fyne.Position) bool {
+	switch a {
+	case ActionPanLeft:
+		p.pan(-1, 0)
+	case ActionPanRight:
+		p.pan(1, 0)
+	case ActionPanUp:
+		p.pan(0, -1)
+	case ActionPanDown:
+		p.pan(0, 1)
+	case ActionZoomIn:
+		p.zoomAt(at, func(*Datum) int { return 1 })
+	case ActionZoomOut:
+		p.zoomAt(at, func(*Datum) int { return -1 })
+	case ActionZoomInOctave:
+		p.zoomAt(at, func(d *Datum) int { return d.Sensitivity })
+	case ActionZoomOutOctave:
+		p.zoomAt(at, func(d *Datum) int { return -d.Sensitivity })
+	case ActionDoubleScale:
+		p.changeView("scale", func(d *Datum) error { return d.ChangeScale(2.0) })
+	case ActionHalveScale:
+		p.changeView("scale", func(d *Datum) error { return d.ChangeScale(0.5) })
+	case ActionFit:
+		p.fit()
+	case ActionActualSize:
+		p.changeView("scale", func(d *Datum) error { return d.setScale(at, 1/d.pixelRatio()) }) // one image pixel per physical pixel
+	case ActionTurnClockwise:
+		p.reorient(func(d *Datum, centre fyne.Position) { d.Rotate(centre, 90) })
+	case ActionTurnAnticlockwise:
+		p.reorient(func(d *Datum, centre fyne.Position) { d.Rotate(centre, -90) })
+	case ActionTiltClockwise:
+		p.reorient(func(d *Datum, centre fyne.Position) { d.Rotate(centre, 15) })
+	case ActionTiltAnticlockwise:
+		p.reorient(func(d *Datum, centre fyne.Position) { d.Rotate(centre, -15) })
+	case ActionFlipHorizontal:
+		p.reorient(func(d *Datum, centre fyne.Position) { d.FlipHorizontal(centre) })
+	case ActionFlipVertical:
+		p.reorient(func(d *Datum, centre fyne.Position) { d.FlipVertical(centre) })
+	case ActionUpright:
+		p.reorient(func(d *Datum, centre fyne.Position) {
+			d.Rotate(centre, -d.Rotation)
+			if d.Mirrored {
+				d.FlipHorizontal(centre)
+			}
+		})
+	case ActionBack:
+		p.Back()
+	case ActionForward:
+		p.Forward()
+	default:
+		return false
+	}
+	return true
+}
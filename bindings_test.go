@@ -0,0 +1,103 @@
+package fynewidgets
+
+import (
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/test"
+	eventbus "github.com/dtomasi/go-event-bus/v3"
+)
+
+func TestInputBindings(t *testing.T) {
+	names := []struct{ got, want string }{
+		{MouseInput(desktop.MouseButtonPrimary, 0), "Primary"},
+		{MouseInput(desktop.MouseButtonPrimary, fyne.KeyModifierShift|fyne.KeyModifierControl), "Ctrl+Shift+Primary"},
+		{MouseInput(desktop.MouseButtonTertiary, fyne.KeyModifierAlt), "Alt+Tertiary"},
+		{WheelInput(fyne.KeyModifierControl), "Ctrl+Wheel"},
+		{KeyInput(fyne.KeyLeft, fyne.KeyModifierAlt), "Alt+Left"},
+		{KeyInput("+", 0), "+"},
+	}
+	for _, n := range names {
+		if n.got != n.want {
+			t.Errorf("input named %q, want %q", n.got, n.want)
+		}
+	}
+
+	a := test.NewTempApp(t)
+	prefs := a.Preferences()
+	if b, err := LoadInputBindings(prefs); err != nil || b["Wheel"] != ActionZoom {
+		t.Errorf("nothing saved loaded %v, %v, want the standard bindings", b, err)
+	}
+	if err := MapInputBindings().Save(prefs); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadInputBindings(prefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b["Wheel"] != ActionWheelPan || b["Ctrl+Wheel"] != ActionZoom || len(b) != len(MapInputBindings()) {
+		t.Errorf("loaded %v", b)
+	}
+	if err := (InputBindings{"Left": "pan-lfet"}).Save(prefs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadInputBindings(prefs); err == nil {
+		t.Error("a binding to an unknown action was loaded")
+	}
+
+	p, _ := newTestPanZoom(t, eventbus.NewEventBus())
+	p.TypedRune('0') // 100%, so that there is room to pan
+
+	centre := p.centre()
+	scroll := func(dy float32) {
+		p.Scrolled(&fyne.ScrollEvent{PointEvent: fyne.PointEvent{Position: centre}, Scrolled: fyne.Delta{DY: dy}})
+	}
+	shown := func() (ImagePoint, int) { return shownAt(p, centre) }
+
+	start, ticks := shown()
+	scroll(1)
+	if _, got := shown(); got != ticks+1 {
+		t.Errorf("standard wheel went from %d to %d ticks", ticks, got)
+	}
+	scroll(-1)
+
+	p.SetInputBindings(MapInputBindings())
+	scroll(10)
+	at, got := shown()
+	if got != ticks || !near(at.Y, start.Y-10) || !near(at.X, start.X) {
+		t.Errorf("mapping wheel moved the centre from %v to %v, and ticks from %d to %d", start, at, ticks, got)
+	}
+
+	p.SetInputBindings(InputBindings{"Secondary": ActionPan})
+	p.MouseDown(&desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(100, 100)}, Button: desktop.MouseButtonPrimary})
+	p.MouseMoved(&desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(120, 100)}})
+	p.MouseUp(&desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(120, 100)}, Button: desktop.MouseButtonPrimary})
+	if now, _ := shown(); now != at {
+		t.Errorf("unbound primary button moved the centre from %v to %v", at, now)
+	}
+	p.MouseDown(&desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(100, 100)}, Button: desktop.MouseButtonSecondary})
+	p.MouseMoved(&desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(120, 100)}})
+	p.MouseUp(&desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(120, 100)}, Button: desktop.MouseButtonSecondary})
+	if now, _ := shown(); !near(now.X, at.X-20) || !near(now.Y, at.Y) {
+		t.Errorf("secondary drag moved the centre from %v to %v, want 20 pixels left", at, now)
+	}
+
+	defer SetDefaultInputBindings(StandardInputBindings())
+	SetDefaultInputBindings(InputBindings{"x": ActionZoomIn})
+	p.SetInputBindings(nil)
+	_, ticks = shown()
+	p.TypedRune('x')
+	p.TypedRune('+')
+	if _, got := shown(); got != ticks+1 {
+		t.Errorf("global bindings went from %d to %d ticks, want one tick", ticks, got)
+	}
+
+	selectall := &fyne.ShortcutSelectAll{}
+	p.SetInputBindings(InputBindings{KeyInput(selectall.Key(), selectall.Mod()): ActionZoomIn})
+	p.TypedShortcut(selectall)
+	if _, got := shown(); got != ticks+2 {
+		t.Errorf("select all shortcut went from %d to %d ticks, want one more", ticks+1, got)
+	}
+	p.render.idle()
+}
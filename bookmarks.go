@@ -0,0 +1,371 @@
+package fynewidgets
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	eventbus "github.com/dtomasi/go-event-bus/v3"
+	"github.com/pkg/errors"
+)
+
+// preference holding the bookmarks of NewPreferenceBookmarks, as JSON
+const BookmarksPreference = "fynewidgets.bookmarks"
+
+// Bookmark is a named view of an image
+type Bookmark struct {
+	Name string    `json:"name"`
+	View ViewState `json:"view"`
+}
+
+// BookmarksChanged is published on the "bookmarks:changed" topic when the bookmarks of an image are added to, changed
+// or removed
+type BookmarksChanged struct {
+	Image string // URI of the image
+}
+
+// Bookmarks keeps named views of images, by the URI of each image, and saves them whenever they change
+type Bookmarks struct {
+	mu       sync.Mutex
+	marks    map[string][]Bookmark // by image URI, in the order they were added
+	save     func([]byte) error
+	bus      *eventbus.EventBus
+	watchers map[int]func(image string) // told of every change, until they stop watching
+	watcher  int                        // key of the latest watcher
+}
+
+// bookmarks kept in the app's preferences, such as fyne.CurrentApp().Preferences()
+func NewPreferenceBookmarks(prefs fyne.Preferences, bus *eventbus.EventBus) (*Bookmarks, error) {
+	save := func(data []byte) error {
+		prefs.SetString(BookmarksPreference, string(data))
+		return nil
+	}
+	b, err := newBookmarks([]byte(prefs.String(BookmarksPreference)), save, bus)
+	if err != nil {
+		return nil, errors.Wrap(err, "f: NewPreferenceBookmarks")
+	}
+	return b, nil
+}
+
+// bookmarks kept in a JSON file, which is made when the first bookmark is added. The file is replaced whole each
+// time, so a crash while saving leaves the previous bookmarks rather than half a file.
+func NewFileBookmarks(path string, bus *eventbus.EventBus) (*Bookmarks, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "f: NewFileBookmarks")
+	}
+	save := func(data []byte) error { return writeFileAtomic(path, data) }
+	b, err := newBookmarks(data, save, bus)
+	if err != nil {
+		return nil, errors.Wrap(err, "f: NewFileBookmarks")
+	}
+	return b, nil
+}
+
+func newBookmarks(data []byte, save func([]byte) error, bus *eventbus.EventBus) (*Bookmarks, error) {
+	b := &Bookmarks{marks: map[string][]Bookmark{}, save: save, bus: bus, watchers: map[int]func(string){}}
+	if len(data) == 0 {
+		return b, nil
+	}
+	if err := json.Unmarshal(data, &b.marks); err != nil {
+		return nil, errors.Wrap(err, "reading bookmarks")
+	}
+	return b, nil
+}
+
+// URIs of the images with bookmarks, in order
+func (b *Bookmarks) Images() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	images := make([]string, 0, len(b.marks))
+	for image := range b.marks {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// bookmarks of an image, in the order they were added
+func (b *Bookmarks) List(image string) []Bookmark {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Bookmark(nil), b.marks[image]...)
+}
+
+// a bookmark of an image by name
+func (b *Bookmarks) Get(image, name string) (Bookmark, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, mark := range b.marks[image] {
+		if mark.Name == name {
+			return mark, true
+		}
+	}
+	return Bookmark{}, false
+}
+
+// bookmarks a view of an image, replacing any bookmark of the same name
+func (b *Bookmarks) Set(image, name string, view ViewState) error {
+	if image == "" || name == "" {
+		return errors.New("f: Bookmarks.Set - a bookmark needs an image and a name")
+	}
+	view.Image = image
+	b.mu.Lock()
+	marks := b.marks[image]
+	i := 0
+	for i < len(marks) && marks[i].Name != name {
+		i++
+	}
+	if i == len(marks) {
+		marks = append(marks, Bookmark{Name: name})
+	}
+	marks[i].View = view
+	b.marks[image] = marks
+	b.mu.Unlock()
+	return b.changed(image)
+}
+
+// forgets a bookmark, if there is one of that name
+func (b *Bookmarks) Remove(image, name string) error {
+	b.mu.Lock()
+	marks := b.marks[image]
+	kept := marks[:0:0]
+	for _, mark := range marks {
+		if mark.Name != name {
+			kept = append(kept, mark)
+		}
+	}
+	if len(kept) == len(marks) {
+		b.mu.Unlock()
+		return nil
+	}
+	if len(kept) == 0 {
+		delete(b.marks, image)
+	} else {
+		b.marks[image] = kept
+	}
+	b.mu.Unlock()
+	return b.changed(image)
+}
+
+// bookmarks what a widget shows. Its image must have come from a URI.
+func (b *Bookmarks) Add(p *PanZoomCanvas, name string) error {
+	if p.URI() == nil {
+		return errors.New("f: Bookmarks.Add - image has no URI")
+	}
+	view, err := p.ViewState()
+	if err != nil {
+		return errors.Wrap(err, "f: Bookmarks.Add")
+	}
+	return b.Set(p.URI().String(), name, view)
+}
+
+// shows a bookmarked view of a widget's image
+func (b *Bookmarks) Show(p *PanZoomCanvas, name string) error {
+	if p.URI() == nil {
+		return errors.New("f: Bookmarks.Show - image has no URI")
+	}
+	mark, ok := b.Get(p.URI().String(), name)
+	if !ok {
+		return errors.Errorf("f: Bookmarks.Show - no bookmark %q", name)
+	}
+	return p.SetViewState(mark.View)
+}
+
+// calls a function with the URI of the image whenever bookmarks change, until the returned function is called
+func (b *Bookmarks) watch(f func(image string)) (stop func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.watcher++
+	key := b.watcher
+	b.watchers[key] = f
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.watchers, key)
+	}
+}
+
+// saves the bookmarks and tells the application, and anything watching them
+func (b *Bookmarks) changed(image string) error {
+	b.mu.Lock()
+	data, err := json.Marshal(b.marks)
+	if err == nil {
+		err = b.save(data)
+	}
+	watchers := make([]func(string), 0, len(b.watchers))
+	for _, f := range b.watchers {
+		watchers = append(watchers, f)
+	}
+	b.mu.Unlock()
+	for _, f := range watchers {
+		f(image)
+	}
+	b.bus.PublishAsync("bookmarks:changed", BookmarksChanged{Image: image})
+	if err != nil {
+		return errors.Wrap(err, "saving bookmarks")
+	}
+	return nil
+}
+
+// BookmarkList shows the bookmarks of the image in a PanZoomCanvas: tapping one shows it, and new ones can be named
+// and added for the current view
+type BookmarkList struct {
+	widget.BaseWidget
+	bookmarks *Bookmarks
+	canvas    *PanZoomCanvas
+	marks     []Bookmark // shown in the list
+	list      *widget.List
+	name      *widget.Entry
+	bus       *eventbus.EventBus
+	stop      func() // stops watching the bookmarks, or nil if the list is not watching them
+	mu        sync.Mutex
+}
+
+// a list of the bookmarks of a widget's image, kept up to date while it is shown. Errors are published on "text:status".
+func NewBookmarkList(bookmarks *Bookmarks, canvas *PanZoomCanvas, bus *eventbus.EventBus) *BookmarkList {
+	b := &BookmarkList{bookmarks: bookmarks, canvas: canvas, bus: bus}
+	b.list = widget.NewList(b.length, b.createItem, b.updateItem)
+	b.list.OnSelected = func(id widget.ListItemID) {
+		b.list.UnselectAll()
+		if mark, ok := b.at(id); ok && b.target() != nil {
+			if err := b.target().SetViewState(mark.View); err != nil {
+				b.bus.PublishAsync("text:status", "bookmark "+mark.Name+": "+err.Error())
+			}
+		}
+	}
+	b.name = widget.NewEntry()
+	b.name.SetPlaceHolder("Bookmark name")
+	b.name.OnSubmitted = func(string) { b.add() }
+	b.reload()
+	b.ExtendBaseWidget(b)
+	return b
+}
+
+// shows the bookmarks of another widget's image
+func (b *BookmarkList) SetCanvas(canvas *PanZoomCanvas) {
+	b.mu.Lock()
+	b.canvas = canvas
+	b.mu.Unlock()
+	b.reload()
+}
+
+// watches the bookmarks for as long as the list is shown
+func (b *BookmarkList) CreateRenderer() fyne.WidgetRenderer {
+	add := widget.NewButtonWithIcon("", theme.ContentAddIcon(), b.add)
+	top := container.NewBorder(nil, nil, nil, add, b.name)
+	b.follow()
+	return &bookmarkListRenderer{WidgetRenderer: widget.NewSimpleRenderer(container.NewBorder(top, nil, nil, nil, b.list)), list: b}
+}
+
+// stops the list following changes to the bookmarks, as happens when fyne destroys its renderer. Call it when the
+// list is removed, as it cannot tell by itself. It follows them again if it is shown again.
+func (b *BookmarkList) Close() {
+	b.mu.Lock()
+	stop := b.stop
+	b.stop = nil
+	b.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+// starts following changes to the bookmarks, if the list is not already, and catches up with any it missed
+func (b *BookmarkList) follow() {
+	b.mu.Lock()
+	if b.stop == nil {
+		b.stop = b.bookmarks.watch(func(image string) {
+			if image == b.image() {
+				b.reload()
+			}
+		})
+	}
+	b.mu.Unlock()
+	b.reload()
+}
+
+// stops the list watching the bookmarks when it is no longer shown
+type bookmarkListRenderer struct {
+	fyne.WidgetRenderer
+	list *BookmarkList
+}
+
+func (r *bookmarkListRenderer) Destroy() {
+	r.list.Close()
+	r.WidgetRenderer.Destroy()
+}
+
+func (b *BookmarkList) target() *PanZoomCanvas {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.canvas
+}
+
+// URI of the image whose bookmarks are listed, or "" if there is none
+func (b *BookmarkList) image() string {
+	if p := b.target(); p != nil && p.URI() != nil {
+		return p.URI().String()
+	}
+	return ""
+}
+
+// bookmarks the current view under the name typed
+func (b *BookmarkList) add() {
+	p := b.target()
+	if p == nil || b.name.Text == "" {
+		return
+	}
+	if err := b.bookmarks.Add(p, b.name.Text); err != nil {
+		b.bus.PublishAsync("text:status", "bookmark: "+err.Error())
+		return
+	}
+	b.name.SetText("")
+}
+
+func (b *BookmarkList) reload() {
+	marks := b.bookmarks.List(b.image())
+	b.mu.Lock()
+	b.marks = marks
+	b.mu.Unlock()
+	b.list.Refresh()
+}
+
+func (b *BookmarkList) length() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.marks)
+}
+
+func (b *BookmarkList) at(id widget.ListItemID) (Bookmark, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if id < 0 || id >= len(b.marks) {
+		return Bookmark{}, false
+	}
+	return b.marks[id], true
+}
+
+func (b *BookmarkList) createItem() fyne.CanvasObject {
+	remove := widget.NewButtonWithIcon("", theme.DeleteIcon(), nil)
+	remove.Importance = widget.LowImportance
+	return container.NewBorder(nil, nil, nil, remove, widget.NewLabel(""))
+}
+
+func (b *BookmarkList) updateItem(id widget.ListItemID, item fyne.CanvasObject) {
+	mark, ok := b.at(id)
+	if !ok {
+		return
+	}
+	row := item.(*fyne.Container)
+	row.Objects[0].(*widget.Label).SetText(mark.Name)
+	row.Objects[1].(*widget.Button).OnTapped = func() {
+		if err := b.bookmarks.Remove(mark.View.Image, mark.Name); err != nil {
+			b.bus.PublishAsync("text:status", "bookmark: "+err.Error())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package fynewidgets
+
+import (
+	"image"
+	"path/filepath"
+	"testing"
+
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/test"
+	eventbus "github.com/dtomasi/go-event-bus/v3"
+)
+
+func TestBookmarks(t *testing.T) {
+	bus := eventbus.NewEventBus()
+	events := bus.Subscribe("bookmarks:changed")
+	path := filepath.Join(t.TempDir(), "bookmarks.json")
+	b, err := NewFileBookmarks(path, bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	view := ViewState{Width: 3001, Height: 2001, Anchor: ImagePoint{1500.5, 20.25}, DeviceX: .5, DeviceY: .5, Ticks: 7, Sensitivity: 5}
+	if err := b.Set("file:///a.tif", "crack", view); err != nil {
+		t.Fatal(err)
+	}
+	if e := (<-events).Data.(BookmarksChanged); e.Image != "file:///a.tif" {
+		t.Errorf("change published for %q", e.Image)
+	}
+	b.Set("file:///a.tif", "signature", view)
+	view.Ticks = 9
+	b.Set("file:///a.tif", "crack", view) // replaces the first
+	b.Set("file:///b.png", "sky", view)
+	b.Remove("file:///b.png", "sky")
+
+	again, err := NewFileBookmarks(path, bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if images := again.Images(); len(images) != 1 || images[0] != "file:///a.tif" {
+		t.Fatalf("bookmarked images are %v", images)
+	}
+	marks := again.List("file:///a.tif")
+	if len(marks) != 2 || marks[0].Name != "crack" || marks[0].View.Ticks != 9 || marks[1].Name != "signature" || marks[0].View.Image != "file:///a.tif" {
+		t.Errorf("bookmarks are %+v", marks)
+	}
+
+	prefs := test.NewTempApp(t).Preferences()
+	p, _ := NewPreferenceBookmarks(prefs, bus)
+	p.Set("file:///a.tif", "crack", view)
+	again, err = NewPreferenceBookmarks(prefs, bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mark, ok := again.Get("file:///a.tif", "crack"); !ok || mark.View != p.List("file:///a.tif")[0].View {
+		t.Errorf("bookmark read back from preferences as %+v", mark)
+	}
+}
+
+func TestBookmarkList(t *testing.T) {
+	test.NewTempApp(t)
+	bus := eventbus.NewEventBus()
+	b, err := NewFileBookmarks(filepath.Join(t.TempDir(), "bookmarks.json"), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canvas, err := NewPanZoomCanvasFromImage(testImage(300, 200), image.Pt(20, 20), bus, "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer canvas.Close()
+	canvas.uri = storage.NewFileURI("/a.tif")
+	list := NewBookmarkList(b, canvas, bus)
+	w := test.NewWindow(list)
+	defer w.Close()
+
+	b.Set("file:///a.tif", "crack", ViewState{Width: 300, Height: 200})
+	if list.length() != 1 {
+		t.Errorf("shown list has %d bookmarks, want 1", list.length())
+	}
+	test.WidgetRenderer(list).Destroy() // as fyne does once the list is no longer shown
+	b.Set("file:///a.tif", "sky", ViewState{Width: 300, Height: 200})
+	if list.length() != 1 || len(b.watchers) != 0 {
+		t.Errorf("list no longer shown followed a change to %d bookmarks, with %d watchers", list.length(), len(b.watchers))
+	}
+	list.follow() // as a new renderer does
+	if list.length() != 2 || len(b.watchers) != 1 {
+		t.Errorf("list shown again has %d bookmarks and %d watchers, want 2 and 1", list.length(), len(b.watchers))
+	}
+	list.Close()
+	if len(b.watchers) != 0 {
+		t.Errorf("%d watchers after closing the list", len(b.watchers))
+	}
+}
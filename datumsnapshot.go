@@ -0,0 +1,111 @@
+package fynewidgets
+
+import (
+	"math"
+	"sync/atomic"
+
+	"fyne.io/fyne/v2"
+	"github.com/pkg/errors"
+)
+
+// DatumSnapshot is a copy of what a datum shows at one moment. It holds no pointers, so it can be kept, compared and
+// published while the datum goes on changing.
+type DatumSnapshot struct {
+	ImageCoords  ImagePoint    // full-resolution image location shown at DeviceCoords
+	DeviceCoords fyne.Position // device location of ImageCoords
+	Scale        float32       // device pixels per image pixel
+	Ticks        int           // scale, as a number of ticks
+	Sensitivity  int           // ticks per octave
+	Rotation     float64       // degrees clockwise
+	Mirrored     bool          // flipped left to right before turning
+	Level        int           // pyramid level shown
+}
+
+// DatumChanged is published on the "datum:changed" topic whenever a PanZoomCanvas changes its datum. Events can
+// arrive out of order, so one with a higher Sequence, from any widget, supersedes one with a lower.
+type DatumChanged struct {
+	Source   *PanZoomCanvas // widget whose datum changed
+	Sequence uint64         // orders the changes of all widgets
+	Snapshot DatumSnapshot  // datum after the change
+}
+
+// numbers the changes published by all widgets
+var datumSequence atomic.Uint64
+
+// a copy of what the datum shows. A datum shown by a PanZoomCanvas changes under the widget's lock, so take its
+// snapshot with PanZoomCanvas.Snapshot instead.
+func (d *Datum) Snapshot() DatumSnapshot {
+	s := DatumSnapshot{Scale: d.Scale, Ticks: d.Ticks, Sensitivity: d.Sensitivity, Rotation: d.Rotation, Mirrored: d.Mirrored}
+	if d.ImageCoords != nil {
+		s.ImageCoords = *d.ImageCoords
+	}
+	if d.DeviceCoords != nil {
+		s.DeviceCoords = *d.DeviceCoords
+	}
+	s.Level = d.level
+	return s
+}
+
+// the projection the snapshot shows, for converting positions without the datum
+func (s DatumSnapshot) Projection() Projection {
+	return Projection{Image: s.ImageCoords, Device: s.DeviceCoords, Scale: s.Scale, Rotation: s.Rotation, Mirrored: s.Mirrored}
+}
+
+// shows what a snapshot shows, at the level that suits this datum's own pyramid. The datum has its own copies of the
+// snapshot's positions, so the two never share anything. Use PanZoomCanvas.ApplySnapshot for a widget's datum.
+func (d *Datum) Apply(s DatumSnapshot) {
+	image, device := s.ImageCoords, s.DeviceCoords
+	d.ImageCoords, d.DeviceCoords = &image, &device
+	d.Scale, d.Ticks, d.Sensitivity = s.Scale, s.Ticks, s.Sensitivity
+	d.Rotation, d.Mirrored = s.Rotation, s.Mirrored
+	if d.Pyramid != nil && d.Scale > 0 {
+		d.level = d.levelForScale(d.Scale)
+	}
+}
+
+// a copy of what the widget's datum shows, and whether it has one yet
+func (p *PanZoomCanvas) Snapshot() (DatumSnapshot, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.datum == nil {
+		return DatumSnapshot{}, false
+	}
+	return p.datum.Snapshot(), true
+}
+
+// changes the widget's datum with the widget locked, so that nothing else changes it or reads it meanwhile, then
+// redraws and tells other widgets. This is the safe way for an application to change a widget's datum.
+func (p *PanZoomCanvas) Update(change func(d *Datum) error) error {
+	p.stopMoving()
+	p.mu.Lock()
+	if p.datum == nil {
+		p.mu.Unlock()
+		return errors.New("f: Update - image has not loaded yet")
+	}
+	err := change(p.datum)
+	p.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "f: Update")
+	}
+	p.changed()
+	return nil
+}
+
+// shows what another widget's datum shows, and redraws. Other widgets are not told, so that linked widgets do not
+// echo each other.
+func (p *PanZoomCanvas) ApplySnapshot(s DatumSnapshot) {
+	p.follow(DatumChanged{Snapshot: s, Sequence: math.MaxUint64})
+}
+
+// shows the view of another widget's change, as a SynchronisedImageGrid does, unless this widget has changed since
+func (p *PanZoomCanvas) follow(e DatumChanged) {
+	p.stopMoving()
+	p.mu.Lock()
+	if p.datum == nil || e.Sequence < p.sequence {
+		p.mu.Unlock()
+		return
+	}
+	p.datum.Apply(e.Snapshot)
+	p.mu.Unlock()
+	p.Refresh()
+}
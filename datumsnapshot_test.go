@@ -0,0 +1,101 @@
+package fynewidgets
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+	eventbus "github.com/dtomasi/go-event-bus/v3"
+)
+
+func TestDatumSnapshot(t *testing.T) {
+	d, err := NewDatum(testImage(800, 600), image.Pt(20, 20), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.FitDevice(fyne.NewSize(400, 300))
+	d.ScaleByTick(fyne.NewPos(100, 100), 1)
+	d.Rotation, d.Mirrored = 90, true
+	s := d.Snapshot()
+
+	other := NewDatumFromPyramid(d.Pyramid, 5)
+	other.Apply(s)
+	if got := other.Snapshot(); got != s {
+		t.Errorf("applied %+v, got %+v", s, got)
+	}
+	other.ImageCoords.X++
+	other.DeviceCoords.Y++
+	if d.ImageCoords.X != s.ImageCoords.X || d.DeviceCoords.Y != s.DeviceCoords.Y {
+		t.Error("datums share their coordinates after applying a snapshot")
+	}
+	for i := 0; i < 10; i++ {
+		other.ScaleByTick(fyne.NewPos(100, 100), -1)
+	}
+	if d.Level() != s.Level || other.Level() == s.Level {
+		t.Errorf("levels %d and %d after zooming one datum out from level %d", d.Level(), other.Level(), s.Level)
+	}
+}
+
+func TestSynchronisedImageGridRace(t *testing.T) {
+	test.NewTempApp(t)
+	bus := eventbus.NewEventBus()
+	grid, err := NewSynchronisedImageGrid(3, bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grid.RemoveAll()
+	var canvases []*PanZoomCanvas
+	for i := 0; i < 3; i++ {
+		p, err := NewPanZoomCanvasFromImage(testImage(1001, 701), image.Pt(20, 20), bus, "sync")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.ExtendBaseWidget(p)
+		canvases = append(canvases, p)
+	}
+	grid.AddPanZoom(canvases...)
+	w := test.NewWindow(grid)
+	defer w.Close()
+	w.Resize(fyne.NewSize(900, 300)) // lays the canvases out at about 300 x 300
+	for _, p := range canvases {
+		p.render.idle()
+	}
+
+	source, other := canvases[0], canvases[1]
+	centre := fyne.NewPos(150, 150)
+	stress(100, func(i int) {
+		source.Scrolled(&fyne.ScrollEvent{PointEvent: fyne.PointEvent{Position: centre}, Scrolled: fyne.Delta{DY: float32(i%3 - 1)}})
+	}, func(i int) {
+		dragStep(source, i)
+	}, func(i int) {
+		other.Update(func(d *Datum) error { return d.ScaleByTick(centre, float32(i%3-1)) })
+	}, func(int) {
+		for _, p := range canvases {
+			p.Snapshot()
+			p.Refresh()
+		}
+		grid.Images()
+	})
+	source.Scrolled(&fyne.ScrollEvent{PointEvent: fyne.PointEvent{Position: centre}, Scrolled: fyne.Delta{DY: 1}})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, p := range canvases[1:] {
+		for {
+			want, _ := source.Snapshot()
+			got, _ := p.Snapshot()
+			if got.ImageCoords == want.ImageCoords && got.DeviceCoords == want.DeviceCoords && got.Ticks == want.Ticks {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("follower shows %+v, source %+v", got, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	for _, p := range canvases {
+		p.render.idle()
+		p.Close()
+	}
+}
@@ -0,0 +1,244 @@
+package fynewidgets
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+)
+
+// DZIOptions control how an image is written as a Deep Zoom Image
+type DZIOptions struct {
+	TileSize int    // tile width and height in pixels, not counting the overlap
+	Overlap  int    // pixels each tile shares with its neighbours on each side
+	Format   string // "jpg" or "png"
+	Quality  int    // JPEG quality, 1-100, or 0 for that of DefaultDZIOptions
+}
+
+// options that suit most web viewers, eg OpenSeadragon: 254 pixel JPEG tiles with 1 pixel of overlap
+func DefaultDZIOptions() DZIOptions {
+	return DZIOptions{TileSize: 254, Overlap: 1, Format: "jpg", Quality: 90}
+}
+
+// the .dzi descriptor
+type dziDescriptor struct {
+	XMLName  xml.Name `xml:"http://schemas.microsoft.com/deepzoom/2008 Image"`
+	Format   string   `xml:"Format,attr"`
+	Overlap  int      `xml:"Overlap,attr"`
+	TileSize int      `xml:"TileSize,attr"`
+	Size     struct {
+		Width  int `xml:"Width,attr"`
+		Height int `xml:"Height,attr"`
+	} `xml:"Size"`
+}
+
+// number of Deep Zoom levels for an image, from 1 x 1 pixel up to full resolution
+func (d dziDescriptor) levels() int {
+	n := 1
+	for size := max(d.Size.Width, d.Size.Height); size > 1; size = (size + 1) / 2 {
+		n++
+	}
+	return n
+}
+
+// extent of a Deep Zoom level. Level 0 is a single pixel and the last is full resolution, each halving the next, rounding up
+func (d dziDescriptor) bounds(level int) image.Rectangle {
+	w, h := d.Size.Width, d.Size.Height
+	for l := d.levels() - 1; l > level; l-- {
+		w, h = (w+1)/2, (h+1)/2
+	}
+	return image.Rect(0, 0, w, h)
+}
+
+// area of a level covered by a tile, including its overlap with its neighbours
+func (d dziDescriptor) tileRect(level, col, row int) image.Rectangle {
+	T, o := d.TileSize, d.Overlap
+	r := image.Rect(col*T-o, row*T-o, (col+1)*T+o, (row+1)*T+o)
+	return r.Intersect(d.bounds(level))
+}
+
+// folder of tiles that goes with a .dzi file
+func dziFolder(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_files"
+}
+
+// writes an image as a Deep Zoom Image, with the .dzi descriptor at path and its tiles in a folder beside it, named
+// as the descriptor with _files in place of the extension. A pyramid is made for the image, and closed afterwards.
+func ExportImageDZI(ctx context.Context, img image.Image, path string, options DZIOptions, progress func(done, total int)) error {
+	p, err := NewPyramid(img, image.Pt(16, 16))
+	if err != nil {
+		return errors.Wrap(err, "f: ExportImageDZI")
+	}
+	defer p.Close()
+	return p.ExportDZI(ctx, path, options, progress)
+}
+
+// writes the pyramid as a Deep Zoom Image, with the .dzi descriptor at path and its tiles in a folder beside it, named
+// as the descriptor with _files in place of the extension. Levels that the pyramid has are copied from it, in 8 bits
+// through its display mapping, and coarser ones are made from its coarsest level. Tiles are made and encoded on every
+// core. The descriptor is written last, so an export that fails or is cancelled is never mistaken for a whole one.
+//
+//	progress  if not nil, is called after each tile with the number written so far and the number needed
+func (p *Pyramid) ExportDZI(ctx context.Context, path string, options DZIOptions, progress func(done, total int)) error {
+	if options.TileSize < 1 || options.Overlap < 0 {
+		return errors.Errorf("f: ExportDZI - tile size %d and overlap %d are not possible", options.TileSize, options.Overlap)
+	}
+	if options.Format == "jpeg" {
+		options.Format = "jpg"
+	}
+	if options.Format != "jpg" && options.Format != "png" {
+		return errors.Errorf("f: ExportDZI - cannot write %q tiles", options.Format)
+	}
+	if options.Quality <= 0 {
+		options.Quality = DefaultDZIOptions().Quality
+	}
+	d := dziDescriptor{Format: options.Format, Overlap: options.Overlap, TileSize: options.TileSize}
+	d.Size.Width, d.Size.Height = p.bounds[0].Dx(), p.bounds[0].Dy()
+
+	type job struct{ level, col, row int }
+	jobs := make([]job, 0)
+	for level := 0; level < d.levels(); level++ {
+		b := d.bounds(level)
+		for row := 0; row*d.TileSize < b.Dy(); row++ {
+			for col := 0; col*d.TileSize < b.Dx(); col++ {
+				jobs = append(jobs, job{level, col, row})
+			}
+		}
+	}
+	folder := dziFolder(path)
+	for level := 0; level < d.levels(); level++ {
+		if err := os.MkdirAll(filepath.Join(folder, fmt.Sprint(level)), 0755); err != nil {
+			return errors.Wrap(err, "f: ExportDZI")
+		}
+	}
+	coarse := make(map[int]image.Image) // whole Deep Zoom levels coarser than the pyramid, made once
+	coarsemu := &sync.Mutex{}
+
+	ctx, cancel := context.WithCancel(ctx) // the first failure stops the other workers
+	defer cancel()
+	queue := make(chan job)
+	wg := &sync.WaitGroup{}
+	mu := &sync.Mutex{} // guards done, firsterr and calls to progress
+	done := 0
+	var firsterr error
+	workers := min(runtime.NumCPU(), len(jobs))
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				err := p.writeDZITile(ctx, d, j.level, j.col, j.row, folder, options, coarse, coarsemu)
+				mu.Lock()
+				if err != nil {
+					if firsterr == nil {
+						firsterr = err
+					}
+					cancel()
+				} else {
+					done++
+					if progress != nil {
+						progress(done, len(jobs))
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, j := range jobs {
+		select {
+		case queue <- j:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if firsterr != nil {
+		return errors.Wrap(firsterr, "f: ExportDZI")
+	}
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "f: ExportDZI")
+	}
+
+	b, err := xml.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "f: ExportDZI")
+	}
+	return errors.Wrap(writeFileAtomic(path, append([]byte(xml.Header), b...)), "f: ExportDZI")
+}
+
+// makes and writes one Deep Zoom tile
+func (p *Pyramid) writeDZITile(ctx context.Context, d dziDescriptor, level, col, row int, folder string, options DZIOptions, coarse map[int]image.Image, coarsemu *sync.Mutex) error {
+	r := d.tileRect(level, col, row)
+	var tile image.Image
+	if k := d.levels() - 1 - level; k < p.Height() {
+		region, err := p.dziRegion(ctx, k, r)
+		if err != nil {
+			return err
+		}
+		tile = region
+	} else {
+		coarsemu.Lock()
+		whole, ok := coarse[level]
+		if !ok {
+			top := p.Height() - 1
+			small, err := p.Region(top, p.bounds[top])
+			if err != nil {
+				coarsemu.Unlock()
+				return err
+			}
+			b := d.bounds(level)
+			whole = imaging.Resize(small, b.Dx(), b.Dy(), imaging.Box)
+			coarse[level] = whole
+		}
+		coarsemu.Unlock()
+		tile = imaging.Crop(whole, r)
+	}
+
+	f, err := os.Create(filepath.Join(folder, fmt.Sprint(level), fmt.Sprintf("%d_%d.%s", col, row, options.Format)))
+	if err != nil {
+		return err
+	}
+	if options.Format == "png" {
+		err = png.Encode(f, tile)
+	} else {
+		err = jpeg.Encode(f, tile, &jpeg.Options{Quality: options.Quality})
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// pixels of a pyramid level for a rectangle of the matching Deep Zoom level. Deep Zoom levels round their size up
+// where the pyramid rounds down, so they can be a pixel wider or taller; the last column or row is repeated to fill it.
+func (p *Pyramid) dziRegion(ctx context.Context, level int, r image.Rectangle) (image.Image, error) {
+	b := p.bounds[level]
+	inner := image.Rect(min(r.Min.X, b.Max.X-1), min(r.Min.Y, b.Max.Y-1), min(r.Max.X, b.Max.X), min(r.Max.Y, b.Max.Y)) // never empty, even for a tile of only the extra column
+	pixels, err := p.region(ctx, level, inner)
+	if err != nil {
+		return nil, err
+	}
+	display := p.display(pixels)
+	if inner == r {
+		return display, nil
+	}
+	out := image.NewNRGBA(r.Sub(r.Min))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sx := min(max(x, inner.Min.X), inner.Max.X-1) - inner.Min.X
+			sy := min(max(y, inner.Min.Y), inner.Max.Y-1) - inner.Min.Y
+			out.SetNRGBA(x-r.Min.X, y-r.Min.Y, display.NRGBAAt(sx, sy))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,90 @@
+package fynewidgets
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportDZI(t *testing.T) {
+	src := testImage(701, 500)
+	path := filepath.Join(t.TempDir(), "scan.dzi")
+	if err := ExportImageDZI(context.Background(), src, path, DZIOptions{TileSize: 254, Overlap: 1, Format: "png"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := dziDescriptor{}
+	if err := xml.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Size.Width != 701 || d.Size.Height != 500 || d.TileSize != 254 || d.Overlap != 1 || d.Format != "png" {
+		t.Fatalf("descriptor is %+v", d)
+	}
+	if d.levels() != 11 {
+		t.Fatalf("%d levels, want 11", d.levels())
+	}
+
+	read := func(name string) image.Image {
+		f, err := os.Open(filepath.Join(dziFolder(path), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		img, err := png.Decode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+
+	tile := read("10/1_1.png") // overlaps its neighbours on every side
+	if tile.Bounds().Size() != image.Pt(256, 247) {
+		t.Errorf("full-resolution tile is %v", tile.Bounds().Size())
+	}
+	for _, p := range []image.Point{{0, 0}, {100, 50}, {255, 246}} {
+		if !sameColour(tile.At(p.X, p.Y), src.At(253+p.X, 253+p.Y)) {
+			t.Errorf("pixel %v of tile differs from the source", p)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dziFolder(path), "10", "3_0.png")); err == nil {
+		t.Error("tile written beyond the image")
+	}
+
+	edge := read("9/1_0.png") // level 9 is 351 wide, one more than the pyramid's level 1
+	if edge.Bounds().Dx() != 351-253 || !sameColour(edge.At(97, 10), edge.At(96, 10)) {
+		t.Error("extra column of a level rounded up is not filled from its neighbour")
+	}
+	if read("0/0_0.png").Bounds().Size() != image.Pt(1, 1) {
+		t.Error("level 0 is not a single pixel")
+	}
+
+	tiles := map[int][]byte{}
+	for _, quality := range []int{0, DefaultDZIOptions().Quality} {
+		path := filepath.Join(t.TempDir(), "scan.dzi")
+		if err := ExportImageDZI(context.Background(), src, path, DZIOptions{TileSize: 254, Format: "jpg", Quality: quality}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if tiles[quality], err = os.ReadFile(filepath.Join(dziFolder(path), "10", "1_1.jpg")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(tiles[0], tiles[DefaultDZIOptions().Quality]) {
+		t.Error("JPEG tiles written without a quality differ from those at the default quality")
+	}
+}
+
+func sameColour(a, b color.Color) bool {
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
@@ -1,12 +1,15 @@
 package fynewidgets
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"io"
 	"log"
 	"math"
+	"os"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/driver/desktop"
@@ -74,6 +77,8 @@ func (b *BoxCursor) Image() (image.Image, int, int) {
 //	nlevels         height of the pyramid
 //
 // returns: pyramid level image to use, and scale to apply to it, in order to achieve the requested absolute scaling. The global scale is also returned (may be revised in tis function later)
+//
+// Deprecated: use Projection.Level, which chooses the level that the widgets draw from.
 func PyramidScale(absoluteScale float32, sensitivity, nlevels int) (int, float32, float32) {
 	l := math.Log2(float64(absoluteScale))
 	level := -int(math.Floor(l))
@@ -86,6 +91,8 @@ func PyramidScale(absoluteScale float32, sensitivity, nlevels int) (int, float32
 }
 
 // A Transform maintains the constants required to scale between device coordinates and underlying image coordinates
+//
+// Deprecated: use Projection, which also handles rotation, fractional pixels and every level at once.
 type PyramidTransform struct {
 	GlobalScale  float32 `default:"1.0"` // scale is Device/Image < 1 for large images
 	LayerScale   float32 // scale is Device/Image < 1 for large images
@@ -133,15 +140,7 @@ func (t *PyramidTransform) Rescale(centre fyne.Position, newScale float64) {
 }
 
 func (t *PyramidTransform) ToDevice(P image.Point) (*fyne.Position, error) {
-	x := float32(P.X)
-	y := float32(P.Y)
-	x -= float32(t.ImageCentre.X)
-	y -= float32(t.ImageCentre.Y)
-	x *= t.LayerScale
-	y *= t.LayerScale
-	x += t.DeviceCentre.X
-	y += t.DeviceCentre.Y
-	p := fyne.NewPos(x, y)
+	p := t.projection().ImageToDevice(ImagePoint{float64(P.X), float64(P.Y)})
 	return &p, nil
 }
 
@@ -149,16 +148,16 @@ func (t *PyramidTransform) FromDevice(M fyne.Position) (*image.Point, error) {
 	if t.DeviceCentre == nil {
 		return nil, errors.New("transform not ready")
 	}
-	x := M.X - t.DeviceCentre.X
-	y := M.Y - t.DeviceCentre.Y
-	x /= t.LayerScale
-	y /= t.LayerScale
-	x += float32(t.ImageCentre.X)
-	y += float32(t.ImageCentre.Y)
-	p := image.Pt(int(x+.5), int(y+.5))
+	q := t.projection().DeviceToImage(M)
+	p := image.Pt(int(q.X+.5), int(q.Y+.5))
 	return &p, nil
 }
 
+// the transform as a projection of its current layer, whose pixels it works in
+func (t *PyramidTransform) projection() Projection {
+	return Projection{Image: ImagePoint{float64(t.ImageCentre.X), float64(t.ImageCentre.Y)}, Device: *t.DeviceCentre, Scale: t.LayerScale}
+}
+
 func (t *PyramidTransform) String() string {
 	return fmt.Sprintf("Transform2D: Scale=%.3g\tImage Point: %v\tDevice Point %v (Sensitivity=%d), current layer=%d", t.GlobalScale, t.ImageCentre, t.DeviceCentre, t.Sensitivity, t.CurrentLayer)
 }
@@ -257,6 +256,9 @@ func BestRowsColumns(N int) (int, int) {
 	return bestRows, bestCols
 }
 
+// Datum2D shows an image point at a device point, at a scale
+//
+// Deprecated: use Projection.
 type Datum2D struct {
 	ImageDatum  image.Point
 	DeviceDatum fyne.Position
@@ -264,25 +266,22 @@ type Datum2D struct {
 }
 
 func (t Datum2D) ToImage(pos *fyne.Position) image.Point {
-	a := pos.Subtract(t.DeviceDatum)
-	b := fyne.Position{}
-	b.X = a.X / float32(t.Scale)
-	b.Y = a.Y / float32(t.Scale)
-	C := b.AddXY(float32(t.ImageDatum.X), float32(t.ImageDatum.Y))
+	C := t.projection().DeviceToImage(*pos)
 	return image.Pt(int(C.X), int(C.Y))
 }
 
 func (t Datum2D) ToDevice(pos *fyne.Position) image.Point {
-	a := pos.SubtractXY(float32(t.ImageDatum.X), float32(t.ImageDatum.Y))
-	b := fyne.Position{}
-	b.X = a.X * float32(t.Scale)
-	b.Y = a.Y * float32(t.Scale)
-	C := b.Add(t.DeviceDatum)
-
+	C := t.projection().ImageToDevice(ImagePoint{float64(pos.X), float64(pos.Y)})
 	return image.Pt(int(C.X), int(C.Y))
 }
 
+func (t Datum2D) projection() Projection {
+	return Projection{Image: ImagePoint{float64(t.ImageDatum.X), float64(t.ImageDatum.Y)}, Device: t.DeviceDatum, Scale: t.Scale}
+}
+
 // scale in a transform is DEVICE/IMAGE
+//
+// Deprecated: use Datum, whose ScaleByTick zooms about a point, or Projection.
 type Transform struct {
 	Datum              *Datum2D // define the datum
 	Ticks, Sensitivity int      // mouse or trackpad tracking & sensitivity
@@ -302,9 +301,11 @@ func (t *Transform) Zoom(p fyne.Position, DY float32) {
 	t.Datum.DeviceDatum = p
 }
 
+// loads an image as it was decoded, so 16-bit PNG and TIFF files keep their full range (as image.Gray16 or image.RGBA64).
+// TIFFs the standard decoders cannot read, such as BigTIFFs and float rasters, are read by this package's own reader
 func LoadImage(uri fyne.URI) (*image.Image, error) {
 
-	img, err := imaging.Open(uri.Path(), imaging.AutoOrientation(true))
+	img, err := loadImage(context.Background(), uri)
 	if err != nil {
 		return nil, errors.Wrap(err, "LoadImage")
 	}
@@ -313,6 +314,44 @@ func LoadImage(uri fyne.URI) (*image.Image, error) {
 
 }
 
+// decodes an image file as LoadImage does, giving up as soon as the context is cancelled
+func loadImage(ctx context.Context, uri fyne.URI) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(uri.Path())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := imaging.Decode(contextReader{ctx: ctx, r: f}, imaging.AutoOrientation(true))
+	if err != nil {
+		if ctxerr := ctx.Err(); ctxerr != nil {
+			return nil, ctxerr
+		}
+		tiff, tifferr := decodeTIFF(ctx, uri.Path())
+		if tifferr != nil {
+			return nil, err
+		}
+		img = tiff
+	}
+	return img, nil
+}
+
+// contextReader fails once its context is cancelled, so that a decoder reading from it gives up part way through
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
+
+// loads an image converted to 8-bit NRGBA. Use LoadImage to keep the range of high bit depth images
 func LoadNRGBA(uri fyne.URI) (*image.NRGBA, error) {
 
 	img, err := LoadImage(uri)
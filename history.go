@@ -0,0 +1,126 @@
+package fynewidgets
+
+import (
+	"time"
+
+	"fyne.io/fyne/v2"
+)
+
+const (
+	historyLimit = 100                    // views remembered by each widget
+	historyMerge = 700 * time.Millisecond // changes of the same kind closer than this make one entry
+)
+
+// HistoryStatus is published on the "history:changed" topic whenever the views a PanZoomCanvas remembers, or its
+// place among them, change, so that back and forward buttons can be enabled or disabled.
+type HistoryStatus struct {
+	Source  *PanZoomCanvas // widget whose history it is
+	Back    int            // views that Back can return to
+	Forward int            // views that Forward can go on to
+}
+
+// views visited, the current one last unless the user has gone back
+type viewHistory struct {
+	views  []ViewState
+	at     int       // index of the view shown
+	reason string    // kind of change that made the latest view
+	when   time.Time // and when it was made
+}
+
+// kinds of change that come in bursts: one soon after another of the same kind replaces it, so that a burst of
+// scrolling, or a held arrow key, is one step back
+var historyMerges = map[string]bool{"zoom": true, "resize": true, "pan": true}
+
+// adds a view after the one shown, forgetting any views that were gone back from
+func (h *viewHistory) add(s ViewState, reason string) {
+	now := time.Now()
+	merge := historyMerges[reason] && reason == h.reason && now.Sub(h.when) < historyMerge && h.at == len(h.views)-1 && h.at > 0
+	h.reason, h.when = reason, now
+	if len(h.views) > 0 && h.views[h.at] == s {
+		return
+	}
+	if merge {
+		h.views[h.at] = s
+		return
+	}
+	if len(h.views) > 0 {
+		h.views = h.views[: h.at+1 : h.at+1] // forget the views gone back from
+	}
+	h.views = append(h.views, s)
+	if len(h.views) > historyLimit {
+		h.views = h.views[len(h.views)-historyLimit:]
+	}
+	h.at = len(h.views) - 1
+}
+
+// the view a number of steps back (negative) or forward, if there is one
+func (h *viewHistory) step(n int) (ViewState, bool) {
+	if h.at+n < 0 || h.at+n >= len(h.views) {
+		return ViewState{}, false
+	}
+	h.at += n
+	h.reason = ""
+	return h.views[h.at], true
+}
+
+// remembers the view shown, and tells the application. Called with the widget locked.
+func (p *PanZoomCanvas) rememberView(reason string) {
+	if s, err := p.viewState(); err == nil {
+		p.history.add(s, reason)
+		p.publishHistory()
+	}
+}
+
+// called with the widget locked
+func (p *PanZoomCanvas) publishHistory() {
+	p.bus.PublishAsync("history:changed", p.historyStatus())
+}
+
+// how far the widget can go back and forward
+func (p *PanZoomCanvas) HistoryStatus() HistoryStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.historyStatus()
+}
+
+func (p *PanZoomCanvas) historyStatus() HistoryStatus {
+	if len(p.history.views) == 0 {
+		return HistoryStatus{Source: p}
+	}
+	return HistoryStatus{Source: p, Back: p.history.at, Forward: len(p.history.views) - 1 - p.history.at}
+}
+
+// returns to the previous view, if there is one
+func (p *PanZoomCanvas) Back() bool {
+	return p.goHistory(-1)
+}
+
+// goes on to the next view, after going back
+func (p *PanZoomCanvas) Forward() bool {
+	return p.goHistory(1)
+}
+
+func (p *PanZoomCanvas) goHistory(n int) bool {
+	p.mu.Lock()
+	if p.datum == nil || p.datum.Scale < 0 {
+		p.mu.Unlock()
+		return false
+	}
+	s, ok := p.history.step(n)
+	if ok {
+		p.publishHistory()
+	}
+	p.mu.Unlock()
+	if ok {
+		p.changeView("", func(d *Datum) error { return d.SetViewState(s) })
+	}
+	return ok
+}
+
+// keys pressed with modifiers, such as Alt+Left and Alt+Right to go back and forward, do what the widget's
+// InputBindings say. fyne's standard shortcuts, such as select all and copy, are named by their keys, as Ctrl+A and Ctrl+C.
+func (p *PanZoomCanvas) TypedShortcut(shortcut fyne.Shortcut) {
+	if s, ok := shortcut.(fyne.KeyboardShortcut); ok {
+		p.perform(p.action(KeyInput(s.Key(), s.Mod())), p.centre())
+	}
+}
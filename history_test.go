@@ -0,0 +1,40 @@
+package fynewidgets
+
+import "testing"
+
+func TestViewHistory(t *testing.T) {
+	var h viewHistory
+	view := func(ticks int) ViewState { return ViewState{Width: 100, Height: 100, Ticks: ticks} }
+	h.add(view(0), "")
+	for ticks := 1; ticks <= 5; ticks++ { // a burst of scrolling
+		h.add(view(ticks), "zoom")
+	}
+	h.add(view(5), "drag") // no change
+	h.add(view(6), "drag")
+	if len(h.views) != 3 || h.at != 2 {
+		t.Fatalf("%d views, at %d, want 3 and 2", len(h.views), h.at)
+	}
+	if s, ok := h.step(-1); !ok || s.Ticks != 5 {
+		t.Errorf("back went to %d ticks, want the end of the scrolling", s.Ticks)
+	}
+	if s, ok := h.step(-1); !ok || s.Ticks != 0 {
+		t.Errorf("back again went to %d ticks, want the start", s.Ticks)
+	}
+	if _, ok := h.step(-1); ok {
+		t.Error("went back past the start")
+	}
+	h.step(1)
+	h.add(view(9), "zoom") // forgets the drag
+	if len(h.views) != 3 || h.views[2].Ticks != 9 {
+		t.Errorf("views are %v after going back and zooming", h.views)
+	}
+	if _, ok := h.step(1); ok {
+		t.Error("went forward past the end")
+	}
+	for i := 0; i < 2*historyLimit; i++ {
+		h.add(view(i+10), "drag")
+	}
+	if len(h.views) != historyLimit || h.at != historyLimit-1 {
+		t.Errorf("%d views kept, want %d", len(h.views), historyLimit)
+	}
+}
@@ -1,22 +1,44 @@
 package fynewidgets
 
 import (
+	"context"
 	"fmt"
 	"image"
-	"image/draw"
 	"math"
 
 	"fyne.io/fyne/v2"
 	"github.com/pkg/errors"
 )
 
+// ImagePoint is a position in full-resolution image pixels, kept to a fraction of a pixel. Pixel x,y covers x to x+1
+// and y to y+1, so its centre is at x+.5, y+.5
+type ImagePoint struct {
+	X, Y float64
+}
+
+// the pixel that contains the point
+func (p ImagePoint) Pixel() image.Point {
+	return image.Pt(int(math.Floor(p.X)), int(math.Floor(p.Y)))
+}
+
+func (p ImagePoint) String() string {
+	return fmt.Sprintf("(%.2f,%.2f)", p.X, p.Y)
+}
+
 type Datum struct {
-	ImageCoords  *image.Point   // pixel location
-	DeviceCoords *fyne.Position // device location of image pixel
-	Scale        float32        // scale is DEVICE:IMAGE
-	Ticks        int            // mouse or trackpad tracking, distance from zero (positive or negative) - creates discrete, repeatable levels of scaling
-	Sensitivity  int            // scroll sensitivity, in ticks per octave
-	Pyramid      *Pyramid       // pyramid of images with an associated current level
+	ImageCoords   *ImagePoint       // full-resolution image location shown at DeviceCoords
+	DeviceCoords  *fyne.Position    // device location of image pixel
+	Scale         float32           // scale is DEVICE:IMAGE
+	Ticks         int               // mouse or trackpad tracking, distance from zero (positive or negative) - creates discrete, repeatable levels of scaling
+	Sensitivity   int               // scroll sensitivity, in ticks per octave
+	Pyramid       *Pyramid          // pyramid of images, which may be shared with other datums
+	Rotation      float64           // degrees clockwise that the image is turned on the device, from 0 up to 360
+	Mirrored      bool              // image is flipped left to right before it is rotated
+	Constraints   ViewConstraints   // limits on zooming and panning
+	PixelRatio    float32           // physical pixels per device unit, as fyne's canvas scale, or 0 for 1
+	Interpolation ViewInterpolation // how the view is drawn from the pixels of a level
+	device        fyne.Size         // last size fitted to, which the constraints apply to
+	level         int               // pyramid level shown, which suits the scale and pixel ratio
 }
 
 func (d Datum) String() string {
@@ -24,116 +46,307 @@ func (d Datum) String() string {
 		return "no datum has been set yet"
 	}
 
-	a := fmt.Sprintf("Datum -----------------------\nDevice: %.1f,%.1f\nImage:  %d,%d\nScale:  %.2f (%d ticks @ %d ticks per octave)\n", d.DeviceCoords.X, d.DeviceCoords.Y, d.ImageCoords.X, d.ImageCoords.Y, d.Scale, d.Ticks, d.Sensitivity)
+	a := fmt.Sprintf("Datum -----------------------\nDevice: %.1f,%.1f\nImage:  %.2f,%.2f\nScale:  %.2f (%d ticks @ %d ticks per octave)\nTurn:   %.1f degrees, mirrored %t\n", d.DeviceCoords.X, d.DeviceCoords.Y, d.ImageCoords.X, d.ImageCoords.Y, d.Scale, d.Ticks, d.Sensitivity, d.Rotation, d.Mirrored)
 	a += d.Pyramid.String()
 	return a
 
 }
 
+// a copy of the datum that can be read while the datum changes. It shares the pyramid.
+func (d *Datum) clone() *Datum {
+	c := *d
+	if d.ImageCoords != nil {
+		image := *d.ImageCoords
+		c.ImageCoords = &image
+	}
+	if d.DeviceCoords != nil {
+		device := *d.DeviceCoords
+		c.DeviceCoords = &device
+	}
+	return &c
+}
+
 func NewDatum(img image.Image, smallestsize image.Point, scrollsensitivity int) (*Datum, error) {
-	p, err := NewPyramid(img, smallestsize)
+	return NewDatumWithOptions(img, smallestsize, scrollsensitivity, DefaultPyramidOptions())
+}
+
+// creates a datum as NewDatum does, with control over how its pyramid is made (resampling filter, linear light, tiling)
+func NewDatumWithOptions(img image.Image, smallestsize image.Point, scrollsensitivity int, options PyramidOptions) (*Datum, error) {
+	p, err := NewPyramidWithOptions(img, smallestsize, options)
 	if err != nil {
 		return nil, errors.Wrap(err, "f: NewDatum")
 	}
-	return &Datum{Scale: -1, Pyramid: p, Sensitivity: scrollsensitivity}, nil
+	return NewDatumFromPyramid(p, scrollsensitivity), nil
 
 }
 
+// creates a datum for an image that is already tiled, fetching tiles from the source only as they are shown
+func NewDatumFromTileSource(source TileSource, smallestsize image.Point, scrollsensitivity int, options PyramidOptions) (*Datum, error) {
+	p, err := NewPyramidFromTileSource(source, smallestsize, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "f: NewDatum")
+	}
+	return NewDatumFromPyramid(p, scrollsensitivity), nil
+}
+
+// creates a datum for an existing pyramid, for instance one made from a file with NewPyramidFromFile
+func NewDatumFromPyramid(p *Pyramid, scrollsensitivity int) *Datum {
+	return &Datum{Scale: -1, Pyramid: p, Sensitivity: scrollsensitivity, Constraints: DefaultViewConstraints(), level: p.Height() - 1}
+}
+
+// the pyramid level the datum shows. Each datum keeps its own, so datums sharing a pyramid can show different levels.
+func (d *Datum) Level() int {
+	return d.level
+}
+
 // FitDevice resizes the image to fit the device by changing its datum.
 func (d *Datum) FitDevice(size fyne.Size) error {
 	if d == nil {
 		return errors.New("No datum yet - nil")
 	}
 
-	SIZE := d.Pyramid.images[0].Bounds()                                        // maximum extent of image
-	scale := min(size.Width/float32(SIZE.Dx()), size.Height/float32(SIZE.Dy())) // scale is DEVICE:IMAGE, and smaller of the two in order to fit the screen
-	ticks := FloatScaleToTicks(scale, d.Sensitivity)                            // convert scale to integer ticks
-	scale = TickScaleToFloatScale(ticks, d.Sensitivity)                         // convert ticks back to scale, thus creating discrete levels of zoom that are repeatable
-	mid := fyne.NewPos(size.Width/2, size.Height/2)                             // centre of device
-	MID := image.Pt(SIZE.Dx()/2, SIZE.Dy()/2)                                   // centre of image
-	d.Pyramid.level = d.levelForScale(scale)
+	SIZE := d.Pyramid.Bounds(0)                     // maximum extent of image
+	wx, wy := d.toDeviceAxes(float64(SIZE.Dx()), 0) // width and height of the image as it is turned on the device
+	hx, hy := d.toDeviceAxes(0, float64(SIZE.Dy()))
+	W, H := float32(math.Abs(wx)+math.Abs(hx)), float32(math.Abs(wy)+math.Abs(hy))
+	scale := min(size.Width/W, size.Height/H) // scale is DEVICE:IMAGE, and smaller of the two in order to fit the screen
+	d.device = size
+	ticks := d.constrainTicks(FloatScaleToTicks(scale, d.Sensitivity)) // convert scale to integer ticks, within the constraints
+	scale = TickScaleToFloatScale(ticks, d.Sensitivity)                // convert ticks back to scale, thus creating discrete levels of zoom that are repeatable
+	mid := fyne.NewPos(size.Width/2, size.Height/2)                    // centre of device
+	MID := ImagePoint{float64(SIZE.Dx()) / 2, float64(SIZE.Dy()) / 2}  // centre of image
+	d.level = d.levelForScale(scale)
 	d.Scale = scale
 	d.DeviceCoords = &mid
 	d.ImageCoords = &MID
 	d.Ticks = ticks
+	d.Constrain()
 
 	return nil
 }
 
-func (d *Datum) TransformDeviceToImage(devicepoint fyne.Position) (*image.Point, error) {
+// the projection the datum shows. Its anchor is zero until the datum has been fitted to a device.
+func (d *Datum) Projection() Projection {
+	p := Projection{Scale: d.Scale, Rotation: d.Rotation, Mirrored: d.Mirrored, PixelRatio: d.PixelRatio}
+	if d.ImageCoords != nil {
+		p.Image = *d.ImageCoords
+	}
+	if d.DeviceCoords != nil {
+		p.Device = *d.DeviceCoords
+	}
+	return p
+}
+
+// the full-resolution image position shown at a device position, not rounded to a pixel
+func (d *Datum) DeviceToImage(devicepoint fyne.Position) (ImagePoint, error) {
 	if d.Pyramid == nil {
-		return nil, errors.New("f:ImagePoint - no pyramid")
+		return ImagePoint{}, errors.New("f:ImagePoint - no pyramid")
 	}
 	if d.Scale < 0 {
-		return nil, errors.New("f:ImagePoint - no scale")
+		return ImagePoint{}, errors.New("f:ImagePoint - no scale")
 	}
-	power := float32(math.Pow(2, float64(d.Pyramid.level)))                     // calculate pyramid level scalar - each layer's dimensions are half that of the previous
-	P := devicepoint.Subtract(d.DeviceCoords)                                   // shift device point to origin
-	s := fyne.NewPos(P.X/d.Scale, P.Y/d.Scale)                                  // scale
-	s = fyne.NewPos(s.X+float32(d.ImageCoords.X), s.Y+float32(d.ImageCoords.Y)) // translate origin to image point
-	s = fyne.NewPos(s.X/power+.5, s.Y/power+.5)                                 // nearest integer pixel needs an offset before truncation
-	Q := image.Pt(int(s.X), int(s.Y))                                           // truncate to integer
+	return d.Projection().DeviceToImage(devicepoint), nil
+}
 
-	return &Q, nil
+// the device position at which a full-resolution image position is shown
+func (d *Datum) ImageToDevice(imagepoint ImagePoint) (fyne.Position, error) {
+	if d.Scale < 0 {
+		return fyne.Position{}, errors.New("f:DevicePoint - no scale")
+	}
+	return d.Projection().ImageToDevice(imagepoint), nil
 }
-func (d *Datum) TransformDeviceToFullImage(devicepoint fyne.Position) (*image.Point, error) {
-	if d.Pyramid == nil {
-		return nil, errors.New("f:ImagePoint - no pyramid")
+
+// turns a distance along the image's axes into one along the device's
+func (d *Datum) toDeviceAxes(x, y float64) (float64, float64) {
+	return d.Projection().toDeviceAxes(x, y)
+}
+
+// turns a distance along the device's axes into one along the image's
+func (d *Datum) toImageAxes(x, y float64) (float64, float64) {
+	return d.Projection().toImageAxes(x, y)
+}
+
+// true if the image is neither rotated nor mirrored
+func (d *Datum) upright() bool {
+	return d.Projection().Upright()
+}
+
+// turns the image clockwise on the device by some degrees (anticlockwise if negative) about a device position
+func (d *Datum) Rotate(centre fyne.Position, degrees float64) error {
+	return d.reorient(centre, func() {
+		d.Rotation = math.Mod(math.Mod(d.Rotation+degrees, 360)+360, 360)
+	})
+}
+
+// mirrors the image left to right on the device, about a device position
+func (d *Datum) FlipHorizontal(centre fyne.Position) error {
+	return d.reorient(centre, func() { // flipping the device's x axis reverses the rotation
+		d.Rotation = math.Mod(360-d.Rotation, 360)
+		d.Mirrored = !d.Mirrored
+	})
+}
+
+// mirrors the image top to bottom on the device, about a device position
+func (d *Datum) FlipVertical(centre fyne.Position) error {
+	return d.reorient(centre, func() { // a vertical flip is a horizontal one turned half way round
+		d.Rotation = math.Mod(540-d.Rotation, 360)
+		d.Mirrored = !d.Mirrored
+	})
+}
+
+// changes the orientation, keeping the image position under a device position where it is
+func (d *Datum) reorient(centre fyne.Position, change func()) error {
+	ip, err := d.DeviceToImage(centre)
+	if err != nil {
+		return errors.Wrap(err, "DeviceToImage, in Datum.reorient")
 	}
-	if d.Scale < 0 {
-		return nil, errors.New("f:ImagePoint - no scale")
+	change()
+	d.ImageCoords = &ip
+	d.DeviceCoords = &centre
+	d.Constrain()
+	return nil
+}
+
+// the pixel of the current pyramid level shown at a device position
+func (d *Datum) TransformDeviceToImage(devicepoint fyne.Position) (*image.Point, error) {
+	P, err := d.DeviceToImage(devicepoint)
+	if err != nil {
+		return nil, err
 	}
-	P := devicepoint.Subtract(d.DeviceCoords)                                   // shift device point to origin
-	s := fyne.NewPos(P.X/d.Scale, P.Y/d.Scale)                                  // scale
-	s = fyne.NewPos(s.X+float32(d.ImageCoords.X), s.Y+float32(d.ImageCoords.Y)) // translate origin to image point
-	s = fyne.NewPos(s.X+.5, s.Y+.5)                                             // nearest integer pixel needs an offset before truncation
-	Q := image.Pt(int(s.X), int(s.Y))                                           // truncate to integer
+	Q := P.ToLevel(d.level).Pixel()
+	return &Q, nil
+}
 
+// the full-resolution pixel shown at a device position
+func (d *Datum) TransformDeviceToFullImage(devicepoint fyne.Position) (*image.Point, error) {
+	P, err := d.DeviceToImage(devicepoint)
+	if err != nil {
+		return nil, err
+	}
+	Q := P.Pixel()
 	return &Q, nil
 }
 
 // gets the image to be displayed using this datum, from the pyramid
 func (d *Datum) GetCurrentImage(size fyne.Size) (*image.NRGBA, int, error) {
 
-	w := size.Width                         // REDRAWING THE OUTPUT
-	h := size.Height                        // dimensions of canvas
-	tl := fyne.NewPos(0, 0)                 // top left in device coordinates
-	TL, err := d.TransformDeviceToImage(tl) // top left in image coordinates
+	rSource, err := d.ViewRect(size)
+	if err != nil {
+		return nil, 0, err
+	}
+	nrgba, err := d.Pyramid.Region(d.level, rSource) // assemble the pixels to be drawn on screen from the tiles of the current pyramid level that they touch
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Getting sub-image from pyramid")
+	}
+
+	view, err := d.placeView(context.Background(), nrgba, rSource, d.level, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	return view, rSource.Dx() * rSource.Dy(), nil
+}
+
+// gets the image to be displayed as GetCurrentImage does, but without waiting for tiles to be made. If the current level
+// is not ready, the finest coarser level that is ready is used instead, so the image has fewer pixels than the device and
+// must be stretched to fill it. The level used is returned with the image and its pixel count.
+func (d *Datum) GetAvailableImage(size fyne.Size) (*image.NRGBA, int, int, error) {
+	return d.availableImage(context.Background(), size, d.level)
+}
+
+// the image GetAvailableImage gives when the wanted level is the one given rather than the datum's, giving up between
+// tiles and between strips of the view if the context is cancelled
+func (d *Datum) availableImage(ctx context.Context, size fyne.Size, wanted int) (*image.NRGBA, int, int, error) {
+	rSource, err := d.viewRect(size, wanted)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	level := wanted
+	r := rSource
+	for level < d.Pyramid.Height()-1 && !d.Pyramid.Ready(level, r) { // step down the pyramid until a level is ready, or the coarsest is reached
+		level++
+		tl, br, _ := d.viewCorners(size, level)
+		r = coveringRect(tl, br)
+	}
+	nrgba, err := d.Pyramid.displayRegion(ctx, level, r) // the coarsest level is made if nothing is ready at all
+	if err != nil {
+		return nil, 0, 0, errors.Wrap(err, "Getting sub-image from pyramid")
+	}
+	view, err := d.placeView(ctx, nrgba, r, level, size)
 	if err != nil {
-		return nil, 0, errors.Wrap(err, "Getting sub-image at TL")
+		return nil, 0, 0, err
 	}
-	br := fyne.NewPos(w, h)                 // bottom right in device
-	BR, err := d.TransformDeviceToImage(br) // bottom right in image coordinates
+	return view, level, r.Dx() * r.Dy(), nil
+}
+
+// rectangle of the current pyramid level that covers a device of the given size
+func (d *Datum) ViewRect(size fyne.Size) (image.Rectangle, error) {
+	return d.viewRect(size, d.level)
+}
+
+// rectangle of a level that covers a device of the given size
+func (d *Datum) viewRect(size fyne.Size, level int) (image.Rectangle, error) {
+	TL, BR, err := d.viewCorners(size, level)
 	if err != nil {
-		return nil, 0, errors.Wrap(err, "Getting sub-image at BR")
+		return image.Rectangle{}, errors.Wrap(err, "Getting sub-image")
+	}
+	rSource := coveringRect(TL, BR) // whole pixels covering image coordinates of canvas corners
+	if rSource.Dx() > maxViewPixels || rSource.Dy() > maxViewPixels || rSource.Dx() <= 1 || rSource.Dy() <= 1 {
+		return image.Rectangle{}, errors.New("image too big")
 	}
-	rSource := image.Rectangle{*TL, *BR} // rectangle covering image coordinates of canvas corners
-	rDest := rSource.Sub(*TL)            // move the rectangle so its TL is at 0,0 - the TL requested will be at the NW corner
-	if rDest.Dx() > 10000 || rDest.Dy() > 10000 || rDest.Dx() <= 1 || rDest.Dy() <= 1 {
-		return nil, 0, errors.New("image too big")
+	return rSource, nil
+}
+
+// image coordinates of the top left and bottom right corners of the smallest upright area of a level that holds
+// everything a device of the given size shows, in that level's pixels
+func (d *Datum) viewCorners(size fyne.Size, level int) (ImagePoint, ImagePoint, error) {
+	if _, err := d.DeviceToImage(fyne.Position{}); err != nil {
+		return ImagePoint{}, ImagePoint{}, err
 	}
-	nrgba := image.NewNRGBA(rDest)                    // create something the right size, ie, as big as the pictures that will be on the screen
-	imageSrc := d.Pyramid.images[d.Pyramid.level]     // take the pixels to be drawn on screen from the current pyramid level
-	draw.Draw(nrgba, rDest, imageSrc, *TL, draw.Over) // draw to the new image from the origin to the size of the requested image, the base image beginning at the requested top left.
+	tl, br := d.Projection().VisibleCorners(size, level)
+	return tl, br, nil
+}
 
-	return nrgba, rSource.Dx() * rSource.Dy(), nil
+// smallest rectangle of whole pixels that covers the area between two corners
+func coveringRect(tl, br ImagePoint) image.Rectangle {
+	return image.Rect(int(math.Floor(tl.X)), int(math.Floor(tl.Y)), int(math.Ceil(br.X)), int(math.Ceil(br.Y)))
+}
+
+// the pixels of a region r of a level, drawn to fill a device of the given size at its physical resolution, with each
+// pixel exactly where the projection puts it and interpolated as the datum says. The view then looks the same whatever
+// the scale within a level, and whatever the graphics driver would have done to stretch r. Gives up if the context is cancelled.
+func (d *Datum) placeView(ctx context.Context, region *image.NRGBA, r image.Rectangle, level int, size fyne.Size) (*image.NRGBA, error) {
+	ratio := d.pixelRatio()
+	w, h := int(math.Round(float64(size.Width*ratio))), int(math.Round(float64(size.Height*ratio)))
+	projection := d.Projection()
+	at := func(x, y float32) ImagePoint { // level position shown at a device position
+		return projection.DeviceToLevel(fyne.NewPos(x, y), level)
+	}
+	origin := at(0, 0)
+	across, down := at(1/ratio, 0), at(0, 1/ratio)
+	u := ImagePoint{across.X - origin.X, across.Y - origin.Y} // level pixels per physical pixel, along each device axis
+	v := ImagePoint{down.X - origin.X, down.Y - origin.Y}
+	if w < 1 || h < 1 {
+		return region, nil
+	}
+	return sampleViewContext(ctx, region, r, origin, u, v, w, h, d.Interpolation)
 }
 
 // change the projection in response to a change of datum or scale. Most often used in mouse-centred zoom, or in panning
 func (d *Datum) ChangeProjection(p fyne.Position, scalerequested float32) error {
-	ip, err := d.TransformDeviceToFullImage(p)
+	ip, err := d.DeviceToImage(p) // kept to a fraction of a pixel, so that the image stays under the mouse however often it is zoomed
 	if err != nil {
-		return errors.Wrap(err, "TransformDeviceToFullImage, in Datum.ChangeProjection")
+		return errors.Wrap(err, "DeviceToImage, in Datum.ChangeProjection")
 	}
 
-	ticks := FloatScaleToTicks(scalerequested, d.Sensitivity) // convert to discrete ticks
-	newscale := TickScaleToFloatScale(ticks, d.Sensitivity)   // convert back to float
+	ticks := d.constrainTicks(FloatScaleToTicks(scalerequested, d.Sensitivity)) // convert to discrete ticks, within the constraints
+	newscale := TickScaleToFloatScale(ticks, d.Sensitivity)                     // convert back to float
 
-	d.DeviceCoords = &p                         // update device coordinates
-	d.ImageCoords = ip                          // update image coordinates
-	d.Scale = newscale                          // update scale
-	d.Ticks = ticks                             // update ticks
-	d.Pyramid.level = d.levelForScale(newscale) // update level
+	d.DeviceCoords = &p                 // update device coordinates
+	d.ImageCoords = &ip                 // update image coordinates
+	d.Scale = newscale                  // update scale
+	d.Ticks = ticks                     // update ticks
+	d.level = d.levelForScale(newscale) // update level
+	d.Constrain()
 
 	return nil
 }
@@ -149,20 +362,53 @@ func (d *Datum) ScaleByTick(p fyne.Position, delta float32) error {
 	return d.ChangeProjection(p, newscale)
 }
 
+// changes the projection as ChangeProjection does, but to exactly the scale requested rather than the nearest tick,
+// so long as it lies within the constraints. Ticks still go to the nearest, so that the next step carries on from there
+func (d *Datum) setScale(p fyne.Position, scalerequested float32) error {
+	if err := d.ChangeProjection(p, scalerequested); err != nil {
+		return err
+	}
+	lowest, highest := d.tickLimits()
+	if scalerequested < TickScaleToFloatScale(lowest, d.Sensitivity) || scalerequested > TickScaleToFloatScale(highest, d.Sensitivity) {
+		return nil
+	}
+	d.Scale = scalerequested
+	d.level = d.levelForScale(scalerequested)
+	d.Constrain()
+	return nil
+}
+
 // multiply current scale by the requested factor (good for doubling size, etc)
 func (d *Datum) ChangeScale(factor float32) error {
 
 	scalerequested := d.Scale * factor
-	ticks := FloatScaleToTicks(scalerequested, d.Sensitivity) // convert to discrete ticks
-	newscale := TickScaleToFloatScale(ticks, d.Sensitivity)   // convert back to float
+	ticks := d.constrainTicks(FloatScaleToTicks(scalerequested, d.Sensitivity)) // convert to discrete ticks, within the constraints
+	newscale := TickScaleToFloatScale(ticks, d.Sensitivity)                     // convert back to float
 
-	d.Scale = newscale                         // update scale
-	d.Ticks = ticks                            // update ticks
-	d.Pyramid.level = d.levelForScale(d.Scale) // update level
+	d.Scale = newscale                 // update scale
+	d.Ticks = ticks                    // update ticks
+	d.level = d.levelForScale(d.Scale) // update level
+	d.Constrain()
 	return nil
 }
 
+// physical pixels per device unit
+func (d *Datum) pixelRatio() float32 {
+	if d.PixelRatio <= 0 {
+		return 1
+	}
+	return d.PixelRatio
+}
+
+// sets the physical pixels per device unit, and the level that suits them
+func (d *Datum) SetPixelRatio(ratio float32) {
+	d.PixelRatio = ratio
+	if d.Scale > 0 && d.Pyramid != nil {
+		d.level = d.levelForScale(d.Scale)
+	}
+}
+
+// level with about one pixel per physical pixel of the device at a scale
 func (d *Datum) levelForScale(scale float32) int {
-	level := -int(math.Log2(float64(scale)) + .31)
-	return min(max(level, 0), d.Pyramid.Height()-1) // constrain level to what is available in the pyramid
+	return Projection{Scale: scale, PixelRatio: d.PixelRatio}.Level(d.Pyramid.Height()) // constrained to what is available in the pyramid
 }
@@ -0,0 +1,179 @@
+package fynewidgets
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+func testDatum(t *testing.T) *Datum {
+	p, err := NewPyramidWithOptions(testImage(3001, 2001), image.Pt(20, 20), PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDatumFromPyramid(p, 5)
+	if err := d.FitDevice(fyne.NewSize(640, 480)); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDatumRoundTrip(t *testing.T) {
+	d := testDatum(t)
+	for _, ticks := range []int{-12, -5, 0, 3, 7, 20, 33} {
+		if err := d.ChangeProjection(fyne.NewPos(211.25, 137.5), TickScaleToFloatScale(ticks, d.Sensitivity)); err != nil {
+			t.Fatal(err)
+		}
+		for _, device := range []fyne.Position{{X: 0, Y: 0}, {X: 0.5, Y: 0.25}, {X: 319.75, Y: 240.125}, {X: 640, Y: 480}} {
+			img, err := d.DeviceToImage(device)
+			if err != nil {
+				t.Fatal(err)
+			}
+			back, err := d.ImageToDevice(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(float64(back.X-device.X)) > 1e-3 || math.Abs(float64(back.Y-device.Y)) > 1e-3 {
+				t.Errorf("%d ticks: %v went to %v and back to %v", ticks, device, img, back)
+			}
+		}
+	}
+}
+
+func TestDatumRepeatedZoom(t *testing.T) {
+	d := testDatum(t)
+	mouse := fyne.NewPos(401.5, 123.25)
+	want, _ := d.DeviceToImage(mouse)
+	start := d.Ticks
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 30; i++ {
+			d.ScaleByTick(mouse, 1)
+		}
+		for i := 0; i < 30; i++ {
+			d.ScaleByTick(mouse, -1)
+		}
+	}
+	if d.Ticks != start {
+		t.Fatalf("ticks are %d, want %d", d.Ticks, start)
+	}
+	got, _ := d.DeviceToImage(mouse)
+	if math.Abs(got.X-want.X) > 1e-6 || math.Abs(got.Y-want.Y) > 1e-6 {
+		t.Errorf("image under the mouse drifted from %v to %v", want, got)
+	}
+}
+
+func TestDatumMagnifiedView(t *testing.T) {
+	d := testDatum(t)
+	size := fyne.NewSize(200, 150)
+	if err := d.ChangeProjection(fyne.NewPos(100.3, 75.6), TickScaleToFloatScale(17, d.Sensitivity)); err != nil { // about 10 device pixels per image pixel
+		t.Fatal(err)
+	}
+	d.ImageCoords = &ImagePoint{1000.37, 700.81}
+	img, _, err := d.GetCurrentImage(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Size() != image.Pt(200, 150) {
+		t.Fatalf("magnified view is %v, want the device size", img.Bounds().Size())
+	}
+	src := testImage(3001, 2001)
+	for _, device := range []image.Point{{0, 0}, {37, 91}, {100, 75}, {199, 149}} {
+		at, _ := d.DeviceToImage(fyne.NewPos(float32(device.X)+.5, float32(device.Y)+.5))
+		pixel := at.Pixel()
+		if img.NRGBAAt(device.X, device.Y) != src.NRGBAAt(pixel.X, pixel.Y) {
+			t.Errorf("device pixel %v shows %v, want image pixel %v", device, img.NRGBAAt(device.X, device.Y), pixel)
+		}
+	}
+}
+
+func TestDatumOrientation(t *testing.T) {
+	src := testImage(3001, 2001)
+	size := fyne.NewSize(160, 120)
+	for _, orientation := range []struct {
+		rotation float64
+		mirrored bool
+	}{{0, true}, {90, false}, {180, true}, {270, false}, {30, false}, {315, true}} {
+		d := testDatum(t)
+		if err := d.ChangeProjection(fyne.NewPos(80, 60), 1); err != nil {
+			t.Fatal(err)
+		}
+		d.ImageCoords = &ImagePoint{1500.25, 1000.75}
+		d.Rotation, d.Mirrored = orientation.rotation, orientation.mirrored
+		for _, device := range []fyne.Position{{X: 0, Y: 0}, {X: 17.5, Y: 99.25}, {X: 160, Y: 120}} {
+			img, _ := d.DeviceToImage(device)
+			back, _ := d.ImageToDevice(img)
+			if math.Abs(float64(back.X-device.X)) > 1e-3 || math.Abs(float64(back.Y-device.Y)) > 1e-3 {
+				t.Errorf("%v: %v went to %v and back to %v", orientation, device, img, back)
+			}
+		}
+		view, _, err := d.GetCurrentImage(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, device := range []image.Point{{0, 0}, {159, 0}, {40, 77}, {159, 119}} {
+			at, _ := d.DeviceToImage(fyne.NewPos(float32(device.X)+.5, float32(device.Y)+.5))
+			pixel := at.Pixel()
+			if view.NRGBAAt(device.X, device.Y) != src.NRGBAAt(pixel.X, pixel.Y) {
+				t.Errorf("%v: device pixel %v does not show image pixel %v", orientation, device, pixel)
+			}
+		}
+	}
+}
+
+func TestDatumFlips(t *testing.T) {
+	d := testDatum(t)
+	centre := fyne.NewPos(320, 240)
+	start, _ := d.DeviceToImage(fyne.NewPos(100, 50))
+	d.Rotate(centre, 90) // flips are about the device's axes, whatever the rotation
+	before, _ := d.DeviceToImage(fyne.NewPos(100, 50))
+	d.FlipHorizontal(centre)
+	after, _ := d.DeviceToImage(fyne.NewPos(540, 50))
+	if math.Abs(after.X-before.X) > 1e-6 || math.Abs(after.Y-before.Y) > 1e-6 {
+		t.Errorf("horizontal flip moved %v to %v", before, after)
+	}
+	d.FlipVertical(centre)
+	after, _ = d.DeviceToImage(fyne.NewPos(540, 430))
+	if math.Abs(after.X-before.X) > 1e-6 || math.Abs(after.Y-before.Y) > 1e-6 {
+		t.Errorf("vertical flip moved %v to %v", before, after)
+	}
+	d.FlipVertical(centre)
+	d.FlipHorizontal(centre)
+	for i := 0; i < 3; i++ {
+		d.Rotate(centre, 90)
+	}
+	if d.Rotation != 0 || d.Mirrored {
+		t.Fatalf("turned %v degrees, mirrored %t, after flipping back and a full turn", d.Rotation, d.Mirrored)
+	}
+	if got, _ := d.DeviceToImage(fyne.NewPos(100, 50)); math.Abs(got.X-start.X) > 1e-6 || math.Abs(got.Y-start.Y) > 1e-6 {
+		t.Errorf("image moved from %v to %v", start, got)
+	}
+}
+
+func TestDatumPixelRatio(t *testing.T) {
+	d := testDatum(t)
+	src := testImage(3001, 2001)
+	size := fyne.NewSize(200, 150)
+	d.ChangeProjection(fyne.NewPos(100, 75), .25)
+	plain := d.Level()
+	d.SetPixelRatio(2)
+	if d.Level() != plain-1 {
+		t.Errorf("level %d at twice the pixels, want %d", d.Level(), plain-1)
+	}
+	d.ChangeProjection(fyne.NewPos(100, 75), 3) // magnified, so drawn at physical resolution
+	img, _, err := d.GetCurrentImage(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Size() != image.Pt(400, 300) {
+		t.Fatalf("view is %v, want twice the device size", img.Bounds().Size())
+	}
+	for _, physical := range []image.Point{{0, 0}, {123, 45}, {399, 299}} {
+		at, _ := d.DeviceToImage(fyne.NewPos((float32(physical.X)+.5)/2, (float32(physical.Y)+.5)/2))
+		pixel := at.Pixel()
+		if img.NRGBAAt(physical.X, physical.Y) != src.NRGBAAt(pixel.X, pixel.Y) {
+			t.Errorf("physical pixel %v does not show image pixel %v", physical, pixel)
+		}
+	}
+}
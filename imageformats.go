@@ -0,0 +1,355 @@
+package fynewidgets
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// PixelFormat is the type of image a pyramid keeps its tiles in. 8-bit images are kept as NRGBA, while 16-bit and
+// floating point images keep their full range, and are converted to 8 bits only for display.
+type PixelFormat int
+
+const (
+	FormatNRGBA   PixelFormat = iota // 8 bits per channel, colour with alpha
+	FormatGray16                     // 16-bit grey
+	FormatNRGBA64                    // 16 bits per channel, colour with alpha
+	FormatGray32f                    // 32-bit floating point grey
+)
+
+func (f PixelFormat) String() string {
+	switch f {
+	case FormatGray16:
+		return "gray16"
+	case FormatNRGBA64:
+		return "nrgba64"
+	case FormatGray32f:
+		return "gray32f"
+	}
+	return "nrgba"
+}
+
+// the format that keeps all the information in an image
+func pixelFormatOf(img image.Image) PixelFormat {
+	switch img.(type) {
+	case *image.Gray16:
+		return FormatGray16
+	case *image.NRGBA64, *image.RGBA64:
+		return FormatNRGBA64
+	case *Gray32f:
+		return FormatGray32f
+	}
+	return FormatNRGBA
+}
+
+// a new, empty image of this format
+func (f PixelFormat) newImage(r image.Rectangle) draw.Image {
+	switch f {
+	case FormatGray16:
+		return image.NewGray16(r)
+	case FormatNRGBA64:
+		return image.NewNRGBA64(r)
+	case FormatGray32f:
+		return NewGray32f(r)
+	}
+	return image.NewNRGBA(r)
+}
+
+// bytes used by each pixel
+func (f PixelFormat) pixelBytes() int {
+	switch f {
+	case FormatGray16:
+		return 2
+	case FormatNRGBA64:
+		return 8
+	}
+	return 4
+}
+
+// the values that are shown as black and white by default
+func (f PixelFormat) fullRange() (float64, float64) {
+	switch f {
+	case FormatGray16, FormatNRGBA64:
+		return 0, 65535
+	case FormatGray32f:
+		return 0, 1
+	}
+	return 0, 255
+}
+
+// Gray32f is an in-memory image of float32 grey values, for scientific data with more range or precision than 16 bits.
+// Its colour model treats 0 as black and 1 as white, so use a DisplayMapping to see other ranges.
+type Gray32f struct {
+	Pix    []float32
+	Stride int // number of values between vertically adjacent pixels
+	Rect   image.Rectangle
+}
+
+// Gray32fColor is the colour of a Gray32f pixel
+type Gray32fColor struct {
+	Y float32
+}
+
+func (c Gray32fColor) RGBA() (r, g, b, a uint32) {
+	y := uint32(min(max(c.Y, 0), 1)*0xffff + .5)
+	return y, y, y, 0xffff
+}
+
+// converts any colour to a Gray32fColor, using the same luminance weights as color.Gray16Model
+var Gray32fModel color.Model = color.ModelFunc(func(c color.Color) color.Color {
+	if g, ok := c.(Gray32fColor); ok {
+		return g
+	}
+	r, g, b, _ := c.RGBA()
+	y := (19595*r + 38470*g + 7471*b + 1<<15) >> 16
+	return Gray32fColor{float32(y) / 0xffff}
+})
+
+func NewGray32f(r image.Rectangle) *Gray32f {
+	return &Gray32f{Pix: make([]float32, r.Dx()*r.Dy()), Stride: r.Dx(), Rect: r}
+}
+
+func (p *Gray32f) ColorModel() color.Model { return Gray32fModel }
+
+func (p *Gray32f) Bounds() image.Rectangle { return p.Rect }
+
+func (p *Gray32f) At(x, y int) color.Color { return Gray32fColor{p.Gray32fAt(x, y)} }
+
+func (p *Gray32f) Gray32fAt(x, y int) float32 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+	return p.Pix[p.PixOffset(x, y)]
+}
+
+// index of the value of pixel x,y in Pix
+func (p *Gray32f) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x - p.Rect.Min.X)
+}
+
+func (p *Gray32f) Set(x, y int, c color.Color) {
+	p.SetGray32f(x, y, Gray32fModel.Convert(c).(Gray32fColor).Y)
+}
+
+func (p *Gray32f) SetGray32f(x, y int, v float32) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.Pix[p.PixOffset(x, y)] = v
+}
+
+// an image representing part of this one, sharing its values
+func (p *Gray32f) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &Gray32f{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &Gray32f{Pix: p.Pix[i:], Stride: p.Stride, Rect: r}
+}
+
+// DisplayMapping converts pixel values to 8 bits for display. Values at or below Black are shown as black, those at or
+// above White as white, with Gamma applied in between (1 is a straight line, larger values brighten the shadows).
+// Values are in the units of the pyramid's format, eg 0-65535 for 16-bit images.
+type DisplayMapping struct {
+	Black, White float64
+	Gamma        float64
+}
+
+// a mapping that shows the whole range of a format, unchanged for 8-bit images
+func DefaultDisplayMapping(format PixelFormat) DisplayMapping {
+	black, white := format.fullRange()
+	return DisplayMapping{Black: black, White: white, Gamma: 1}
+}
+
+// maps a single value to 8 bits
+func (m DisplayMapping) apply(v float64) uint8 {
+	if m.White == m.Black {
+		if v > m.Black {
+			return 255
+		}
+		return 0
+	}
+	t := min(max((v-m.Black)/(m.White-m.Black), 0), 1)
+	if m.Gamma > 0 && m.Gamma != 1 {
+		t = math.Pow(t, 1/m.Gamma)
+	}
+	return uint8(t*255 + .5)
+}
+
+// converts pixels of any of the pyramid formats to 8-bit NRGBA for display, with the origin of the result at 0,0
+func toDisplay(img image.Image, m DisplayMapping) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(b.Sub(b.Min))
+	var lut [65536]uint8 // 16-bit values to 8 bits
+	if f := pixelFormatOf(img); f == FormatGray16 || f == FormatNRGBA64 {
+		for i := range lut {
+			lut[i] = m.apply(float64(i))
+		}
+	}
+	switch src := img.(type) {
+	case *image.Gray16:
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				v := lut[src.Gray16At(b.Min.X+x, b.Min.Y+y).Y]
+				o := dst.PixOffset(x, y)
+				dst.Pix[o], dst.Pix[o+1], dst.Pix[o+2], dst.Pix[o+3] = v, v, v, 255
+			}
+		}
+	case *image.NRGBA64:
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				c := src.NRGBA64At(b.Min.X+x, b.Min.Y+y)
+				o := dst.PixOffset(x, y)
+				dst.Pix[o], dst.Pix[o+1], dst.Pix[o+2], dst.Pix[o+3] = lut[c.R], lut[c.G], lut[c.B], uint8(c.A>>8)
+			}
+		}
+	case *Gray32f:
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				v := m.apply(float64(src.Gray32fAt(b.Min.X+x, b.Min.Y+y)))
+				o := dst.PixOffset(x, y)
+				dst.Pix[o], dst.Pix[o+1], dst.Pix[o+2], dst.Pix[o+3] = v, v, v, 255
+			}
+		}
+	default:
+		draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+		if m != DefaultDisplayMapping(FormatNRGBA) {
+			var lut [256]uint8
+			for i := range lut {
+				lut[i] = m.apply(float64(i))
+			}
+			for i := 0; i < len(dst.Pix); i += 4 {
+				dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2] = lut[dst.Pix[i]], lut[dst.Pix[i+1]], lut[dst.Pix[i+2]]
+			}
+		}
+	}
+	return dst
+}
+
+// a mapping that stretches the values of an image between two fractions of its histogram, eg 0.001 and 0.999,
+// so that a few extreme pixels do not leave the rest of the image grey
+func stretchMapping(img image.Image, format PixelFormat, low, high float64) (DisplayMapping, error) {
+	b := img.Bounds()
+	values := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			switch src := img.(type) {
+			case *image.Gray16:
+				values = append(values, float64(src.Gray16At(x, y).Y))
+			case *image.NRGBA64:
+				c := src.NRGBA64At(x, y)
+				values = append(values, float64(max(c.R, c.G, c.B)))
+			case *Gray32f:
+				v := float64(src.Gray32fAt(x, y))
+				if !math.IsNaN(v) && !math.IsInf(v, 0) {
+					values = append(values, v)
+				}
+			case *image.NRGBA:
+				c := src.NRGBAAt(x, y)
+				values = append(values, float64(max(c.R, c.G, c.B)))
+			}
+		}
+	}
+	if len(values) == 0 {
+		return DefaultDisplayMapping(format), errors.New("no values to stretch")
+	}
+	sort.Float64s(values)
+	at := func(f float64) float64 {
+		return values[min(max(int(f*float64(len(values)-1)+.5), 0), len(values)-1)]
+	}
+	return DisplayMapping{Black: at(low), White: at(high), Gamma: 1}, nil
+}
+
+// copies a rectangle of src, starting at sp, into r of dst. Images of the same pyramid format are copied a row at a time
+func blit(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point) {
+	r = r.Intersect(dst.Bounds())
+	sr := r.Sub(r.Min).Add(sp).Intersect(src.Bounds())
+	r = sr.Sub(sp).Add(r.Min)
+	sp = sr.Min
+	if r.Empty() {
+		return
+	}
+	switch d := dst.(type) {
+	case *image.NRGBA:
+		if s, ok := src.(*image.NRGBA); ok { // draw.Draw would go through premultiplied colour, losing the colour of transparent pixels
+			for y := 0; y < r.Dy(); y++ {
+				copy(d.Pix[d.PixOffset(r.Min.X, r.Min.Y+y):][:4*r.Dx()], s.Pix[s.PixOffset(sp.X, sp.Y+y):])
+			}
+			return
+		}
+	case *image.Gray16:
+		if s, ok := src.(*image.Gray16); ok {
+			for y := 0; y < r.Dy(); y++ {
+				copy(d.Pix[d.PixOffset(r.Min.X, r.Min.Y+y):][:2*r.Dx()], s.Pix[s.PixOffset(sp.X, sp.Y+y):])
+			}
+			return
+		}
+	case *image.NRGBA64:
+		if s, ok := src.(*image.NRGBA64); ok {
+			for y := 0; y < r.Dy(); y++ {
+				copy(d.Pix[d.PixOffset(r.Min.X, r.Min.Y+y):][:8*r.Dx()], s.Pix[s.PixOffset(sp.X, sp.Y+y):])
+			}
+			return
+		}
+	case *Gray32f:
+		if s, ok := src.(*Gray32f); ok {
+			for y := 0; y < r.Dy(); y++ {
+				copy(d.Pix[d.PixOffset(r.Min.X, r.Min.Y+y):][:r.Dx()], s.Pix[s.PixOffset(sp.X, sp.Y+y):])
+			}
+			return
+		}
+	}
+	draw.Draw(dst, r, src, sp, draw.Src)
+}
+
+// the raw bytes of each row of a tile, without any padding between rows. Values of float images are little-endian
+func tileBytes(tile image.Image) []byte {
+	r := tile.Bounds()
+	out := make([]byte, 0, r.Dx()*r.Dy()*pixelFormatOf(tile).pixelBytes())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		switch t := tile.(type) {
+		case *image.NRGBA:
+			i := t.PixOffset(r.Min.X, y)
+			out = append(out, t.Pix[i:i+4*r.Dx()]...)
+		case *image.Gray16:
+			i := t.PixOffset(r.Min.X, y)
+			out = append(out, t.Pix[i:i+2*r.Dx()]...)
+		case *image.NRGBA64:
+			i := t.PixOffset(r.Min.X, y)
+			out = append(out, t.Pix[i:i+8*r.Dx()]...)
+		case *Gray32f:
+			i := t.PixOffset(r.Min.X, y)
+			for _, v := range t.Pix[i : i+r.Dx()] {
+				out = binary.LittleEndian.AppendUint32(out, math.Float32bits(v))
+			}
+		}
+	}
+	return out
+}
+
+// makes a tile of a format from bytes written by tileBytes
+func tileFromBytes(format PixelFormat, r image.Rectangle, b []byte) (image.Image, error) {
+	if len(b) != r.Dx()*r.Dy()*format.pixelBytes() {
+		return nil, errors.New("wrong number of bytes for tile")
+	}
+	tile := format.newImage(r)
+	switch t := tile.(type) {
+	case *image.NRGBA:
+		copy(t.Pix, b)
+	case *image.Gray16:
+		copy(t.Pix, b)
+	case *image.NRGBA64:
+		copy(t.Pix, b)
+	case *Gray32f:
+		for i := range t.Pix {
+			t.Pix[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+		}
+	}
+	return tile, nil
+}
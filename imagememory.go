@@ -0,0 +1,154 @@
+package fynewidgets
+
+import (
+	"container/list"
+	"image"
+	"sync"
+)
+
+const DefaultImageMemoryBudget int = 1 << 30 // bytes of pixels that the pyramids sharing an ImageMemory may hold before the least recently used give some up
+
+// ImageMemory shares a byte budget between pyramids, so that opening many large images at once (eg in a
+// SynchronisedImageGrid) does not keep every one of them in memory. When the budget is exceeded, the least recently
+// used pyramids first drop their full-resolution level, which is reloaded from file when it is next needed, and then
+// their reduced tiles, which are made again. Coarse levels are always kept, so every image can still be shown.
+//
+// Only pyramids that can reload what they give up, those made from files or tile sources, are registered. They
+// register when they are made, and stay registered until they are closed. Pyramids made from an image in memory are
+// left to the garbage collector, as their caller holds the source anyway.
+type ImageMemory struct {
+	mu      sync.Mutex
+	budget  int
+	total   int        // bytes held by registered pyramids when each was last touched
+	order   *list.List // most recently used pyramid at the front
+	entries map[*Pyramid]*list.Element
+}
+
+// a registered pyramid and the bytes it held when last counted
+type memoryEntry struct {
+	pyramid *Pyramid
+	bytes   int
+}
+
+var sharedimagememory = NewImageMemory(DefaultImageMemoryBudget)
+
+func NewImageMemory(budget int) *ImageMemory {
+	return &ImageMemory{budget: budget, order: list.New(), entries: make(map[*Pyramid]*list.Element)}
+}
+
+// the ImageMemory used by pyramids whose options do not name one
+func SharedImageMemory() *ImageMemory {
+	return sharedimagememory
+}
+
+// bytes that registered pyramids may hold before some are evicted
+func (m *ImageMemory) Budget() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.budget
+}
+
+// changes the budget, evicting straight away if necessary
+func (m *ImageMemory) SetBudget(budget int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.budget = budget
+	m.evict(nil)
+}
+
+// bytes currently held by registered pyramids, including pinned tiles and full-resolution images
+func (m *ImageMemory) Used() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for e := m.order.Front(); e != nil; e = e.Next() {
+		m.count(e.Value.(*memoryEntry))
+	}
+	return m.total
+}
+
+// number of registered pyramids
+func (m *ImageMemory) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// registers a pyramid if necessary and marks it as the most recently used, then evicts others if it took the total
+// over budget. Pyramids that cannot reload what they give up, and closed ones, are ignored.
+func (m *ImageMemory) touch(p *Pyramid) {
+	if !p.reloadable() {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p.closed.Load() { // checked under the lock, so a pyramid being closed is either removed after this or not added
+		return
+	}
+	e, ok := m.entries[p]
+	if ok {
+		m.order.MoveToFront(e)
+	} else {
+		e = m.order.PushFront(&memoryEntry{pyramid: p})
+		m.entries[p] = e
+	}
+	m.count(e.Value.(*memoryEntry))
+	m.evict(p)
+}
+
+// forgets a pyramid
+func (m *ImageMemory) remove(p *Pyramid) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[p]; ok {
+		m.total -= e.Value.(*memoryEntry).bytes
+		m.order.Remove(e)
+		delete(m.entries, p)
+	}
+}
+
+// updates the running total with the bytes a pyramid holds now. Must be called with the lock held.
+func (m *ImageMemory) count(entry *memoryEntry) {
+	bytes := entry.pyramid.MemoryBytes()
+	m.total += bytes - entry.bytes
+	entry.bytes = bytes
+}
+
+// releases memory from the least recently used pyramids, other than the one in use, until the budget is met. Full
+// resolution levels go first, as they are the largest and are only needed when zoomed right in. Must be called with the lock held.
+func (m *ImageMemory) evict(keep *Pyramid) {
+	for _, release := range []func(*Pyramid){(*Pyramid).releaseFull, (*Pyramid).releaseTiles} {
+		for e := m.order.Back(); e != nil && m.total > m.budget; e = e.Prev() {
+			entry := e.Value.(*memoryEntry)
+			if entry.pyramid == keep {
+				continue
+			}
+			release(entry.pyramid)
+			m.count(entry)
+		}
+	}
+}
+
+// bytes of pixels held by an image, or an estimate for types that do not expose them
+func imageBytes(img image.Image) int {
+	switch i := img.(type) {
+	case *image.NRGBA:
+		return len(i.Pix)
+	case *image.RGBA:
+		return len(i.Pix)
+	case *image.NRGBA64:
+		return len(i.Pix)
+	case *image.RGBA64:
+		return len(i.Pix)
+	case *image.Gray:
+		return len(i.Pix)
+	case *image.Gray16:
+		return len(i.Pix)
+	case *image.YCbCr:
+		return len(i.Y) + len(i.Cb) + len(i.Cr)
+	case *image.Paletted:
+		return len(i.Pix)
+	case *Gray32f:
+		return 4 * len(i.Pix)
+	}
+	return 4 * img.Bounds().Dx() * img.Bounds().Dy()
+}
@@ -0,0 +1,79 @@
+package fynewidgets
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fyne.io/fyne/v2/storage"
+)
+
+func TestImageMemoryBudget(t *testing.T) {
+	dir := t.TempDir()
+	memory := NewImageMemory(600 * 400 * 4 * 3 / 2) // room for one full-resolution image, not two
+	options := PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit, Memory: memory}
+
+	pyramids := make([]*Pyramid, 2)
+	for i := range pyramids {
+		file := filepath.Join(dir, string(rune('a'+i))+".png")
+		f, err := os.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		png.Encode(f, testImage(600, 400))
+		f.Close()
+		pyramids[i], err = NewPyramidFromFile(storage.NewFileURI(file), image.Pt(20, 20), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pyramids[i].Region(0, image.Rect(0, 0, 100, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if memory.Count() != 2 {
+		t.Fatalf("%d pyramids registered, want 2", memory.Count())
+	}
+	if memory.Used() > memory.Budget() {
+		t.Errorf("%d bytes used, over the budget of %d", memory.Used(), memory.Budget())
+	}
+	if pyramids[0].source != nil || pyramids[1].source == nil {
+		t.Error("the full-resolution image evicted was not the least recently used")
+	}
+
+	got, err := pyramids[0].Region(0, image.Rect(100, 100, 101, 101)) // reloaded from file
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.NRGBAAt(0, 0) != testImage(600, 400).NRGBAAt(100, 100) {
+		t.Error("reloaded pixel differs from the file")
+	}
+	if pyramids[1].source != nil {
+		t.Error("the other full-resolution image was kept over the budget")
+	}
+
+	for _, p := range pyramids {
+		p.Close()
+	}
+	if memory.Count() != 0 || memory.Used() != 0 {
+		t.Errorf("%d pyramids holding %d bytes after closing", memory.Count(), memory.Used())
+	}
+	if _, err := pyramids[0].Region(0, image.Rect(0, 0, 100, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if memory.Count() != 0 {
+		t.Error("a closed pyramid was registered again when it was used")
+	}
+
+	p, err := NewPyramidWithOptions(testImage(600, 400), image.Pt(20, 20), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Region(0, image.Rect(0, 0, 100, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if memory.Count() != 0 {
+		t.Error("a pyramid that cannot reload its image was registered")
+	}
+}
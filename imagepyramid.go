@@ -1,36 +1,90 @@
 package fynewidgets
 
 import (
+	"context"
 	"fmt"
 	"image"
+	"image/draw"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
 
+	"fyne.io/fyne/v2"
 	"github.com/disintegration/imaging"
 	"github.com/pkg/errors"
 )
 
+const tilemargin int = 8 // pixels of the finer level read either side of a tile when reducing it, so the filter can see across tile edges. Must be even.
+
 // Pyramid decomposition of an image.Image
 //   - Each level is half the width and height of the previous level
+//   - Each level is split into square tiles, which are produced from the level below when first needed and evicted when unused
 //   - A projection PyramidDatum stores datum and scale for the top level of the pyramid, as well as the active level of the pyramid
 type Pyramid struct {
-	images []*image.NRGBA
-	level  int
+	source     image.Image                 // full-resolution image. Level 0 tiles are cut from it on demand
+	load       func() (image.Image, error) // loads the source if it is not in memory yet
+	sourcemu   sync.Mutex                  // guards source while it is loaded
+	sourcesize atomic.Int64                // bytes held by source, zero when it is not loaded
+	memory     *ImageMemory                // shares a memory budget with other pyramids
+	closed     atomic.Bool                 // set by Close, so that the pyramid is not registered with its memory again
+	store      *pyramidStore               // on-disk tiles, if the pyramid has a cache
+	remote     TileSource                  // supplies every tile, if the image was tiled already
+	bounds     []image.Rectangle           // extent of each level, with its origin at 0,0
+	tilesize   int                         // tile width and height, in pixels of the tile's own level
+	filter     PyramidFilter               // resampling filter used to make each level from the one below
+	linear     bool                        // resample in linear light rather than sRGB
+	format     PixelFormat                 // type of image the tiles are kept in
+	mapping    DisplayMapping              // converts tiles to 8 bits for display
+	mappingmu  sync.RWMutex                // guards mapping
+	pinned     int                         // tiles at this level and coarser are never evicted
+	tiles      *tileCache                  // tiles produced so far
+	mu         sync.Mutex                  // guards inflight
+	inflight   map[tileKey]chan struct{}
+	level      int
+}
+
+// PyramidOptions control the tiling of a Pyramid and how much memory its tiles may use
+type PyramidOptions struct {
+	TileSize   int           // tile width and height in pixels
+	CacheLimit int           // bytes of tiles to keep before evicting the least recently used
+	Cache      *PyramidCache // keeps reduced levels of pyramids made from files on disk, if not nil
+	Filter     PyramidFilter // resampling filter used to make each level from the one below
+	Memory     *ImageMemory  // budget shared with other pyramids, or the SharedImageMemory if nil
+	Linear     bool          // resample 8-bit images in linear light, so that coarse levels keep the true average brightness of fine detail. High bit depth values are always resampled as they are
 }
 
-func (p Pyramid) String() string {
-	s := fmt.Sprintf("\n\n------------------------\nPyramid\n%d\tLevels:\n", p.Height())
+var defaultoptions = PyramidOptions{TileSize: DefaultTileSize, CacheLimit: DefaultTileCacheLimit}
+var defaultoptionsmu sync.Mutex
+
+// the options used by NewPyramid, and by widgets that make their own pyramids
+func DefaultPyramidOptions() PyramidOptions {
+	defaultoptionsmu.Lock()
+	defer defaultoptionsmu.Unlock()
+	return defaultoptions
+}
+
+// changes the options returned by DefaultPyramidOptions, for instance to give every PanZoomCanvas opened from a file an on-disk cache
+func SetDefaultPyramidOptions(options PyramidOptions) {
+	defaultoptionsmu.Lock()
+	defer defaultoptionsmu.Unlock()
+	defaultoptions = options
+}
+
+// describes everything that changes the pixels of reduced tiles, so that cached tiles made differently are not used
+func (o PyramidOptions) settings() string {
+	return fmt.Sprintf("tile=%d margin=%d filter=%s linear=%t overview=rows layout=2", o.TileSize, tilemargin, o.Filter, o.Linear)
+}
+
+func (p *Pyramid) String() string {
+	s := fmt.Sprintf("\n\n------------------------\nPyramid\n%d\tLevels (%d px tiles, %.1f MB cached):\n", p.Height(), p.tilesize, float32(p.tiles.bytes())/1024/1024)
 
 	for i := 0; i < p.Height(); i++ {
-		if p.images[i] == nil {
-			s += fmt.Sprintf("Level %2d : nil\n", i)
-		} else {
-			w := p.images[i].Bounds().Dx()
-			h := p.images[i].Bounds().Dy()
-			s += fmt.Sprintf("Level %2d : %4d x %4d", i, w, h)
-			if i == p.level {
-				s += " (Active)"
-			}
-			s += "\n"
+		s += fmt.Sprintf("Level %2d : %4d x %4d", i, p.bounds[i].Dx(), p.bounds[i].Dy())
+		if i == p.level {
+			s += " (Active)"
 		}
+		s += "\n"
 	}
 
 	return s
@@ -38,27 +92,186 @@ func (p Pyramid) String() string {
 }
 
 // creates a pyramid from an image,Image, with the smallest possible size given. Active level will be the last one (lowest resolution)
+//
+// The image is not copied, and must not be modified while the pyramid is in use.
 func NewPyramid(img image.Image, smallestsize image.Point) (*Pyramid, error) {
-	newpyramid := Pyramid{}
-	W := img.Bounds().Dx()
-	H := img.Bounds().Dy()
+	return NewPyramidWithOptions(img, smallestsize, DefaultPyramidOptions())
+}
+
+// creates a pyramid as NewPyramid does, with control over tiling and memory use
+func NewPyramidWithOptions(img image.Image, smallestsize image.Point, options PyramidOptions) (*Pyramid, error) {
+	if img == nil {
+		return nil, errors.New("f: NewPyramid - nil image")
+	}
+	p, err := newPyramid(img.Bounds().Size(), pixelFormatOf(img), smallestsize, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "f: NewPyramid")
+	}
+	p.source = img
+	p.sourcesize.Store(int64(imageBytes(img)))
+	return p, nil
+}
+
+// creates a pyramid from an image file. If the options include a cache, reduced levels are read from it when the
+// file has been opened before, and in that case the file itself is only decoded if full-resolution pixels are needed.
+//
+// PNG, TIFF and baseline JPEG files that can be decoded a few rows at a time are streamed, making every level as the
+// rows arrive, so opening them never holds the full-resolution image in memory. Full-resolution tiles are not kept,
+// though: zooming right in reads them from the cache, if it had room for them (see PyramidCache). With no cache, or
+// too small a one, the first full-resolution view decodes the whole file, so peak memory is bounded only for
+// pyramids given a cache big enough for the file.
+//
+// Tiled TIFF and BigTIFF files are not decoded at all: their tiles are read as they come into view, and any
+// reduced-resolution images they hold are used as levels, so even multi-gigabyte slides open at once (see TIFFTileSource).
+// Levels the file lacks are made from its tiles and kept in the cache, if the options have one, so that a file without
+// reduced images has every tile read only the first time it is shown whole, rather than every time it is opened.
+func NewPyramidFromFile(uri fyne.URI, smallestsize image.Point, options PyramidOptions) (*Pyramid, error) {
+	return NewPyramidFromFileContext(context.Background(), uri, smallestsize, options, nil)
+}
+
+// creates a pyramid from an image file as NewPyramidFromFile does, giving up if the context is cancelled.
+//
+//	progress  if not nil, is called while a file is streamed with the rows decoded so far and the height of the image
+func NewPyramidFromFileContext(ctx context.Context, uri fyne.URI, smallestsize image.Point, options PyramidOptions, progress func(done, total int)) (*Pyramid, error) {
+	if uri == nil {
+		return nil, errors.New("f: NewPyramidFromFile - nil URI")
+	}
+	load := func() (image.Image, error) {
+		img, err := LoadImage(uri)
+		if err != nil {
+			return nil, err
+		}
+		return *img, nil
+	}
+
+	if isTIFFFile(uri.Path()) {
+		if source, err := openTIFFTileSource(ctx, uri.Path()); err == nil { // a tiled TIFF is read a tile at a time, with its own reduced levels
+			p, err := tiledPyramid(ctx, uri, source, smallestsize, options)
+			if err != nil {
+				return nil, errors.Wrap(err, "f: NewPyramidFromFile")
+			}
+			return p, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrap(err, "f: NewPyramidFromFile")
+		}
+	}
+
+	var store *pyramidStore
+	if options.Cache != nil && cacheable(uri) {
+		var manifest *pyramidManifest
+		var err error
+		store, manifest, err = options.Cache.open(uri, options.settings())
+		if err != nil {
+			return nil, errors.Wrap(err, "f: NewPyramidFromFile")
+		}
+		if manifest != nil && len(manifest.Bounds) > 0 { // seen before - no need to decode anything yet
+			p, err := newPyramid(manifest.Bounds[0].Size(), manifest.Format, smallestsize, options)
+			if err != nil {
+				return nil, errors.Wrap(err, "f: NewPyramidFromFile")
+			}
+			p.load = load
+			p.store = store
+			p.memory.touch(p)
+			return p, nil
+		}
+	}
+
+	if p, err := streamPyramid(ctx, uri, smallestsize, options, store, progress); p != nil || err != nil {
+		if err != nil {
+			return nil, errors.Wrap(err, "f: NewPyramidFromFile")
+		}
+		p.load = load
+		p.memory.touch(p)
+		return p, nil
+	}
+
+	img, err := loadImage(ctx, uri) // the file cannot be streamed, so it is decoded whole, unless the context is cancelled first
+	if err != nil {
+		return nil, errors.Wrap(err, "f: NewPyramidFromFile")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "f: NewPyramidFromFile")
+	}
+	p, err := NewPyramidWithOptions(img, smallestsize, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "f: NewPyramidFromFile")
+	}
+	p.load = load
+	if store != nil && store.create(uri, options.settings(), p.format, p.bounds) == nil {
+		p.store = store
+	}
+	p.memory.touch(p)
+	return p, nil
+}
+
+// a pyramid reading a tiled TIFF's tiles, which keeps the levels the file lacks in the options' cache, if it has one.
+// The source is closed if the pyramid cannot be made.
+func tiledPyramid(ctx context.Context, uri fyne.URI, source *TIFFTileSource, smallestsize image.Point, options PyramidOptions) (*Pyramid, error) {
+	if err := ctx.Err(); err != nil {
+		source.Close()
+		return nil, err
+	}
+	p, err := NewPyramidFromTileSource(source, smallestsize, options)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+	if options.Cache != nil && cacheable(uri) {
+		options.TileSize = source.TileSize() // the cached tiles are cut as the file's are
+		store, manifest, err := options.Cache.open(uri, options.settings())
+		if err == nil && (manifest != nil || store.create(uri, options.settings(), p.format, p.bounds) == nil) {
+			p.store = store
+		}
+	}
+	return p, nil
+}
+
+// sets up the levels and tiling of a pyramid for a full-resolution image of the given size and format, without any pixels
+func newPyramid(size image.Point, format PixelFormat, smallestsize image.Point, options PyramidOptions) (*Pyramid, error) {
+	if size.X <= 0 || size.Y <= 0 {
+		return nil, errors.New("empty image")
+	}
+	if options.TileSize < 16 {
+		return nil, errors.Errorf("tile size %d is too small", options.TileSize)
+	}
+	newpyramid := Pyramid{tilesize: options.TileSize, filter: options.Filter, linear: options.Linear, format: format, inflight: make(map[tileKey]chan struct{})}
+	newpyramid.mapping = DefaultDisplayMapping(format)
+	newpyramid.tiles = newTileCache(options.CacheLimit)
+	newpyramid.memory = options.Memory
+	if newpyramid.memory == nil {
+		newpyramid.memory = SharedImageMemory()
+	}
+	W := size.X
+	H := size.Y
 	ww := smallestsize.X
 	hh := smallestsize.Y
-	newpyramid.images = make([]*image.NRGBA, 0)
-	newpyramid.images = append(newpyramid.images, imaging.Clone(img))
+	bounds := []image.Rectangle{image.Rect(0, 0, W, H)}
 	W, H = W/2, H/2 // next level down the pyramid
 
 	for W > ww && H > hh { // add level and scale down by 2 in x,y, while remaining above the minimum required size
-		newpyramid.images = append(newpyramid.images, imaging.Resize(newpyramid.images[len(newpyramid.images)-1], W, H, imaging.Gaussian))
+		bounds = append(bounds, image.Rect(0, 0, W, H))
 		W, H = W/2, H/2
 	}
-	newpyramid.level = len(newpyramid.images) - 1 // for safety in case someone tries to display a massive image
+	newpyramid.setBounds(bounds)
 
 	return &newpyramid, nil
 
 }
 
-// returns the current level of the pyramid to use
+// sets the extent of every level, finest first, and which levels are kept in memory
+func (p *Pyramid) setBounds(bounds []image.Rectangle) {
+	p.bounds = bounds
+	p.level = len(p.bounds) - 1 // for safety in case someone tries to display a massive image
+
+	p.pinned = p.level // a level of no more than 2 x 2 tiles is worth keeping forever
+	for p.pinned > 0 && p.bounds[p.pinned-1].Dx() <= 2*p.tilesize && p.bounds[p.pinned-1].Dy() <= 2*p.tilesize {
+		p.pinned--
+	}
+}
+
+// returns the level of the pyramid set with SetLevel, which the pyramid's String marks as active. Datums keep their
+// own level (see Datum.Level), and neither read nor change this one.
 func (p *Pyramid) Level() int {
 
 	return p.level
@@ -71,21 +284,540 @@ func (p *Pyramid) SetLevel(level int) {
 }
 
 // returns the number of levels in the pyramid, whcih is at least 1 if the normal constructor was used.
-func (p Pyramid) Height() int {
+func (p *Pyramid) Height() int {
+
+	return len(p.bounds)
+
+}
+
+// extent of a level, with its origin at 0,0. Empty if the level does not exist
+func (p *Pyramid) Bounds(level int) image.Rectangle {
+	if level < 0 || level >= p.Height() {
+		return image.Rectangle{}
+	}
+	return p.bounds[level]
+}
+
+// width and height of the tiles making up each level
+func (p *Pyramid) TileSize() int {
+	return p.tilesize
+}
+
+// changes the number of bytes of tiles kept in memory. Coarse levels are always kept.
+func (p *Pyramid) SetCacheLimit(bytes int) {
+	p.tiles.setLimit(bytes)
+}
+
+// number of bytes currently held by evictable tiles
+func (p *Pyramid) CachedBytes() int {
+	return p.tiles.bytes()
+}
+
+// number of bytes held by the pyramid: its tiles, including pinned ones, and the full-resolution image if it is loaded
+func (p *Pyramid) MemoryBytes() int {
+	return p.tiles.allBytes() + int(p.sourcesize.Load())
+}
+
+// releases the pyramid's memory and takes it off its ImageMemory for good. Call it when a pyramid made from a file or
+// tile source is no longer needed, as otherwise its ImageMemory keeps it. A pyramid made from a file can still be used
+// afterwards, and reloads what it needs without counting against the budget, but one made from a tile source cannot,
+// as the source is closed too if it has a Close method.
+func (p *Pyramid) Close() {
+	p.closed.Store(true)
+	p.memory.remove(p)
+	p.releaseFull()
+	p.tiles.clear()
+	if closer, ok := p.remote.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// true if the pyramid can make again whatever it gives up, so that it is worth registering with its ImageMemory
+func (p *Pyramid) reloadable() bool {
+	return p.load != nil || p.remote != nil
+}
+
+// drops the full-resolution image and the tiles cut from it, if they can be reloaded from file. An image still being
+// loaded is left alone.
+func (p *Pyramid) releaseFull() {
+	if p.load == nil || !p.sourcemu.TryLock() {
+		return
+	}
+	p.source = nil
+	p.sourcesize.Store(0)
+	p.sourcemu.Unlock()
+	p.tiles.dropLevel(0)
+}
 
-	return len(p.images)
+// drops the tiles that can be made again, keeping the coarse levels
+func (p *Pyramid) releaseTiles() {
+	p.tiles.purge()
+}
 
+// type of image the pyramid keeps its tiles in, which is that of the source image, or NRGBA for 8-bit images
+func (p *Pyramid) Format() PixelFormat {
+	return p.format
 }
 
-// image at level i of the pyramid. Level 0 corresponds to the full image, with level 1 at half width and height, etc.
-func (p Pyramid) CurrentImage() (*image.NRGBA, error) {
+// how pixels are converted to 8 bits by Region. Only high bit depth images usually need anything but the default
+func (p *Pyramid) DisplayMapping() DisplayMapping {
+	p.mappingmu.RLock()
+	defer p.mappingmu.RUnlock()
+	return p.mapping
+}
 
-	if len(p.images) == 0 {
+func (p *Pyramid) SetDisplayMapping(m DisplayMapping) {
+	p.mappingmu.Lock()
+	defer p.mappingmu.Unlock()
+	p.mapping = m
+}
+
+// a display mapping that stretches the values of the coarsest level between two fractions of their histogram, eg
+// 0.001 and 0.999, so that a few extreme pixels do not leave the rest of a high bit depth image grey
+func (p *Pyramid) StretchedDisplayMapping(low, high float64) (DisplayMapping, error) {
+	top := p.Height() - 1
+	pixels, err := p.Pixels(top, p.bounds[top])
+	if err != nil {
+		return p.DisplayMapping(), errors.Wrap(err, "f: StretchedDisplayMapping")
+	}
+	return stretchMapping(pixels, p.format, low, high)
+}
+
+// image at the current level of the pyramid. Level 0 corresponds to the full image, with level 1 at half width and height, etc.
+//
+// The whole level is assembled from its tiles, so prefer Region for large images.
+func (p *Pyramid) CurrentImage() (*image.NRGBA, error) {
+
+	if p.Height() == 0 {
 		return nil, errors.New("no images in pyramid")
 	}
-	if p.level < 0 || p.level >= len(p.images) {
+	if p.level < 0 || p.level >= p.Height() {
 		return nil, errors.New("level out of range")
 	}
-	return p.images[p.level], nil
+	return p.Region(p.level, p.bounds[p.level])
 
 }
+
+// pixels of a level inside a rectangle given in that level's coordinates, returned for display with the origin at 0,0.
+// Only the tiles that the rectangle touches are produced. Parts of the rectangle outside the level are transparent.
+// High bit depth pixels are converted to 8 bits with the pyramid's display mapping.
+func (p *Pyramid) Region(level int, r image.Rectangle) (*image.NRGBA, error) {
+	return p.displayRegion(context.Background(), level, r)
+}
+
+// Region, giving up if the context is cancelled
+func (p *Pyramid) displayRegion(ctx context.Context, level int, r image.Rectangle) (*image.NRGBA, error) {
+	pixels, err := p.region(ctx, level, r)
+	if err != nil {
+		return nil, err
+	}
+	p.memory.touch(p)
+	return p.display(pixels), nil
+}
+
+// pixels in the pyramid's format, converted to 8 bits with its display mapping
+func (p *Pyramid) display(pixels image.Image) *image.NRGBA {
+	m := p.DisplayMapping()
+	if nrgba, ok := pixels.(*image.NRGBA); ok && m == DefaultDisplayMapping(FormatNRGBA) {
+		return nrgba
+	}
+	return toDisplay(pixels, m)
+}
+
+// pixels of a level inside a rectangle, as Region, but in the pyramid's own format (see Format) with its full range
+func (p *Pyramid) Pixels(level int, r image.Rectangle) (image.Image, error) {
+	pixels, err := p.region(context.Background(), level, r)
+	if err != nil {
+		return nil, err
+	}
+	p.memory.touch(p)
+	return pixels, nil
+}
+
+// Pixels, giving up if the context is cancelled
+func (p *Pyramid) region(ctx context.Context, level int, r image.Rectangle) (draw.Image, error) {
+	if level < 0 || level >= p.Height() {
+		return nil, errors.Errorf("f: Region - level %d out of range", level)
+	}
+	dst := p.format.newImage(r.Sub(r.Min))
+	visible := r.Intersect(p.bounds[level])
+	if visible.Empty() {
+		return dst, nil
+	}
+	T := p.tilesize
+	for row := visible.Min.Y / T; row <= (visible.Max.Y-1)/T; row++ {
+		for col := visible.Min.X / T; col <= (visible.Max.X-1)/T; col++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			tile, err := p.tile(ctx, level, col, row)
+			if err != nil {
+				return nil, errors.Wrap(err, "f: Region")
+			}
+			overlap := tile.Bounds().Intersect(visible)
+			blit(dst, overlap.Sub(r.Min), tile, overlap.Min)
+		}
+	}
+	return dst, nil
+}
+
+// the tile at a column and row of a level, produced (along with any finer tiles it depends on) if it is not cached.
+// The tile's bounds are in the coordinates of its level, and are smaller than the tile size at the right and bottom edges.
+// Tiles are in the pyramid's own format (see Format).
+func (p *Pyramid) Tile(level, col, row int) (image.Image, error) {
+	return p.tile(context.Background(), level, col, row)
+}
+
+// Tile, giving up if the context is cancelled before the tile, or one it depends on, is made
+func (p *Pyramid) tile(ctx context.Context, level, col, row int) (image.Image, error) {
+	if level < 0 || level >= p.Height() {
+		return nil, errors.Errorf("f: Tile - level %d out of range", level)
+	}
+	r := p.tileRect(level, col, row)
+	if r.Empty() {
+		return nil, errors.Errorf("f: Tile - tile %d,%d is outside level %d", col, row, level)
+	}
+	key := tileKey{level, col, row}
+
+	for {
+		if tile, ok := p.tiles.get(key); ok {
+			return tile, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p.mu.Lock()
+		wait, busy := p.inflight[key]
+		if !busy {
+			p.inflight[key] = make(chan struct{})
+			p.mu.Unlock()
+			break
+		}
+		p.mu.Unlock()
+		select { // someone else is producing this tile - wait for them and look again
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	defer func() {
+		p.mu.Lock()
+		close(p.inflight[key])
+		delete(p.inflight, key)
+		p.mu.Unlock()
+	}()
+
+	if p.store != nil && !p.fetches(level) && (level > 0 || p.sourcesize.Load() == 0) { // full-resolution tiles are only stored by a streaming build, and tiles read from a tile source are not stored at all
+		if tile := p.store.load(key, p.format); tile != nil && tile.Bounds() == r {
+			p.tiles.put(key, tile, r.Dx()*r.Dy()*p.format.pixelBytes(), level >= p.pinned)
+			p.memory.touch(p)
+			return tile, nil
+		}
+	}
+
+	var tile image.Image
+	var err error
+	size := r.Dx() * r.Dy() * p.format.pixelBytes()
+	if p.fetches(level) {
+		tile, err = p.fetch(ctx, key, r)
+	} else if level == 0 {
+		tile, size, err = p.cut(r)
+	} else if level == p.Height()-1 && p.remote == nil {
+		tile, err = p.overview(key)
+	} else {
+		tile, err = p.reduce(ctx, level, r)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "f: Tile")
+	}
+	if level > 0 && p.store != nil && !p.fetches(level) {
+		p.store.save(key, tile)
+	}
+	p.tiles.put(key, tile, size, level >= p.pinned)
+	p.memory.touch(p)
+	return tile, nil
+}
+
+// true if every tile of a level that a rectangle touches is in memory, so that Region will not have to make any
+func (p *Pyramid) Ready(level int, r image.Rectangle) bool {
+	if level < 0 || level >= p.Height() {
+		return false
+	}
+	visible := r.Intersect(p.bounds[level])
+	T := p.tilesize
+	for row := visible.Min.Y / T; row < (visible.Max.Y+T-1)/T; row++ {
+		for col := visible.Min.X / T; col < (visible.Max.X+T-1)/T; col++ {
+			if _, ok := p.tiles.get(tileKey{level, col, row}); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// makes the tiles of a level that a rectangle touches, so that a later Region is quick.
+//
+//	progress  if not nil, is called after each tile with the number made so far and the number needed
+func (p *Pyramid) Prepare(level int, r image.Rectangle, progress func(done, total int)) error {
+	return p.PrepareContext(context.Background(), level, r, progress)
+}
+
+// makes the tiles of a level that a rectangle touches as Prepare does, spread over every core, stopping early if the
+// context is cancelled. Progress is reported from one goroutine at a time.
+func (p *Pyramid) PrepareContext(ctx context.Context, level int, r image.Rectangle, progress func(done, total int)) error {
+	if level < 0 || level >= p.Height() {
+		return errors.Errorf("f: Prepare - level %d out of range", level)
+	}
+	visible := r.Intersect(p.bounds[level])
+	T := p.tilesize
+	keys := make([]tileKey, 0)
+	for row := visible.Min.Y / T; row < (visible.Max.Y+T-1)/T; row++ {
+		for col := visible.Min.X / T; col < (visible.Max.X+T-1)/T; col++ {
+			keys = append(keys, tileKey{level, col, row})
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx) // the first failure stops the other workers
+	defer cancel()
+	jobs := make(chan tileKey)
+	wg := &sync.WaitGroup{}
+	mu := &sync.Mutex{} // guards done, firsterr and calls to progress
+	done := 0
+	var firsterr error
+	workers := min(runtime.NumCPU(), len(keys))
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for key := range jobs {
+				_, err := p.tile(ctx, key.level, key.col, key.row)
+				mu.Lock()
+				if err != nil {
+					if firsterr == nil {
+						firsterr = err
+					}
+					cancel()
+				} else {
+					done++
+					if progress != nil {
+						progress(done, len(keys))
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, key := range keys {
+		select {
+		case jobs <- key:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firsterr != nil {
+		return errors.Wrap(firsterr, "f: Prepare")
+	}
+	return errors.Wrap(ctx.Err(), "f: Prepare") // nil unless cancelled
+}
+
+// makes every level of the pyramid, from finest to coarsest, spreading the tiles of each level over every core and
+// stopping early if the context is cancelled. Only useful if the cache limit can hold the levels, or to fill an
+// on-disk cache, as otherwise tiles are made lazily when they are needed.
+//
+//	progress  if not nil, is called after each tile with the level, the tiles made so far and the number needed
+func (p *Pyramid) Build(ctx context.Context, progress func(level, done, total int)) error {
+	for level := 1; level < p.Height(); level++ {
+		var f func(done, total int)
+		if progress != nil {
+			f = func(done, total int) { progress(level, done, total) }
+		}
+		if err := p.PrepareContext(ctx, level, p.bounds[level], f); err != nil {
+			return errors.Wrap(err, "f: Build")
+		}
+	}
+	return nil
+}
+
+// creates a pyramid as NewPyramidWithOptions does, and makes all its levels in parallel before returning, unless
+// the context is cancelled first
+func BuildPyramid(ctx context.Context, img image.Image, smallestsize image.Point, options PyramidOptions) (*Pyramid, error) {
+	p, err := NewPyramidWithOptions(img, smallestsize, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Build(ctx, nil); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// the full-resolution image, loaded first if necessary. Loading it may make other pyramids give up theirs.
+func (p *Pyramid) fullImage() (image.Image, error) {
+	img, loaded, err := p.loadSource()
+	if loaded {
+		p.memory.touch(p)
+	}
+	return img, err
+}
+
+// the full-resolution image, and whether it had to be loaded
+func (p *Pyramid) loadSource() (image.Image, bool, error) {
+	p.sourcemu.Lock()
+	defer p.sourcemu.Unlock()
+	if p.source != nil {
+		return p.source, false, nil
+	}
+	if p.load == nil {
+		return nil, false, errors.New("no source image")
+	}
+	img, err := p.load()
+	if err != nil {
+		return nil, false, errors.Wrap(err, "loading source image")
+	}
+	if img.Bounds().Size() != p.bounds[0].Size() {
+		return nil, false, errors.Errorf("source image is %v, expected %v", img.Bounds().Size(), p.bounds[0].Size())
+	}
+	p.source = img
+	p.sourcesize.Store(int64(imageBytes(img)))
+	return img, true, nil
+}
+
+// rectangle covered by a tile, clipped to its level
+func (p *Pyramid) tileRect(level, col, row int) image.Rectangle {
+	T := p.tilesize
+	return image.Rect(col*T, row*T, (col+1)*T, (row+1)*T).Intersect(p.bounds[level])
+}
+
+// cuts a rectangle of the full-resolution level from the source image, returning the tile and the bytes it added.
+// Sources already in the pyramid's format share their pixels with the tile, so cost nothing extra.
+func (p *Pyramid) cut(r image.Rectangle) (image.Image, int, error) {
+	source, err := p.fullImage()
+	if err != nil {
+		return nil, 0, err
+	}
+	min := source.Bounds().Min
+	if min == (image.Point{}) {
+		switch src := source.(type) {
+		case *image.NRGBA:
+			return src.SubImage(r), 0, nil
+		case *image.Gray16:
+			return src.SubImage(r), 0, nil
+		case *image.NRGBA64:
+			return src.SubImage(r), 0, nil
+		case *Gray32f:
+			return src.SubImage(r), 0, nil
+		}
+	}
+	tile := p.format.newImage(r)
+	blit(tile, r, source, r.Min.Add(min))
+	return tile, r.Dx() * r.Dy() * p.format.pixelBytes(), nil
+}
+
+// resizes an image in the pyramid's format with a filter, in linear light if the pyramid was asked to
+func (p *Pyramid) resize(img image.Image, w, h int, filter imaging.ResampleFilter) image.Image {
+	if p.format == FormatNRGBA && !p.linear {
+		return imaging.Resize(img, w, h, filter)
+	}
+	return resizeSamples(img, p.codec(), w, h, filter)
+}
+
+// how the pyramid's pixels are resampled
+func (p *Pyramid) codec() sampleCodec {
+	return sampleCodec{format: p.format, linear: p.linear && p.format == FormatNRGBA}
+}
+
+// makes the coarsest level straight from the full-resolution image by averaging, rather than through every level in
+// between, so that there is something to show soon after an image is opened. All its tiles are kept, and the one asked for is returned.
+func (p *Pyramid) overview(want tileKey) (image.Image, error) {
+	source, err := p.fullImage()
+	if err != nil {
+		return nil, err
+	}
+	shrink := p.newOverview()
+	read := shrink.codec.reader(source)
+	line := make([]float32, shrink.codec.channels()*p.bounds[0].Dx())
+	for y := 0; y < p.bounds[0].Dy(); y++ {
+		read(y, line)
+		shrink.add(y, line)
+	}
+	return p.keepOverview(shrink.image(), want), nil
+}
+
+// averages rows of the full-resolution level into the coarsest level
+func (p *Pyramid) newOverview() *rowResizer {
+	top := p.Height() - 1
+	return newRowResizer(p.codec(), p.bounds[0].Dx(), p.bounds[0].Dy(), p.bounds[top].Dx(), p.bounds[top].Dy(), imaging.Box)
+}
+
+// splits the coarsest level into tiles, saving and keeping all but the one asked for, which is returned
+func (p *Pyramid) keepOverview(small image.Image, want tileKey) image.Image {
+	var wanted image.Image
+	top := p.Height() - 1
+	T := p.tilesize
+	for row := 0; row*T < p.bounds[top].Dy(); row++ {
+		for col := 0; col*T < p.bounds[top].Dx(); col++ {
+			key := tileKey{top, col, row}
+			r := p.tileRect(top, col, row)
+			tile := p.format.newImage(r)
+			blit(tile, r, small, r.Min)
+			if key == want {
+				wanted = tile
+				continue // saved and cached by Tile
+			}
+			p.keep(key, tile)
+		}
+	}
+	return wanted
+}
+
+// saves a tile made from other tiles, and caches it
+func (p *Pyramid) keep(key tileKey, tile image.Image) {
+	if p.store != nil {
+		p.store.save(key, tile)
+	}
+	r := tile.Bounds()
+	p.tiles.put(key, tile, r.Dx()*r.Dy()*p.format.pixelBytes(), key.level >= p.pinned)
+}
+
+// makes a tile by halving the matching area of the next finer level, plus a margin
+func (p *Pyramid) reduce(ctx context.Context, level int, r image.Rectangle) (image.Image, error) {
+	s := p.reduceSource(level, r)
+	finer, err := p.region(ctx, level-1, s)
+	if err != nil {
+		return nil, err
+	}
+	return p.halve(finer, s, r), nil
+}
+
+// the area of the next finer level that a tile of a level is made from
+func (p *Pyramid) reduceSource(level int, r image.Rectangle) image.Rectangle {
+	return image.Rect(r.Min.X*2-tilemargin, r.Min.Y*2-tilemargin, r.Max.X*2+tilemargin, r.Max.Y*2+tilemargin).Intersect(p.bounds[level-1])
+}
+
+// makes the tile covering r from the pixels of the next finer level covering s, given with their origin at 0,0
+func (p *Pyramid) halve(finer image.Image, s, r image.Rectangle) image.Image {
+	if grow := image.Pt(max(2*r.Max.X-s.Max.X, 0), max(2*r.Max.Y-s.Max.Y, 0)); grow != (image.Point{}) { // levels rounded up have half a pixel more than the level below
+		finer = p.extend(finer, s.Size().Add(grow))
+		s.Max = s.Max.Add(grow)
+	}
+	half := p.resize(finer, s.Dx()/2, s.Dy()/2, p.filter.resampleFilter())
+	tile := p.format.newImage(r)
+	blit(tile, r, half, image.Pt(r.Min.X-s.Min.X/2, r.Min.Y-s.Min.Y/2))
+	return tile
+}
+
+// an image at the origin, grown to a size by repeating its last column and row
+func (p *Pyramid) extend(img image.Image, size image.Point) image.Image {
+	b := img.Bounds()
+	out := p.format.newImage(image.Rectangle{Max: size})
+	blit(out, image.Rectangle{Max: b.Size()}, img, b.Min)
+	for x := b.Dx(); x < size.X; x++ {
+		blit(out, image.Rect(x, 0, x+1, b.Dy()), out, image.Pt(b.Dx()-1, 0))
+	}
+	for y := b.Dy(); y < size.Y; y++ {
+		blit(out, image.Rect(0, y, size.X, y+1), out, image.Pt(0, b.Dy()-1))
+	}
+	return out
+}
@@ -0,0 +1,251 @@
+package fynewidgets
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/storage"
+)
+
+func testImage(w, h int) *image.NRGBA {
+	im := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			im.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+	return im
+}
+
+func TestPyramidLevels(t *testing.T) {
+	p, err := NewPyramid(testImage(1000, 600), image.Pt(50, 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []image.Point{{1000, 600}, {500, 300}, {250, 150}, {125, 75}}
+	if p.Height() != len(want) {
+		t.Fatalf("height %d, want %d", p.Height(), len(want))
+	}
+	for i, w := range want {
+		if p.Bounds(i).Size() != w {
+			t.Errorf("level %d is %v, want %v", i, p.Bounds(i).Size(), w)
+		}
+	}
+}
+
+func TestPyramidRegion(t *testing.T) {
+	src := testImage(700, 500)
+	p, err := NewPyramidWithOptions(src, image.Pt(20, 20), PyramidOptions{TileSize: 64, CacheLimit: 64 * 64 * 4 * 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := image.Rect(50, 60, 300, 210) // spans several tiles
+	region, err := p.Region(0, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if region.NRGBAAt(x-r.Min.X, y-r.Min.Y) != src.NRGBAAt(x, y) {
+				t.Fatalf("pixel %d,%d differs from source", x, y)
+			}
+		}
+	}
+
+	outside, err := p.Region(0, image.Rect(-10, -10, 5, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outside.NRGBAAt(0, 0).A != 0 || outside.NRGBAAt(14, 14) != src.NRGBAAt(4, 4) {
+		t.Error("region overlapping the edge of the level is wrong")
+	}
+
+	for level := 1; level < p.Height(); level++ {
+		if _, err := p.Region(level, p.Bounds(level)); err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		if p.CachedBytes() > 64*64*4*3 {
+			t.Errorf("level %d: cache holds %d bytes, over its limit", level, p.CachedBytes())
+		}
+	}
+}
+
+func TestPyramidProgressive(t *testing.T) {
+	p, err := NewPyramidWithOptions(testImage(2000, 1500), image.Pt(50, 50), PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDatumFromPyramid(p, 5)
+	size := fyne.NewSize(400, 300)
+	d.FitDevice(size)
+	if d.Level() >= p.Height()-1 {
+		t.Fatalf("level %d leaves nothing to refine", d.Level())
+	}
+
+	_, level, _, err := d.GetAvailableImage(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != p.Height()-1 {
+		t.Errorf("first image came from level %d, not the coarsest", level)
+	}
+
+	r, _ := d.ViewRect(size)
+	calls := 0
+	if err := p.Prepare(d.Level(), r, func(done, total int) { calls++ }); err != nil {
+		t.Fatal(err)
+	}
+	if calls == 0 || !p.Ready(d.Level(), r) {
+		t.Error("prepared level is not ready")
+	}
+	if _, level, _, _ = d.GetAvailableImage(size); level != d.Level() {
+		t.Errorf("refined image came from level %d, want %d", level, d.Level())
+	}
+}
+
+func TestPyramidCancel(t *testing.T) {
+	p, err := NewPyramidWithOptions(testImage(2000, 1500), image.Pt(20, 20), PyramidOptions{TileSize: 32, CacheLimit: DefaultTileCacheLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	err = p.Build(ctx, func(level, done, total int) {
+		if level == 2 && done == 1 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("build returned %v after cancelling", err)
+	}
+	if p.Ready(3, p.Bounds(3)) {
+		t.Error("levels after the cancellation were still made")
+	}
+
+	if err := p.Build(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	for level := 0; level < p.Height(); level++ {
+		if !p.Ready(level, p.Bounds(level)) {
+			t.Errorf("level %d not built", level)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "big.jpg") // cannot be streamed, so is decoded whole
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpeg.Encode(f, testImage(2000, 1500), nil)
+	f.Close()
+	ctx, cancel = context.WithCancel(context.Background())
+	if _, err := loadImage(ctx, storage.NewFileURI(file)); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = os.Open(file); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reads := 0
+	decoding := contextReader{ctx: ctx, r: readerFunc(func(b []byte) (int, error) { // cancelled part way through the file
+		if reads++; reads == 2 {
+			cancel()
+		}
+		return f.Read(b)
+	})}
+	if _, err := jpeg.Decode(decoding); !errors.Is(err, context.Canceled) {
+		t.Errorf("decoding returned %v after cancelling", err)
+	}
+	if _, err := NewPyramidFromFileContext(ctx, storage.NewFileURI(file), image.Pt(20, 20), DefaultPyramidOptions(), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("opening a JPEG returned %v after cancelling", err)
+	}
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(b []byte) (int, error) { return f(b) }
+
+func TestPyramidLinearLight(t *testing.T) {
+	checks := image.NewNRGBA(image.Rect(0, 0, 256, 256)) // single-pixel black and white checks average to half the light
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			v := uint8(255 * ((x + y) % 2))
+			checks.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	for _, c := range []struct {
+		linear   bool
+		min, max uint8
+	}{{false, 120, 135}, {true, 180, 195}} {
+		p, err := NewPyramidWithOptions(checks, image.Pt(20, 20), PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit, Filter: FilterBox, Linear: c.linear})
+		if err != nil {
+			t.Fatal(err)
+		}
+		level, err := p.Region(1, p.Bounds(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := level.NRGBAAt(60, 60).R; v < c.min || v > c.max {
+			t.Errorf("linear=%t: grey is %d, want %d-%d", c.linear, v, c.min, c.max)
+		}
+	}
+}
+
+func TestPyramidHighBitDepth(t *testing.T) {
+	grey := image.NewGray16(image.Rect(0, 0, 400, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 400; x++ {
+			grey.SetGray16(x, y, color.Gray16{uint16(1000 + x)}) // far too dark to see in 8 bits
+		}
+	}
+	p, err := NewPyramidWithOptions(grey, image.Pt(20, 20), PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Format() != FormatGray16 {
+		t.Fatalf("format is %v", p.Format())
+	}
+	pixels, err := p.Pixels(1, p.Bounds(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	level, ok := pixels.(*image.Gray16)
+	if !ok {
+		t.Fatalf("level 1 is %T", pixels)
+	}
+	if v := level.Gray16At(100, 50).Y; v < 1195 || v > 1205 {
+		t.Errorf("level 1 value is %d, want about 1200", v)
+	}
+
+	p.SetDisplayMapping(DisplayMapping{Black: 1000, White: 1400, Gamma: 1})
+	shown, err := p.Region(1, p.Bounds(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := shown.NRGBAAt(100, 50).R; v < 120 || v > 135 {
+		t.Errorf("mapped value is %d, want mid grey", v)
+	}
+
+	float := NewGray32f(image.Rect(0, 0, 200, 200))
+	for i := range float.Pix {
+		float.Pix[i] = 1e6
+	}
+	p, err = NewPyramidWithOptions(float, image.Pt(20, 20), PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := p.Pixels(p.Height()-1, p.Bounds(p.Height()-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := top.(*Gray32f).Gray32fAt(5, 5); v != 1e6 {
+		t.Errorf("float value %g was not kept", v)
+	}
+}
@@ -0,0 +1,446 @@
+package fynewidgets
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+
+	"fyne.io/fyne/v2"
+	"github.com/pkg/errors"
+)
+
+// returned by openRowDecoder for files that have to be decoded whole
+var errNotStreamable = errors.New("image cannot be decoded a row at a time")
+
+// returned when streaming a PNG that has been corrupted
+var errBadPNGCRC = errors.New("PNG chunk fails its CRC check")
+
+// rowDecoder decodes an image file from the top down, a band of rows at a time, so that the whole image is never held
+// in memory. Rows are given in the pixel format that decoding the whole file would have given the pyramid.
+type rowDecoder interface {
+	size() image.Point
+	format() PixelFormat
+	read(band draw.Image) error // fills the next rows of the image, which must be those covered by the band
+	close() error
+}
+
+// a rowDecoder for a file, or errNotStreamable if its format, or the way it was written, needs a full decode.
+// Non-interlaced PNG, uncompressed, LZW or deflate TIFF or BigTIFF strips, and baseline greyscale or YCbCr JPEG that
+// imaging would not turn can be streamed. Progressive JPEG cannot, as its rows are only complete after its last scan.
+func openRowDecoder(uri fyne.URI) (rowDecoder, error) {
+	f, err := os.Open(uri.Path())
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		f.Close()
+		return nil, errNotStreamable
+	}
+	var d rowDecoder
+	switch {
+	case bytes.Equal(magic, []byte("\x89PNG\r\n\x1a\n")):
+		d, err = newPNGRows(f)
+	case isTIFFMagic(magic):
+		d, err = newTIFFRows(f)
+	case bytes.HasPrefix(magic, []byte{0xff, 0xd8, 0xff}):
+		if _, err = f.Seek(2, io.SeekStart); err == nil {
+			d, err = newJPEGRows(f)
+		}
+	default:
+		err = errNotStreamable
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// undoes the PNG filter of a row, given the previous row (all zero for the first) and the bytes per complete pixel
+func unfilterPNG(filter byte, cur, prev []byte, bpp int) error {
+	switch filter {
+	case 0:
+	case 1:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case 2:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case 3:
+		for i := range cur {
+			left := 0
+			if i >= bpp {
+				left = int(cur[i-bpp])
+			}
+			cur[i] += byte((left + int(prev[i])) / 2)
+		}
+	case 4:
+		for i := range cur {
+			var a, c int
+			if i >= bpp {
+				a, c = int(cur[i-bpp]), int(prev[i-bpp])
+			}
+			b := int(prev[i])
+			p := a + b - c
+			pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+			switch {
+			case pa <= pb && pa <= pc:
+				cur[i] += byte(a)
+			case pb <= pc:
+				cur[i] += byte(b)
+			default:
+				cur[i] += byte(c)
+			}
+		}
+	default:
+		return errors.Errorf("bad PNG filter %d", filter)
+	}
+	return nil
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// streams the rows of a non-interlaced PNG
+type pngRows struct {
+	file        *os.File
+	pixels      io.Reader // decompressed IDAT data
+	w, h        int
+	depth       int
+	colour      int
+	palette     []color.NRGBA
+	transparent []byte // tRNS chunk: the transparent grey or colour, or alpha of each palette entry
+	bpp         int    // bytes per complete pixel, for unfiltering
+	cur, prev   []byte // filter byte and samples of this row and the last
+	row         draw.Image
+	y           int
+}
+
+// reads the header chunks of a PNG, up to its first IDAT chunk
+func newPNGRows(f *os.File) (*pngRows, error) {
+	r := bufio.NewReader(f)
+	d := &pngRows{file: f}
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, errors.Wrap(err, "reading PNG")
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		kind := string(header[4:])
+		if kind == "IDAT" {
+			if d.w == 0 {
+				return nil, errors.New("PNG has no header")
+			}
+			crc := crc32.NewIEEE()
+			crc.Write(header[4:])
+			z, err := zlib.NewReader(&idatReader{r: r, remaining: length, crc: crc})
+			if err != nil {
+				return nil, errors.Wrap(err, "reading PNG")
+			}
+			d.pixels = z
+			break
+		}
+		if length > 1<<24 {
+			return nil, errors.New("PNG chunk too long")
+		}
+		data := make([]byte, length+4) // and CRC
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, errors.Wrap(err, "reading PNG")
+		}
+		if crc32.Update(crc32.ChecksumIEEE(header[4:]), crc32.IEEETable, data[:length]) != binary.BigEndian.Uint32(data[length:]) {
+			return nil, errBadPNGCRC
+		}
+		data = data[:length]
+		switch kind {
+		case "IHDR":
+			if length != 13 {
+				return nil, errors.New("bad PNG header")
+			}
+			d.w, d.h = int(binary.BigEndian.Uint32(data[0:])), int(binary.BigEndian.Uint32(data[4:]))
+			d.depth, d.colour = int(data[8]), int(data[9])
+			if data[12] != 0 { // interlaced images only have their full rows at the end
+				return nil, errNotStreamable
+			}
+		case "PLTE":
+			for i := 0; i+2 < len(data); i += 3 {
+				d.palette = append(d.palette, color.NRGBA{data[i], data[i+1], data[i+2], 255})
+			}
+		case "tRNS":
+			d.transparent = data
+		}
+	}
+
+	channels := map[int]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}[d.colour]
+	if channels == 0 || d.w <= 0 || d.h <= 0 || (d.depth != 16 && (d.depth > 8 || (d.colour != 0 && d.colour != 3 && d.depth != 8))) {
+		return nil, errNotStreamable
+	}
+	if d.colour == 3 {
+		for i := 0; i < len(d.transparent) && i < len(d.palette); i++ {
+			d.palette[i].A = d.transparent[i]
+			d.palette[i] = color.NRGBAModel.Convert(color.RGBA64Model.Convert(d.palette[i])).(color.NRGBA) // as drawing a decoded paletted image would give
+		}
+	}
+	d.bpp = max(channels*d.depth/8, 1)
+	d.cur = make([]byte, 1+(d.w*channels*d.depth+7)/8)
+	d.prev = make([]byte, len(d.cur))
+	d.row = d.format().newImage(image.Rect(0, 0, d.w, 1))
+	return d, nil
+}
+
+func (d *pngRows) size() image.Point { return image.Pt(d.w, d.h) }
+
+// the format image/png would decode to
+func (d *pngRows) format() PixelFormat {
+	switch {
+	case d.depth == 16 && d.colour == 0 && d.transparent == nil:
+		return FormatGray16
+	case d.depth == 16:
+		return FormatNRGBA64
+	}
+	return FormatNRGBA
+}
+
+func (d *pngRows) close() error { return d.file.Close() }
+
+func (d *pngRows) read(band draw.Image) error {
+	b := band.Bounds()
+	if b.Min.Y != d.y || b.Dx() != d.w {
+		return errors.New("PNG rows must be read in order")
+	}
+	for ; d.y < min(b.Max.Y, d.h); d.y++ {
+		d.cur, d.prev = d.prev, d.cur
+		if _, err := io.ReadFull(d.pixels, d.cur); err != nil {
+			return errors.Wrap(err, "reading PNG")
+		}
+		if err := unfilterPNG(d.cur[0], d.cur[1:], d.prev[1:], d.bpp); err != nil {
+			return err
+		}
+		d.convert(d.cur[1:])
+		blit(band, image.Rect(0, d.y, d.w, d.y+1), d.row, image.Point{})
+	}
+	if d.y == d.h { // read to the end, to check the zlib checksum and the CRC of the last chunk
+		if _, err := io.Copy(io.Discard, d.pixels); err != nil {
+			return errors.Wrap(err, "reading PNG")
+		}
+	}
+	return nil
+}
+
+// fills the row image from the unfiltered samples of a row
+func (d *pngRows) convert(s []byte) {
+	switch row := d.row.(type) {
+	case *image.Gray16: // big-endian, as in the file
+		copy(row.Pix, s)
+	case *image.NRGBA64:
+		for x := 0; x < d.w; x++ {
+			p := row.Pix[8*x : 8*x+8]
+			switch d.colour {
+			case 0:
+				for c := 0; c < 3; c++ {
+					copy(p[2*c:2*c+2], s[2*x:])
+				}
+				p[6], p[7] = 0xff, 0xff
+				if len(d.transparent) >= 2 && bytes.Equal(s[2*x:2*x+2], d.transparent[:2]) {
+					p[6], p[7] = 0, 0
+				}
+			case 2:
+				copy(p[0:6], s[6*x:])
+				p[6], p[7] = 0xff, 0xff
+				if len(d.transparent) >= 6 && bytes.Equal(s[6*x:6*x+6], d.transparent[:6]) {
+					p[6], p[7] = 0, 0
+				}
+			case 4:
+				for c := 0; c < 3; c++ {
+					copy(p[2*c:2*c+2], s[4*x:])
+				}
+				copy(p[6:8], s[4*x+2:])
+			case 6:
+				copy(p, s[8*x:])
+			}
+		}
+	case *image.NRGBA:
+		for x := 0; x < d.w; x++ {
+			p := row.Pix[4*x : 4*x+4]
+			switch d.colour {
+			case 0:
+				v := d.sample(s, x)
+				p[0], p[1], p[2], p[3] = v, v, v, 0xff
+				if len(d.transparent) >= 2 && int(binary.BigEndian.Uint16(d.transparent)) == d.bits(s, x) {
+					p[3] = 0
+				}
+			case 2:
+				p[0], p[1], p[2], p[3] = s[3*x], s[3*x+1], s[3*x+2], 0xff
+				if len(d.transparent) >= 6 && s[3*x] == d.transparent[1] && s[3*x+1] == d.transparent[3] && s[3*x+2] == d.transparent[5] {
+					p[3] = 0
+				}
+			case 3:
+				c := color.NRGBA{A: 0xff}
+				if i := d.bits(s, x); i < len(d.palette) {
+					c = d.palette[i]
+				}
+				p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+			case 4:
+				p[0], p[1], p[2], p[3] = s[2*x], s[2*x], s[2*x], s[2*x+1]
+			case 6:
+				copy(p, s[4*x:])
+			}
+		}
+	}
+}
+
+// the raw value of a one-sample pixel of 8 bits or fewer
+func (d *pngRows) bits(s []byte, x int) int {
+	if d.depth == 8 {
+		return int(s[x])
+	}
+	perbyte := 8 / d.depth
+	shift := 8 - d.depth*(x%perbyte+1)
+	return int(s[x/perbyte]>>shift) & (1<<d.depth - 1)
+}
+
+// a grey sample of 8 bits or fewer, scaled to 8 bits
+func (d *pngRows) sample(s []byte, x int) uint8 {
+	return uint8(d.bits(s, x) * 255 / (1<<d.depth - 1))
+}
+
+// reads the data of consecutive IDAT chunks as one stream
+type idatReader struct {
+	r         *bufio.Reader
+	remaining uint32
+	crc       hash.Hash32 // of the type and data of the chunk being read, until its CRC is checked
+	done      bool
+}
+
+func (i *idatReader) Read(p []byte) (int, error) {
+	for i.remaining == 0 {
+		if err := i.check(); err != nil {
+			return 0, err
+		}
+		if i.done {
+			return 0, io.EOF
+		}
+		header := make([]byte, 8) // length and type of the next chunk
+		if _, err := io.ReadFull(i.r, header); err != nil {
+			return 0, err
+		}
+		if string(header[4:]) != "IDAT" {
+			i.done = true
+			return 0, io.EOF
+		}
+		i.remaining = binary.BigEndian.Uint32(header[:4])
+		i.crc = crc32.NewIEEE()
+		i.crc.Write(header[4:])
+	}
+	n, err := i.r.Read(p[:min(len(p), int(i.remaining))])
+	i.crc.Write(p[:n])
+	i.remaining -= uint32(n)
+	if i.remaining == 0 && err == nil {
+		if err := i.check(); err != nil {
+			return 0, err // holding back the end of the chunk, as zlib only sees errors once it needs more
+		}
+	}
+	return n, err
+}
+
+// reads the CRC of a chunk whose data has all been read, and compares it with that of the data
+func (i *idatReader) check() error {
+	if i.crc == nil {
+		return nil
+	}
+	crc := make([]byte, 4)
+	if _, err := io.ReadFull(i.r, crc); err != nil {
+		return err
+	}
+	sum := i.crc.Sum32()
+	i.crc = nil
+	if sum != binary.BigEndian.Uint32(crc) {
+		return errBadPNGCRC
+	}
+	return nil
+}
+
+// streams the rows of the first image of a TIFF or BigTIFF stored in strips
+type tiffRows struct {
+	file      *os.File
+	ifd       *tiffIFD
+	strip     int       // next strip to open
+	stripleft int       // rows left in the open strip
+	pixels    io.Reader // decompressed samples of the open strip
+	raw       []byte
+	y         int
+}
+
+func newTIFFRows(f *os.File) (*tiffRows, error) {
+	order, big, first, err := readTIFFHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	ifd, _, err := readTIFFIFD(f, info.Size(), order, big, first)
+	if err != nil {
+		return nil, err
+	}
+	if ifd.tiled || ifd.compression == 7 || ifd.supported() != nil {
+		return nil, errNotStreamable
+	}
+	return &tiffRows{file: f, ifd: ifd, raw: make([]byte, ifd.rowBytes())}, nil
+}
+
+func (d *tiffRows) size() image.Point { return image.Pt(d.ifd.width, d.ifd.height) }
+
+func (d *tiffRows) format() PixelFormat { return d.ifd.format() }
+
+func (d *tiffRows) close() error { return d.file.Close() }
+
+func (d *tiffRows) read(band draw.Image) error {
+	b := band.Bounds()
+	if b.Min.Y != d.y || b.Dx() != d.ifd.width {
+		return errors.New("TIFF rows must be read in order")
+	}
+	for ; d.y < min(b.Max.Y, d.ifd.height); d.y++ {
+		if d.stripleft == 0 {
+			if err := d.openStrip(); err != nil {
+				return err
+			}
+		}
+		if _, err := io.ReadFull(d.pixels, d.raw); err != nil {
+			return errors.Wrap(err, "reading TIFF strip")
+		}
+		d.stripleft--
+		d.ifd.convert(d.raw, band, 0, d.y, d.ifd.width)
+	}
+	return nil
+}
+
+// starts decompressing the next strip
+func (d *tiffRows) openStrip() error {
+	if d.strip >= len(d.ifd.offsets) {
+		return errors.New("TIFF has too few strips")
+	}
+	pixels, err := d.ifd.stripReader(d.file, d.strip)
+	if err != nil {
+		return errors.Wrap(err, "reading TIFF strip")
+	}
+	d.pixels = pixels
+	d.strip++
+	d.stripleft = d.ifd.tileheight
+	return nil
+}
@@ -0,0 +1,144 @@
+package fynewidgets
+
+import (
+	"container/list"
+	"image"
+	"sync"
+)
+
+const DefaultTileSize int = 256             // width and height of pyramid tiles, in pixels of their own level
+const DefaultTileCacheLimit int = 256 << 20 // bytes of tiles a pyramid keeps before evicting the least recently used
+
+// identifies a tile by pyramid level, and column and row within that level
+type tileKey struct {
+	level, col, row int
+}
+
+type tileEntry struct {
+	key    tileKey
+	tile   image.Image // in the pyramid's format
+	size   int         // bytes held by the tile, zero if it shares memory with the source image
+	pinned bool        // pinned tiles are never evicted (coarse levels are cheap to keep and expensive to rebuild)
+}
+
+// least-recently-used store of pyramid tiles, limited by the number of bytes held
+type tileCache struct {
+	mu      sync.Mutex
+	limit   int        // maximum bytes held by unpinned tiles
+	used    int        // bytes currently held by unpinned tiles
+	kept    int        // bytes held by pinned tiles
+	order   *list.List // most recently used at the front
+	entries map[tileKey]*list.Element
+}
+
+func newTileCache(limit int) *tileCache {
+	return &tileCache{limit: limit, order: list.New(), entries: make(map[tileKey]*list.Element)}
+}
+
+// returns a cached tile, marking it as recently used
+func (c *tileCache) get(key tileKey) (image.Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*tileEntry).tile, true
+}
+
+// adds a tile, evicting the least recently used unpinned tiles if the limit is exceeded
+func (c *tileCache) put(key tileKey, tile image.Image, size int, pinned bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.entries[key] = c.order.PushFront(&tileEntry{key: key, tile: tile, size: size, pinned: pinned})
+	if pinned {
+		c.kept += size
+	} else {
+		c.used += size
+	}
+	c.evict()
+}
+
+// changes the limit, evicting tiles straight away if necessary
+func (c *tileCache) setLimit(limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limit = limit
+	c.evict()
+}
+
+// bytes currently held by unpinned tiles
+func (c *tileCache) bytes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+// bytes held by every tile, pinned or not
+func (c *tileCache) allBytes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used + c.kept
+}
+
+// drops every tile of a level, even pinned ones
+func (c *tileCache) dropLevel(level int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.order.Back(); e != nil; {
+		prev := e.Prev()
+		if e.Value.(*tileEntry).key.level == level {
+			c.remove(e)
+		}
+		e = prev
+	}
+}
+
+// drops every tile, even pinned ones
+func (c *tileCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[tileKey]*list.Element)
+	c.used, c.kept = 0, 0
+}
+
+// drops every unpinned tile
+func (c *tileCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.order.Back(); e != nil; {
+		prev := e.Prev()
+		if !e.Value.(*tileEntry).pinned {
+			c.remove(e)
+		}
+		e = prev
+	}
+}
+
+// must be called with the lock held
+func (c *tileCache) evict() {
+	for e := c.order.Back(); e != nil && c.used > c.limit; {
+		prev := e.Prev()
+		if !e.Value.(*tileEntry).pinned {
+			c.remove(e)
+		}
+		e = prev
+	}
+}
+
+// must be called with the lock held
+func (c *tileCache) remove(e *list.Element) {
+	entry := e.Value.(*tileEntry)
+	if entry.pinned {
+		c.kept -= entry.size
+	} else {
+		c.used -= entry.size
+	}
+	c.order.Remove(e)
+	delete(c.entries, entry.key)
+}
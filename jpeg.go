@@ -0,0 +1,653 @@
+package fynewidgets
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"math/bits"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// streams the rows of a baseline JPEG. The standard library only decodes whole images, so the blocks of each band of
+// rows are taken from the file's scan and written out as a small JPEG of their own, with the file's quantisation
+// tables, which is decoded instead. Blocks are decoded independently of one another, so a band's pixels are exactly
+// those of the same rows of the whole image.
+type jpegRows struct {
+	file       *os.File
+	bits       jpegBits
+	w, h       int
+	sof        byte            // the frame marker, for the bands
+	comps      []jpegComponent // in the order of the frame header
+	scan       []int           // components in the order of the scan
+	quant      [4][]byte       // each quantisation table as it appears in a DQT segment
+	dc, ac     [4]*jpegHuffman // by table number
+	mcuw, mcuh int             // size of a minimum coded unit, in pixels
+	across     int             // MCUs in each row
+	restart    int             // MCUs between restart markers, or 0 for none
+	mcu        int             // next MCU of the scan
+	eobrun     int             // blocks still to come with no AC coefficients, after an end-of-band run
+	strip      image.Image     // the last band decoded, in the image's coordinates
+	y          int
+}
+
+type jpegComponent struct {
+	id     byte
+	hv, tq byte // sampling and quantisation table, as in the frame header
+	h, v   int  // blocks in each MCU
+	td, ta int  // Huffman tables of the scan
+	pred   int  // DC coefficient of the last block read from the file
+	out    int  // DC coefficient of the last block written to the band
+}
+
+// reads the headers of a JPEG, up to the start of its scan. f is just after its SOI marker.
+func newJPEGRows(f *os.File) (*jpegRows, error) {
+	r := bufio.NewReader(f)
+	d := &jpegRows{file: f, bits: jpegBits{r: r}}
+	jfif, adobe, app1 := false, -1, false
+	for {
+		marker, err := readJPEGMarker(r)
+		if err != nil {
+			return nil, err
+		}
+		if marker == 0xd8 || marker == 0x01 || marker >= 0xd0 && marker <= 0xd7 { // no segment follows
+			continue
+		}
+		if marker == 0xd9 {
+			return nil, errors.New("JPEG has no scan")
+		}
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return nil, errors.Wrap(err, "reading JPEG")
+		}
+		n := int(binary.BigEndian.Uint16(length)) - 2
+		if n < 0 {
+			return nil, errors.New("bad JPEG segment")
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, errors.Wrap(err, "reading JPEG")
+		}
+		switch {
+		case marker == 0xc0 || marker == 0xc1:
+			if err := d.frame(marker, data); err != nil {
+				return nil, err
+			}
+		case marker >= 0xc2 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			return nil, errNotStreamable // progressive, lossless or arithmetic coded
+		case marker == 0xc4:
+			if err := d.huffman(data); err != nil {
+				return nil, err
+			}
+		case marker == 0xdb:
+			if err := d.quantisation(data); err != nil {
+				return nil, err
+			}
+		case marker == 0xdd:
+			if n < 2 {
+				return nil, errors.New("bad JPEG restart interval")
+			}
+			d.restart = int(binary.BigEndian.Uint16(data))
+		case marker == 0xe0:
+			jfif = jfif || bytes.HasPrefix(data, []byte("JFIF\x00"))
+		case marker == 0xe1:
+			if !app1 && jpegOrientation(data) > 1 { // imaging turns the whole image to suit
+				return nil, errNotStreamable
+			}
+			app1 = true
+		case marker == 0xee:
+			if n >= 12 && bytes.HasPrefix(data, []byte("Adobe")) {
+				adobe = int(data[11])
+			}
+		case marker == 0xda:
+			if err := d.startScan(data); err != nil {
+				return nil, err
+			}
+			if len(d.comps) == 3 && !jfif && (adobe == 0 || string([]byte{d.comps[0].id, d.comps[1].id, d.comps[2].id}) == "RGB") {
+				return nil, errNotStreamable // stored as RGB, which image/jpeg decodes to another type of image
+			}
+			return d, nil
+		}
+	}
+}
+
+// the next marker, skipping any fill bytes before it
+func readJPEGMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, errors.Wrap(err, "reading JPEG")
+	}
+	if b != 0xff {
+		return 0, errors.New("JPEG marker expected")
+	}
+	for b == 0xff {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, errors.Wrap(err, "reading JPEG")
+		}
+	}
+	return b, nil
+}
+
+// reads the frame header. Only greyscale, and YCbCr with one of the subsamplings of image.YCbCr, can be streamed.
+func (d *jpegRows) frame(marker byte, data []byte) error {
+	if len(d.comps) > 0 {
+		return errors.New("JPEG has more than one frame")
+	}
+	if len(data) < 6 || data[0] != 8 {
+		return errNotStreamable
+	}
+	d.sof = marker
+	d.h, d.w = int(binary.BigEndian.Uint16(data[1:])), int(binary.BigEndian.Uint16(data[3:]))
+	n := int(data[5])
+	if d.w == 0 || d.h == 0 || (n != 1 && n != 3) || len(data) < 6+3*n {
+		return errNotStreamable // no height means it is given after the scan
+	}
+	for i := 0; i < n; i++ {
+		c := data[6+3*i:]
+		d.comps = append(d.comps, jpegComponent{id: c[0], hv: c[1], tq: c[2] & 3, h: int(c[1] >> 4), v: int(c[1] & 15)})
+	}
+	if n == 1 {
+		d.comps[0].h, d.comps[0].v, d.comps[0].hv = 1, 1, 0x11 // a single component is never interleaved
+	} else {
+		switch d.comps[0].hv {
+		case 0x11, 0x12, 0x21, 0x22, 0x41, 0x42:
+		default:
+			return errNotStreamable
+		}
+		if d.comps[1].hv != 0x11 || d.comps[2].hv != 0x11 {
+			return errNotStreamable
+		}
+	}
+	d.mcuw, d.mcuh = 8*d.comps[0].h, 8*d.comps[0].v
+	d.across = (d.w + d.mcuw - 1) / d.mcuw
+	return nil
+}
+
+func (d *jpegRows) huffman(data []byte) error {
+	for len(data) > 0 {
+		if len(data) < 17 {
+			return errors.New("bad JPEG Huffman table")
+		}
+		class, id := data[0]>>4, data[0]&15
+		counts := data[1:17]
+		n := 0
+		for _, c := range counts {
+			n += int(c)
+		}
+		if class > 1 || id > 3 || len(data) < 17+n {
+			return errors.New("bad JPEG Huffman table")
+		}
+		h, err := newJPEGHuffman(counts, data[17:17+n])
+		if err != nil {
+			return err
+		}
+		if class == 0 {
+			d.dc[id] = h
+		} else {
+			d.ac[id] = h
+		}
+		data = data[17+n:]
+	}
+	return nil
+}
+
+func (d *jpegRows) quantisation(data []byte) error {
+	for len(data) > 0 {
+		size := 1 + 64*(1+int(data[0]>>4))
+		if data[0]>>4 > 1 || data[0]&15 > 3 || len(data) < size {
+			return errors.New("bad JPEG quantisation table")
+		}
+		d.quant[data[0]&15] = data[:size]
+		data = data[size:]
+	}
+	return nil
+}
+
+// reads the scan header. The scan has to hold every component, or the rows of the image could not be had in order.
+func (d *jpegRows) startScan(data []byte) error {
+	if len(d.comps) == 0 {
+		return errors.New("JPEG scan has no frame")
+	}
+	if len(data) < 1 || int(data[0]) != len(d.comps) || len(data) < 4+2*len(d.comps) {
+		return errNotStreamable
+	}
+	for i := range d.comps {
+		s := data[1+2*i:]
+		c := -1
+		for j := range d.comps {
+			if d.comps[j].id == s[0] {
+				c = j
+			}
+		}
+		if c < 0 || s[1]>>4 > 3 || s[1]&15 > 3 || d.dc[s[1]>>4] == nil || d.ac[s[1]&15] == nil || d.quant[d.comps[c].tq] == nil {
+			return errors.New("bad JPEG scan")
+		}
+		for _, other := range d.scan {
+			if other == c {
+				return errors.New("bad JPEG scan")
+			}
+		}
+		d.comps[c].td, d.comps[c].ta = int(s[1]>>4), int(s[1]&15)
+		d.scan = append(d.scan, c)
+	}
+	return nil
+}
+
+func (d *jpegRows) size() image.Point { return image.Pt(d.w, d.h) }
+
+// the format imaging's decode would give the pyramid, from an image.Gray or image.YCbCr
+func (d *jpegRows) format() PixelFormat { return FormatNRGBA }
+
+func (d *jpegRows) close() error { return d.file.Close() }
+
+func (d *jpegRows) read(band draw.Image) error {
+	b := band.Bounds()
+	if b.Min.Y != d.y || b.Dx() != d.w {
+		return errors.New("JPEG rows must be read in order")
+	}
+	end := min(b.Max.Y, d.h)
+	if d.strip != nil {
+		blit(band, b, d.strip, b.Min)
+	}
+	if d.strip == nil || d.strip.Bounds().Max.Y < end {
+		strip, err := d.decode((end + d.mcuh - 1) / d.mcuh)
+		if err != nil {
+			return err
+		}
+		d.strip = strip
+		blit(band, b.Intersect(strip.Bounds()), strip, b.Intersect(strip.Bounds()).Min)
+	}
+	d.y = end
+	return nil
+}
+
+// decodes the rows of MCUs from the next one up to a row, returning an image of them in the image's coordinates
+func (d *jpegRows) decode(end int) (image.Image, error) {
+	y := d.mcu / d.across * d.mcuh
+	out := &bytes.Buffer{}
+	d.header(out, min(end*d.mcuh, d.h)-y)
+	w := &jpegBitWriter{out: out}
+	for _, c := range d.scan {
+		d.comps[c].out = 0
+	}
+	for ; d.mcu < end*d.across; d.mcu++ {
+		if d.restart > 0 && d.mcu > 0 && d.mcu%d.restart == 0 {
+			if err := d.bits.restart(byte(0xd0 + (d.mcu/d.restart-1)%8)); err != nil {
+				return nil, err
+			}
+			for _, c := range d.scan {
+				d.comps[c].pred = 0
+			}
+			d.eobrun = 0
+		}
+		for _, c := range d.scan {
+			for i := 0; i < d.comps[c].h*d.comps[c].v; i++ {
+				if err := d.block(&d.comps[c], w); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	w.flush()
+	out.Write([]byte{0xff, 0xd9})
+
+	img, err := jpeg.Decode(out)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding JPEG rows")
+	}
+	switch img := img.(type) {
+	case *image.Gray:
+		img.Rect = img.Rect.Add(image.Pt(0, y))
+		return img, nil
+	case *image.YCbCr: // its chroma is found from the top of its bounds, which are at the top of a row of MCUs
+		img.Rect = img.Rect.Add(image.Pt(0, y))
+		return img, nil
+	}
+	return nil, errors.New("JPEG rows decoded to an unexpected image")
+}
+
+// writes the headers of a band's JPEG: the file's quantisation tables and sampling, and a Huffman table that has a
+// code for every symbol
+func (d *jpegRows) header(out *bytes.Buffer, height int) {
+	out.Write([]byte{0xff, 0xd8})
+	segment := func(marker byte, data ...[]byte) {
+		n := 2
+		for _, b := range data {
+			n += len(b)
+		}
+		out.Write([]byte{0xff, marker, byte(n >> 8), byte(n)})
+		for _, b := range data {
+			out.Write(b)
+		}
+	}
+	used := [4]bool{}
+	for _, c := range d.comps {
+		if !used[c.tq] {
+			used[c.tq] = true
+			segment(0xdb, d.quant[c.tq])
+		}
+	}
+	frame := []byte{8, byte(height >> 8), byte(height), byte(d.w >> 8), byte(d.w), byte(len(d.comps))}
+	for i, c := range d.comps {
+		frame = append(frame, byte(i+1), c.hv, c.tq) // numbered afresh, as some identifiers would mark the band as RGB
+	}
+	segment(d.sof, frame)
+	table := make([]byte, 17+256)
+	table[8], table[9] = 255, 1 // 8-bit codes for all but the last symbol, which has a 9-bit one
+	for i := 0; i < 256; i++ {
+		table[17+i] = byte(i)
+	}
+	segment(0xc4, []byte{0x00}, table[1:], []byte{0x10}, table[1:])
+	scan := []byte{byte(len(d.scan))}
+	for _, c := range d.scan {
+		scan = append(scan, byte(c+1), 0x00)
+	}
+	segment(0xda, scan, []byte{0, 63, 0})
+}
+
+// reads a block of a component from the file and writes it to the band, as image/jpeg would read it
+func (d *jpegRows) block(c *jpegComponent, w *jpegBitWriter) error {
+	t, err := d.bits.decode(d.dc[c.td])
+	if err != nil {
+		return err
+	}
+	if t > 16 {
+		return errors.New("JPEG DC coefficient too large")
+	}
+	diff, err := d.bits.receive(int(t))
+	if err != nil {
+		return err
+	}
+	c.pred += extendJPEG(diff, int(t))
+	if err := w.coefficient(c.pred - c.out); err != nil {
+		return err
+	}
+	c.out = c.pred
+
+	if d.eobrun > 0 {
+		d.eobrun--
+		w.symbol(0x00)
+		return nil
+	}
+	for k := 1; k <= 63; k++ {
+		rs, err := d.bits.decode(d.ac[c.ta])
+		if err != nil {
+			return err
+		}
+		r, s := int(rs>>4), int(rs&15)
+		if s == 0 {
+			if r != 15 { // the end of the block, or of a run of blocks
+				d.eobrun = 1 << r
+				if r > 0 {
+					more, err := d.bits.receive(r)
+					if err != nil {
+						return err
+					}
+					d.eobrun |= int(more)
+				}
+				d.eobrun--
+				w.symbol(0x00)
+				return nil
+			}
+			k += 15
+			w.symbol(0xf0)
+			continue
+		}
+		if k += r; k > 63 {
+			w.symbol(0x00)
+			return nil
+		}
+		v, err := d.bits.receive(s)
+		if err != nil {
+			return err
+		}
+		w.symbol(rs)
+		w.put(v, s)
+	}
+	return nil
+}
+
+// the value of n extra bits of a coefficient
+func extendJPEG(v uint32, n int) int {
+	if n == 0 {
+		return 0
+	}
+	if v < 1<<(n-1) {
+		return int(v) - 1<<n + 1
+	}
+	return int(v)
+}
+
+// the orientation given in the Exif data of an APP1 segment, or 0 if it has none, read as imaging reads it
+func jpegOrientation(data []byte) int {
+	if len(data) < 14 || string(data[:4]) != "Exif" {
+		return 0
+	}
+	tiff := data[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	at := int(order.Uint32(tiff[4:]))
+	if at < 8 || at+2 > len(tiff) {
+		return 0
+	}
+	n := int(order.Uint16(tiff[at:]))
+	for i := 0; i < n; i++ {
+		e := at + 2 + 12*i
+		if e+10 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// a Huffman table read from a JPEG
+type jpegHuffman struct {
+	lut     [256]uint16 // value<<8 | length of the code starting with each byte, for codes of 8 bits or fewer
+	maxcode [17]int32   // largest code of each length, or -1 if there are none
+	offset  [17]int32   // index in vals of the value of a code of each length, less the code
+	vals    []byte
+}
+
+func newJPEGHuffman(counts, vals []byte) (*jpegHuffman, error) {
+	h := &jpegHuffman{vals: vals}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		if code+n > 1<<l {
+			return nil, errors.New("bad JPEG Huffman table")
+		}
+		h.maxcode[l], h.offset[l] = code+n-1, k-code
+		if n == 0 {
+			h.maxcode[l] = -1
+		}
+		for i := int32(0); l <= 8 && i < n; i++ {
+			first := (code + i) << (8 - l)
+			for j := first; j < first+1<<(8-l); j++ {
+				h.lut[j] = uint16(vals[k+i])<<8 | uint16(l)
+			}
+		}
+		code, k = (code+n)<<1, k+n
+	}
+	return h, nil
+}
+
+// reads the bits of a JPEG's scan, taking out the zero bytes stuffed after each 0xff. The scan ends at a marker, after
+// which only zero bits are given, as a code may be looked for in more bits than are left.
+type jpegBits struct {
+	r      *bufio.Reader
+	acc    uint64
+	n      int  // bits held in the bottom of acc
+	pad    int  // of those, zero bits after the end of the scan
+	marker byte // the marker that ended the scan, or 0
+}
+
+var errJPEGShort = errors.New("JPEG scan ends early")
+
+// makes sure that at least n bits are held
+func (b *jpegBits) fill(n int) error {
+	for b.n < n {
+		c := byte(0)
+		if b.marker == 0 {
+			x, err := b.r.ReadByte()
+			if err != nil {
+				return errors.Wrap(err, "reading JPEG")
+			}
+			if x == 0xff {
+				m, err := readJPEGMarkerAfterFF(b.r)
+				if err != nil {
+					return err
+				}
+				if m == 0 {
+					c = 0xff
+				} else {
+					b.marker = m
+				}
+			} else {
+				c = x
+			}
+		}
+		if b.marker != 0 {
+			b.pad += 8
+		}
+		b.acc = b.acc<<8 | uint64(c)
+		b.n += 8
+	}
+	return nil
+}
+
+// the byte after a 0xff in a scan, skipping fill bytes: 0 for a stuffed 0xff, or the marker
+func readJPEGMarkerAfterFF(r *bufio.Reader) (byte, error) {
+	for {
+		m, err := r.ReadByte()
+		if err != nil {
+			return 0, errors.Wrap(err, "reading JPEG")
+		}
+		if m != 0xff {
+			return m, nil
+		}
+	}
+}
+
+// drops n bits, which must all have been in the scan
+func (b *jpegBits) skip(n int) error {
+	b.n -= n
+	if b.n < b.pad {
+		return errJPEGShort
+	}
+	return nil
+}
+
+func (b *jpegBits) decode(h *jpegHuffman) (byte, error) {
+	if err := b.fill(16); err != nil {
+		return 0, err
+	}
+	peek := int32(b.acc>>(b.n-16)) & 0xffff
+	if e := h.lut[peek>>8]; e != 0 {
+		return byte(e >> 8), b.skip(int(e & 0xff))
+	}
+	for l := 9; l <= 16; l++ {
+		if code := peek >> (16 - l); code <= h.maxcode[l] {
+			return h.vals[h.offset[l]+code], b.skip(l)
+		}
+	}
+	return 0, errors.New("bad JPEG Huffman code")
+}
+
+// the next n bits, as they are
+func (b *jpegBits) receive(n int) (uint32, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if err := b.fill(n); err != nil {
+		return 0, err
+	}
+	v := uint32(b.acc>>(b.n-n)) & (1<<n - 1)
+	return v, b.skip(n)
+}
+
+// drops the rest of the byte, and reads the restart marker that should follow it
+func (b *jpegBits) restart(marker byte) error {
+	if b.marker == 0 {
+		x, err := b.r.ReadByte()
+		if err != nil {
+			return errors.Wrap(err, "reading JPEG")
+		}
+		if x != 0xff {
+			return errors.New("JPEG restart marker expected")
+		}
+		if b.marker, err = readJPEGMarkerAfterFF(b.r); err != nil {
+			return err
+		}
+	}
+	if b.marker != marker {
+		return errors.New("JPEG restart marker expected")
+	}
+	b.acc, b.n, b.pad, b.marker = 0, 0, 0, 0
+	return nil
+}
+
+// writes the scan of a band, with the Huffman table written by header
+type jpegBitWriter struct {
+	out *bytes.Buffer
+	acc uint32
+	n   int
+}
+
+// writes the bottom n bits of v, n being 16 or fewer
+func (w *jpegBitWriter) put(v uint32, n int) {
+	w.acc = w.acc<<n | v&(1<<n-1)
+	for w.n += n; w.n >= 8; w.n -= 8 {
+		c := byte(w.acc >> (w.n - 8))
+		w.out.WriteByte(c)
+		if c == 0xff {
+			w.out.WriteByte(0)
+		}
+	}
+}
+
+func (w *jpegBitWriter) symbol(s byte) {
+	if s == 255 {
+		w.put(0x1fe, 9)
+		return
+	}
+	w.put(uint32(s), 8)
+}
+
+// writes a DC coefficient's difference from the last
+func (w *jpegBitWriter) coefficient(v int) error {
+	n := bits.Len(uint(max(v, -v)))
+	if n > 16 {
+		return errors.New("JPEG DC coefficient too large")
+	}
+	w.symbol(byte(n))
+	if v < 0 {
+		v--
+	}
+	w.put(uint32(v), n)
+	return nil
+}
+
+// pads the last byte with ones
+func (w *jpegBitWriter) flush() {
+	if w.n > 0 {
+		w.put(0xff, 8-w.n)
+	}
+}
@@ -0,0 +1,132 @@
+package fynewidgets
+
+import (
+	"image/color"
+	"unicode/utf8"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/theme"
+	"github.com/pkg/errors"
+)
+
+const keyPanStep = 0.1 // fraction of the view an arrow key pans by
+
+var _ fyne.Focusable = (*PanZoomCanvas)(nil)
+
+// moves the image by a distance on the device, keeping to the constraints
+func (d *Datum) Pan(dx, dy float32) error {
+	if d.DeviceCoords == nil || d.Scale < 0 {
+		return errors.New("f: Datum.Pan - no datum yet")
+	}
+	device := d.DeviceCoords.AddXY(dx, dy)
+	d.DeviceCoords = &device
+	d.Constrain()
+	return nil
+}
+
+// the keyboard is used once the widget is focused, by tabbing to it or clicking it
+func (p *PanZoomCanvas) FocusGained() {
+	p.showFocus(true)
+}
+
+func (p *PanZoomCanvas) FocusLost() {
+	p.showFocus(false)
+}
+
+// outlines the widget while it has the keyboard
+func (p *PanZoomCanvas) showFocus(focused bool) {
+	p.mu.Lock()
+	outline := p.outline
+	p.mu.Unlock()
+	if outline == nil {
+		return
+	}
+	if focused {
+		outline.Show()
+	} else {
+		outline.Hide()
+	}
+	outline.Refresh()
+}
+
+// a hidden outline, in the theme's focus colour, to show over the image while the widget is focused
+func (p *PanZoomCanvas) newOutline() *canvas.Rectangle {
+	outline := canvas.NewRectangle(color.Transparent)
+	outline.StrokeColor = theme.FocusColor()
+	outline.StrokeWidth = 2
+	outline.Hide()
+	p.mu.Lock()
+	p.outline = outline
+	p.mu.Unlock()
+	return outline
+}
+
+// takes the keyboard, as a click on the widget does
+func (p *PanZoomCanvas) requestFocus() {
+	if fyne.CurrentApp() == nil {
+		return
+	}
+	if c := fyne.CurrentApp().Driver().CanvasForObject(p); c != nil {
+		c.Focus(p)
+	}
+}
+
+// keys with names, such as the arrow keys, do what the widget's InputBindings say. Keys that type a character do so
+// through TypedRune instead.
+func (p *PanZoomCanvas) TypedKey(event *fyne.KeyEvent) {
+	if utf8.RuneCountInString(string(event.Name)) > 1 {
+		p.perform(p.action(KeyInput(event.Name, 0)), p.centre())
+	}
+}
+
+// characters typed do what the widget's InputBindings say
+func (p *PanZoomCanvas) TypedRune(r rune) {
+	p.perform(p.action(string(r)), p.centre())
+}
+
+// centre of the widget on the device
+func (p *PanZoomCanvas) centre() fyne.Position {
+	size := p.canvas.Size()
+	return fyne.NewPos(size.Width/2, size.Height/2)
+}
+
+// moves the view a step across the image, in a direction along each device axis: -1 for left or up, 1 for right or
+// down
+func (p *PanZoomCanvas) pan(x, y float32) {
+	size := p.canvas.Size()
+	p.panBy(-x*keyPanStep*size.Width, -y*keyPanStep*size.Height) // the image moves the other way
+}
+
+// fits the whole image to the view
+func (p *PanZoomCanvas) fit() {
+	size := p.canvas.Size()
+	p.changeView("fit", func(d *Datum) error { return d.FitDevice(size) })
+}
+
+// zooms about a device position by a number of ticks. Ticks are counted from the view being glided to, so keys
+// pressed quickly zoom as far as they would slowly.
+func (p *PanZoomCanvas) zoomAt(at fyne.Position, ticks func(d *Datum) int) {
+	p.changeView("zoom", func(d *Datum) error {
+		return d.ChangeProjection(at, TickScaleToFloatScale(d.Ticks+ticks(d), d.Sensitivity))
+	})
+}
+
+// moves the image a distance on the device. It steps straight there, as a held key or a scroll repeats faster than
+// the view could glide.
+func (p *PanZoomCanvas) panBy(dx, dy float32) {
+	p.stopMoving()
+	p.mu.Lock()
+	if p.datum == nil || p.datum.Scale < 0 {
+		p.mu.Unlock()
+		return
+	}
+	err := p.datum.Pan(dx, dy)
+	if err == nil {
+		p.rememberView("pan")
+	}
+	p.mu.Unlock()
+	if err == nil {
+		p.changed()
+	}
+}
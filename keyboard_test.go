@@ -0,0 +1,94 @@
+package fynewidgets
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+	eventbus "github.com/dtomasi/go-event-bus/v3"
+)
+
+func TestKeyboardNavigation(t *testing.T) {
+	test.NewTempApp(t)
+	bus := eventbus.NewEventBus()
+	p, w := newTestPanZoom(t, bus)
+
+	var published atomic.Int32
+	ch := bus.Subscribe("datum:changed")
+	go func() {
+		for x := range ch {
+			if e, ok := x.Data.(DatumChanged); ok && e.Source == p {
+				published.Add(1)
+			}
+		}
+	}()
+
+	w.Canvas().Focus(p)
+	if p.outline == nil || !p.outline.Visible() {
+		t.Error("focused widget is not outlined")
+	}
+	centre := p.centre()
+	shown := func() (ImagePoint, int) { return shownAt(p, centre) }
+	fitted, fittedTicks := shown()
+	start, _ := p.Snapshot()
+	octave := start.Sensitivity
+
+	steps := []struct {
+		name  string
+		press func()
+		check func(before, after ImagePoint, ticks int) bool
+	}{
+		{"0 shows 100%", func() { p.TypedRune('0') }, func(b, a ImagePoint, ticks int) bool { return ticks == 0 && near(a.X, b.X) && near(a.Y, b.Y) }},
+		{"+ zooms in a tick", func() { p.TypedRune('+') }, func(b, a ImagePoint, ticks int) bool { return ticks == 1 && near(a.X, b.X) }},
+		{"- zooms out a tick", func() { p.TypedRune('-') }, func(b, a ImagePoint, ticks int) bool { return ticks == 0 && near(a.X, b.X) }},
+		{"left pans left", func() { p.TypedKey(&fyne.KeyEvent{Name: fyne.KeyLeft}) }, func(b, a ImagePoint, _ int) bool { return near(a.X, b.X-40) && near(a.Y, b.Y) }},
+		{"d pans right", func() { p.TypedRune('d') }, func(b, a ImagePoint, _ int) bool { return near(a.X, b.X+40) && near(a.Y, b.Y) }},
+		{"up pans up", func() { p.TypedKey(&fyne.KeyEvent{Name: fyne.KeyUp}) }, func(b, a ImagePoint, _ int) bool { return near(a.Y, b.Y-30) && near(a.X, b.X) }},
+		{"s pans down", func() { p.TypedRune('s') }, func(b, a ImagePoint, _ int) bool { return near(a.Y, b.Y+30) && near(a.X, b.X) }},
+		{"page up zooms in an octave", func() { p.TypedKey(&fyne.KeyEvent{Name: fyne.KeyPageUp}) }, func(b, a ImagePoint, ticks int) bool { return ticks == octave && near(a.X, b.X) }},
+		{"page down zooms out an octave", func() { p.TypedKey(&fyne.KeyEvent{Name: fyne.KeyPageDown}) }, func(b, a ImagePoint, ticks int) bool { return ticks == 0 && near(a.X, b.X) }},
+		{"home fits", func() { p.TypedKey(&fyne.KeyEvent{Name: fyne.KeyHome}) }, func(_, a ImagePoint, ticks int) bool { return ticks == fittedTicks && near(a.X, fitted.X) }},
+		{"f fits", func() { p.TypedRune('2'); p.TypedRune('f') }, func(_, a ImagePoint, ticks int) bool { return ticks == fittedTicks && near(a.X, fitted.X) }},
+	}
+	for _, step := range steps {
+		before, _ := shown()
+		count := published.Load()
+		step.press()
+		after, ticks := shown()
+		if !step.check(before, after, ticks) {
+			t.Errorf("%s: centre went from %v to %v, at %d ticks", step.name, before, after, ticks)
+		}
+		deadline := time.Now().Add(time.Second)
+		for published.Load() == count && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if published.Load() == count {
+			t.Errorf("%s: nothing published on datum:changed", step.name)
+		}
+	}
+
+	w.Canvas().Unfocus()
+	if p.outline.Visible() {
+		t.Error("unfocused widget is still outlined")
+	}
+
+	w.Canvas().(test.WindowlessCanvas).SetScale(2) // a display with two physical pixels per device unit
+	p.Refresh()
+	p.render.idle()
+	p.TypedRune('0')
+	if _, ticks := shown(); ticks != -octave {
+		t.Errorf("0 on a 2x display gave %d ticks, want %d for half a device unit per image pixel", ticks, -octave)
+	}
+	p.render.idle()
+
+	w.Canvas().(test.WindowlessCanvas).SetScale(1.5) // a ratio no tick lands on
+	p.Refresh()
+	p.render.idle()
+	p.TypedRune('0')
+	if d, _ := p.Snapshot(); d.Scale*1.5 != 1 {
+		t.Errorf("0 on a 1.5x display gave scale %v, want exactly one image pixel per physical pixel", d.Scale)
+	}
+	p.render.idle()
+}
@@ -23,7 +23,7 @@ func NewMachineInfo(bus *eventbus.EventBus) *MachineInfo {
 	malloc := runtime.MemStats{}
 	runtime.ReadMemStats(&malloc)
 
-	m.allinonelabel = widget.NewLabel(fmt.Sprintf("|Heap%5dMB|Stack%5dMB|%3d procs|%s", malloc.HeapInuse/1024/1024, malloc.StackInuse/1024/1024, runtime.NumGoroutine(), time.Now().Format("Mon 15:04")))
+	m.allinonelabel = widget.NewLabel(fmt.Sprintf("|Heap%5dMB|Stack%5dMB|Images%5d/%dMB|%3d procs|%s", malloc.HeapInuse/1024/1024, malloc.StackInuse/1024/1024, SharedImageMemory().Used()>>20, SharedImageMemory().Budget()>>20, runtime.NumGoroutine(), time.Now().Format("Mon 15:04")))
 	m.allinonelabel.TextStyle.Bold = true
 	m.allinonelabel.TextStyle.Monospace = true
 	m.allinonelabel.Importance=widget.MediumImportance
@@ -31,7 +31,7 @@ func NewMachineInfo(bus *eventbus.EventBus) *MachineInfo {
 	go func() {
 		for range time.NewTicker(time.Second * 1).C {
 			runtime.ReadMemStats(&malloc)
-			m.allinonelabel.SetText(fmt.Sprintf("|Heap%5dMB|Stack%5dMB|%3d procs|%s", malloc.HeapInuse/1024/1024, malloc.StackInuse/1024/1024, runtime.NumGoroutine(), time.Now().Format("Mon 15:04")))
+			m.allinonelabel.SetText(fmt.Sprintf("|Heap%5dMB|Stack%5dMB|Images%5d/%dMB|%3d procs|%s", malloc.HeapInuse/1024/1024, malloc.StackInuse/1024/1024, SharedImageMemory().Used()>>20, SharedImageMemory().Budget()>>20, runtime.NumGoroutine(), time.Now().Format("Mon 15:04")))
 		}
 	}()
 
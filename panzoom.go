@@ -1,10 +1,13 @@
 package fynewidgets
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
+	"math"
+	"sync"
+	"sync/atomic"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
@@ -15,32 +18,84 @@ import (
 	"github.com/pkg/errors"
 )
 
+// PyramidProgress is published on the "pyramid:progress" topic while a PanZoomCanvas makes the tiles it needs,
+// first for the coarsest level when an image is opened, and then for each finer level that it refines its view to.
+type PyramidProgress struct {
+	URI   fyne.URI // originating URI, if available
+	Level int      // level being made
+	Done  int      // tiles made so far, or rows decoded while a file is streamed at level 0
+	Total int      // tiles needed, or rows in the image
+}
+
+// fraction of the tiles made, between 0 and 1
+func (p PyramidProgress) Fraction() float64 {
+	if p.Total == 0 {
+		return 1
+	}
+	return float64(p.Done) / float64(p.Total)
+}
+
 // A Widget to display a large image with pan and zoom capability
 // - solves the problem of overloading the graphics card with large images, by pyramid decomposition
 type PanZoomCanvas struct {
 	widget.BaseWidget
-	datum               *Datum             // datum handles pyramid and projection for display
-	canvas              *canvas.Image      // the image is displayed here
-	bus                 *eventbus.EventBus // to talk to the application's StatusProgress widget
-	busy                bool               // avoids the whole double bounce thing
-	mousedown           bool               // for detecting drag etc
-	mousedownpoint      fyne.Position      // where the mouse was clicked
-	mousedownimagepoint image.Point        // where the image was clicked
-	pixelcount          int                // pixels on device (mainly for testing)
+	datum               *Datum              // datum handles pyramid and projection for display
+	canvas              *canvas.Image       // the image is displayed here
+	bus                 *eventbus.EventBus  // to talk to the application's StatusProgress widget
+	mousedown           bool                // for detecting drag etc
+	dragbutton          desktop.MouseButton // button dragging the image
+	mousedownpoint      fyne.Position       // where the mouse was clicked
+	mousedownimagepoint ImagePoint          // where the image was clicked
+	pixelcount          int                 // pixels on device (mainly for testing)
 	// datumchannel        chan Datum         // when there is a change, this channel can be used to notify other components
-	uri   fyne.URI // originating URI, if available
-	text  string   // used for labels
-	loupe *Loupe   // used for providing a loup image to an application
+	uri           fyne.URI    // originating URI, if available
+	text          string      // used for labels
+	loupe         *Loupe      // used for providing a loup image to an application
+	refining      atomic.Bool // a goroutine is making the tiles needed at the current level
+	stale         atomic.Bool // the view changed while refining, so the refining goroutine should look again
+	ctx           context.Context
+	cancel        context.CancelFunc // stops loading and refining, when the widget is closed
+	animation     ViewAnimation      // how the widget moves between views
+	mover         viewMover          // the animation running, and the drag it may carry on
+	history       viewHistory        // views visited, for going back and forward
+	interpolation ViewInterpolation  // how the view is drawn from the pixels of a level
+	pending       *ViewState         // state to show once the image has loaded
+	mu            sync.Mutex         // guards the datum, and the mouse and history kept with it
+	render        *renderScheduler   // draws the view in the background
+	sequence      uint64             // of the latest change of the datum published
+	outline       *canvas.Rectangle  // shown while the widget has the keyboard
+	bindings      InputBindings      // what input does, or nil for DefaultInputBindings
 	// channel             chan interface{} // to talk to the application's StatusProgress widget
 
 }
 
+// topics a PanZoomCanvas publishes on
+var panZoomTopics = []string{"datum:changed", "text:status", "pyramid:progress", "history:changed"}
+
+// the event bus makes its statistics for a topic, unlocked, when the topic is first used, so two goroutines first
+// publishing at once race. Making them up front, one widget at a time, leaves publishing only reading them.
+var topicsmu sync.Mutex
+
+func registerTopics(bus *eventbus.EventBus, topics ...string) {
+	if bus == nil {
+		return
+	}
+	topicsmu.Lock()
+	defer topicsmu.Unlock()
+	for _, topic := range topics {
+		bus.Stats().GetTopicStatsByName(topic)
+	}
+}
+
 func NewPanZoomCanvasFromImage(img image.Image, minsize image.Point, bus *eventbus.EventBus, description string) (*PanZoomCanvas, error) {
 
 	widget := &PanZoomCanvas{
 		canvas: canvas.NewImageFromImage(img),
 		bus:    bus,
 		text:   description}
+	widget.ctx, widget.cancel = context.WithCancel(context.Background())
+	widget.render = newRenderScheduler(widget.draw)
+	registerTopics(bus, panZoomTopics...)
 	widget.canvas.FillMode = canvas.ImageFillStretch
 	widget.canvas.SetMinSize(fyne.NewSize(100, 100))
 
@@ -67,61 +122,119 @@ func NewPanZoomCanvasFromFile(uri fyne.URI, minsize image.Point, bus *eventbus.E
 	widget := &PanZoomCanvas{
 		canvas: canvas.NewImageFromImage(MakeUniformColourImage(color.Gray{Y: 32}, 200, 200)),
 		uri:    uri,
-		busy:   true,
 		text:   uri.Name(),
 		bus:    bus}
 	widget.canvas.FillMode = canvas.ImageFillContain
 	widget.canvas.SetMinSize(fyne.NewSize(float32(minsize.X), float32(minsize.Y)))
 	widget.uri = uri
+	widget.ctx, widget.cancel = context.WithCancel(context.Background())
+	widget.render = newRenderScheduler(widget.draw)
+	registerTopics(bus, panZoomTopics...)
 
 	go func(ww *PanZoomCanvas) {
-		defer func() { ww.busy = false }()
-
 		if uri == nil {
 			ww.canvas.Image = image.NewUniform(color.NRGBA{255, 0, 0, 255}) // replace the placeholder image with a red one
 			return
 		}
-		img, err := LoadNRGBA(uri) // load image as NRGBA, even if it's something else (especially JPEG)
-		if err != nil {            // if loading fails, replace the placeholder image with a red one
-			ww.canvas.Image = image.NewUniform(color.NRGBA{255, 0, 0, 255}) // replace the placeholder image with a red one
+		pyramid, err := NewPyramidFromFileContext(ww.ctx, uri, minsize, DefaultPyramidOptions(), ww.publishProgress(0)) // streams or decodes the file unless its reduced levels are cached
+
+		if ww.ctx.Err() != nil { // closed while decoding - nobody wants the pyramid now
+			if pyramid != nil {
+				pyramid.Close()
+			}
 			return
 		}
-		d, err := NewDatum(img, minsize, 5)
-		if err != nil {
+		if err != nil { // if loading fails, replace the placeholder image with a red one
 			ww.canvas.Image = image.NewUniform(color.NRGBA{255, 0, 0, 255}) // replace the placeholder image with a red one
 			return
 		}
-		ww.datum = d
+		ww.show(pyramid)
+	}(widget)
 
-		d.FitDevice(fyne.NewSize(ww.canvas.Size().Width, ww.canvas.Size().Height))
+	widget.ExtendBaseWidget(widget)
+	return widget, nil // return immediately to keep the UI snappy like a crocodile
+}
 
-		// ww.DatumChanged()
+// Shows an image that is already tiled, such as a Deep Zoom folder or an IIIF server, fetching only the tiles in view.
+// Returns a component immediately, which shows the image once its coarsest level has been fetched.
+//
+//	minsize      The minimum size of the image
+//	description  Used for the label
+func NewPanZoomCanvasFromTileSource(source TileSource, minsize image.Point, bus *eventbus.EventBus, description string) (*PanZoomCanvas, error) {
+	pyramid, err := NewPyramidFromTileSource(source, minsize, DefaultPyramidOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "creating pyramid")
+	}
+	widget := &PanZoomCanvas{
+		canvas: canvas.NewImageFromImage(MakeUniformColourImage(color.Gray{Y: 32}, 200, 200)),
+		text:   description,
+		bus:    bus}
+	widget.canvas.FillMode = canvas.ImageFillContain
+	widget.canvas.SetMinSize(fyne.NewSize(float32(minsize.X), float32(minsize.Y)))
+	widget.ctx, widget.cancel = context.WithCancel(context.Background())
+	widget.render = newRenderScheduler(widget.draw)
+	registerTopics(bus, panZoomTopics...)
 
-		ww.canvas.Image, _, err = d.GetCurrentImage(ww.canvas.Size()) // show the smallest image by default. Large images can take too long to show, and will most likely be replaced by lower reoslution versions anyway.
-		if err != nil {
-			ww.canvas.Image = image.NewUniform(color.NRGBA{255, 0, 0, 255}) // replace the placeholder image with a red one
-			return
-		}
-		ww.Refresh()
+	go func(ww *PanZoomCanvas) {
+		ww.show(pyramid)
 	}(widget)
 
 	widget.ExtendBaseWidget(widget)
-	return widget, nil // return immediately to keep the UI snappy like a crocodile
+	return widget, nil
+}
+
+// makes the coarsest level of a loaded pyramid, then shows it, refining the view as finer levels become available
+func (p *PanZoomCanvas) show(pyramid *Pyramid) {
+	top := pyramid.Height() - 1 // show the smallest image first. Large images take too long to reduce fully, and the view is refined as finer levels become available
+	err := pyramid.PrepareContext(p.ctx, top, pyramid.Bounds(top), p.publishProgress(top))
+	if p.ctx.Err() != nil {
+		pyramid.Close()
+		return
+	}
+	if err != nil {
+		p.canvas.Image = image.NewUniform(color.NRGBA{255, 0, 0, 255}) // replace the placeholder image with a red one
+		return
+	}
+	d := NewDatumFromPyramid(pyramid, 5)
+	d.FitDevice(fyne.NewSize(p.canvas.Size().Width, p.canvas.Size().Height))
+	p.mu.Lock()
+	d.Interpolation = p.interpolation
+	p.datum = d
+	p.showPending()
+	p.rememberView("")
+	p.mu.Unlock()
+
+	// p.DatumChanged()
+
+	p.Refresh()
 }
 
 func (p *PanZoomCanvas) URI() fyne.URI { return p.uri }
 
+// stops any loading or refining still going on, and releases the image's memory. Call it when the widget is removed,
+// as it cannot tell by itself
+func (p *PanZoomCanvas) Close() {
+	p.stopMoving()
+	p.cancel()
+	p.mu.Lock()
+	d := p.datum
+	p.mu.Unlock()
+	if d != nil {
+		d.Pyramid.Close()
+	}
+}
+
 func (p *PanZoomCanvas) CreateRenderer() fyne.WidgetRenderer {
 	if p.uri != nil {
 
 		label := widget.NewLabelWithStyle(p.text, fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
 		label.Truncation = fyne.TextTruncateEllipsis
 		c := container.NewBorder(nil, label, nil, nil, nil)
-		c2 := container.NewStack(p.canvas, c)
+		c2 := container.NewStack(p.canvas, c, p.newOutline())
 		return widget.NewSimpleRenderer(c2)
 
 	}
-	c := container.NewBorder(nil, nil, nil, nil, p.canvas)
+	c := container.NewStack(p.canvas, p.newOutline())
 	return widget.NewSimpleRenderer(c)
 }
 
@@ -132,6 +245,8 @@ func (p *PanZoomCanvas) SetLoupe(loupe *Loupe) {
 
 // returns the current image portion displayed in the component, at full resolution
 func (p *PanZoomCanvas) CurrentImage() (image.Image, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if p.canvas.Image != nil {
 		return p.canvas.Image, nil
 	}
@@ -164,32 +279,106 @@ func (p *PanZoomCanvas) MinSize() fyne.Size {
 // }
 // }
 
+// changes how high bit depth pixels are converted to 8 bits for display, and redraws the image
+func (p *PanZoomCanvas) SetDisplayMapping(m DisplayMapping) error {
+	p.mu.Lock()
+	d := p.datum
+	p.mu.Unlock()
+	if d == nil {
+		return errors.New("no image to map yet")
+	}
+	d.Pyramid.SetDisplayMapping(m)
+	p.Refresh()
+	return nil
+}
+
+// shows a copy of a datum, so that the caller can go on using its own. The level shown is chosen for its scale.
 func (p *PanZoomCanvas) SetDatum(datum Datum) {
-	p.datum = &datum
+	d := datum.clone()
+	if d.Pyramid != nil && d.Scale > 0 {
+		d.level = d.levelForScale(d.Scale)
+	}
+	p.mu.Lock()
+	p.datum = d
+	p.mu.Unlock()
 	p.Refresh()
 }
 
+// a copy of the widget's datum, or nil if the image has not loaded yet. Changing the copy does not change the widget.
+//
+// Deprecated: use Snapshot to read what the widget shows, and Update to change it.
 func (p *PanZoomCanvas) Datum() *Datum {
-	return p.datum
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.datum == nil {
+		return nil
+	}
+	return p.datum.clone()
+}
+
+// a copy of the datum, and the level it wants, that can be read while the datum changes, or nil if there is no datum
+func (p *PanZoomCanvas) snapshot() (*Datum, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.datum == nil {
+		return nil, 0
+	}
+	return p.datum.clone(), p.datum.level
+}
+
+// redraws, and tells other widgets that the datum has changed
+func (p *PanZoomCanvas) changed() {
+	p.Refresh()
+	p.publishDatum()
+}
+
+// publishes a snapshot of the datum, numbered so that followers can drop any that arrive late or that they have
+// since overtaken
+func (p *PanZoomCanvas) publishDatum() {
+	p.mu.Lock()
+	if p.datum == nil {
+		p.mu.Unlock()
+		return
+	}
+	p.sequence = datumSequence.Add(1)
+	e := DatumChanged{Source: p, Sequence: p.sequence, Snapshot: p.datum.Snapshot()}
+	p.mu.Unlock()
+	p.bus.PublishAsync("datum:changed", e)
 }
 
 func (p *PanZoomCanvas) SetLoupeAtPoint(point *image.Point) error {
 	if p.loupe == nil {
 		return errors.New("no loup to use")
 	}
-	if p.datum == nil {
+	d, _ := p.snapshot()
+	if d == nil {
 		return errors.New("no datum to use")
 	}
-	if p.datum.Pyramid == nil {
+	if d.Pyramid == nil {
 		return errors.New("no pyramid to use")
 	}
 	P := image.Pt(p.loupe.dimensions.X/2, p.loupe.dimensions.Y/2)
 	tl := point.Sub(P)
 	br := point.Add(P)
 
+	if !d.upright() { // full-resolution pixels around the point, turned as the image is on the device
+		reach := int(math.Ceil(math.Hypot(float64(P.X), float64(P.Y)))) + 1
+		tl, br = point.Sub(image.Pt(reach, reach)), point.Add(image.Pt(reach, reach))
+	}
 	srect := image.Rectangle{tl, br}
-	smallimage := image.NewNRGBA(srect.Sub(tl))
-	draw.Draw(smallimage, srect.Sub(tl), p.datum.Pyramid.images[0], srect.Min, draw.Over)
+	smallimage, err := d.Pyramid.Region(0, srect)
+	if err != nil {
+		return errors.Wrap(err, "loupe image")
+	}
+	if !d.upright() {
+		u := ImagePoint{}
+		u.X, u.Y = d.toImageAxes(1, 0)
+		v := ImagePoint{}
+		v.X, v.Y = d.toImageAxes(0, 1)
+		ox, oy := d.toImageAxes(-float64(P.X), -float64(P.Y))
+		origin := ImagePoint{float64(point.X) + .5 + ox, float64(point.Y) + .5 + oy}
+		smallimage = sampleView(smallimage, srect, origin, u, v, p.loupe.dimensions.X, p.loupe.dimensions.Y, InterpolateNearest)
+	}
 
 	p.loupe.canvas.Image = smallimage
 	p.loupe.Refresh()
@@ -200,46 +389,128 @@ func (p *PanZoomCanvas) SetLoupeAtPoint(point *image.Point) error {
 // When the window is resized, show the full image and broadcast this datum change
 func (p *PanZoomCanvas) Resize(size fyne.Size) {
 	p.BaseWidget.Resize(size)
+	p.stopMoving()
+	p.mu.Lock()
 	err := p.datum.FitDevice(p.canvas.Size())
+	if err == nil {
+		p.showPending()
+		p.rememberView("resize")
+	}
+	p.mu.Unlock()
 	if err != nil {
 		return
 	}
-	p.Refresh()
-	p.bus.PublishAsync("datum:changed", p.datum)
+	p.changed()
 }
 
+// redraws the widget, drawing the view of the latest datum in the background
 func (p *PanZoomCanvas) Refresh() {
 	p.BaseWidget.Refresh()
+	if p.render != nil {
+		p.render.request()
+	}
+}
+
+// draws the view of the datum as it is now, giving up as soon as the context is cancelled because it has changed
+// again. Called by the render scheduler, so never runs twice at once.
+func (p *PanZoomCanvas) draw(ctx context.Context) {
+	size := p.canvas.Size()
+	ratio := p.pixelRatio()
+	p.mu.Lock()
 	if p.datum == nil {
+		p.mu.Unlock()
 		return
 	}
-	if p.datum.Scale < 0 {
-		p.datum.FitDevice(p.canvas.Size())
-		p.bus.PublishAsync("datum:changed", p.datum)
+	fitted := p.datum.Scale < 0
+	if fitted {
+		p.datum.FitDevice(size)
 	}
-	img, pixelscount, err := p.datum.GetCurrentImage(p.canvas.Size())
-	if err != nil {
+	if ratio != p.datum.PixelRatio {
+		p.datum.SetPixelRatio(ratio)
+	}
+	d, wanted := p.datum.clone(), p.datum.level
+	p.mu.Unlock()
+	if fitted {
+		p.publishDatum()
+	}
+	img, level, pixelscount, err := d.availableImage(ctx, size, wanted) // whatever is ready now, stretched if it is coarser than wanted
+	if err != nil || ctx.Err() != nil {
 		return
 	}
+	if level != wanted {
+		p.refine()
+	}
+	p.mu.Lock()
 	p.pixelcount = pixelscount
 	p.canvas.Image = img
+	p.mu.Unlock()
 
-	text := fmt.Sprintf("L: %d | Scale: %d%% | %.2f MPix", p.datum.Pyramid.level, int(p.datum.Scale*100), float32(p.pixelcount)/1000000.0)
+	text := fmt.Sprintf("L: %d | Scale: %d%% | %.2f MPix", level, int(d.Scale*100), float32(pixelscount)/1000000.0)
 
 	p.bus.Publish("text:status", text)
 
 	p.canvas.Refresh()
 }
 
+// physical pixels per device unit of the window showing the widget, or 1 if it is not shown yet
+func (p *PanZoomCanvas) pixelRatio() float32 {
+	if fyne.CurrentApp() == nil {
+		return 1
+	}
+	if c := fyne.CurrentApp().Driver().CanvasForObject(p); c != nil && c.Scale() > 0 {
+		return c.Scale()
+	}
+	return 1
+}
+
+// makes the tiles for the current view in the background, then refreshes to show them
+func (p *PanZoomCanvas) refine() {
+	if !p.refining.CompareAndSwap(false, true) {
+		p.stale.Store(true) // the goroutine already running will look again
+		return
+	}
+	go func() {
+		for {
+			p.stale.Store(false)
+			d, level := p.snapshot()
+			r, err := d.viewRect(p.canvas.Size(), level)
+			if err == nil {
+				err = d.Pyramid.PrepareContext(p.ctx, level, r, p.publishProgress(level))
+			}
+			if err != nil {
+				p.refining.Store(false)
+				if p.ctx.Err() == nil {
+					p.bus.PublishAsync("text:status", "refining view: "+err.Error())
+				}
+				return // refreshing would only try again
+			}
+			if !p.stale.Load() {
+				break
+			}
+		}
+		p.refining.Store(false)
+		p.Refresh()
+	}()
+}
+
+// returns a function that publishes the progress of making the tiles of a level
+func (p *PanZoomCanvas) publishProgress(level int) func(done, total int) {
+	return func(done, total int) {
+		p.bus.PublishAsync("pyramid:progress", &PyramidProgress{URI: p.uri, Level: level, Done: done, Total: total})
+	}
+}
+
 func (p *PanZoomCanvas) MouseOut() {}
 
 func (p *PanZoomCanvas) MouseMoved(e *desktop.MouseEvent) {
-
+	p.mu.Lock()
 	if p.datum == nil {
+		p.mu.Unlock()
 		return
 	}
 	point, err := p.datum.TransformDeviceToFullImage(e.Position)
 	if err != nil {
+		p.mu.Unlock()
 		return
 	}
 
@@ -247,110 +518,130 @@ func (p *PanZoomCanvas) MouseMoved(e *desktop.MouseEvent) {
 	BR, _ := p.datum.TransformDeviceToFullImage(fyne.NewPos(p.canvas.Size().Width, p.canvas.Size().Height))
 	SIZE := BR.Sub(*TL)
 
-	p.bus.PublishAsync("text:status", fmt.Sprintf("L: %d | Scale: %d%% | %.2f MPix | M: %.1f %.1f | W: %d %d | Full View: %d x %d ",
-		p.datum.Pyramid.level, int(p.datum.Scale*100), float32(p.pixelcount)/1000000.0,
-		e.Position.X, e.Position.Y, point.X, point.Y, SIZE.X, SIZE.Y))
-
-	if p.mousedown {
-		if p.busy {
-			return
-		}
-
-		go func(*PanZoomCanvas) {
-			p.busy = true
-			defer func() {
-				p.busy = false
-			}()
-			p.datum.ImageCoords = &p.mousedownimagepoint
-			p.datum.DeviceCoords = &e.Position
-			p.Refresh()
-			p.bus.PublishAsync("datum:changed", p.datum)
-		}(p)
+	status := fmt.Sprintf("L: %d | Scale: %d%% | %.2f MPix | M: %.1f %.1f | W: %d %d | Full View: %d x %d ",
+		p.datum.level, int(p.datum.Scale*100), float32(p.pixelcount)/1000000.0,
+		e.Position.X, e.Position.Y, point.X, point.Y, SIZE.X, SIZE.Y)
+
+	dragging := p.mousedown
+	if dragging {
+		p.trackDrag(e.Position)
+		anchor := p.mousedownimagepoint // the image point clicked stays under the mouse
+		position := e.Position
+		p.datum.ImageCoords = &anchor
+		p.datum.DeviceCoords = &position
+		p.datum.Constrain()
+	}
+	p.mu.Unlock()
+	p.bus.PublishAsync("text:status", status)
+	if dragging {
+		p.changed()
 	}
 	p.SetLoupeAtPoint(point)
-
 }
 
 func (p *PanZoomCanvas) MouseIn(e *desktop.MouseEvent) {
 
 }
 
+// ends a drag, carrying it on if it was fast, or does what the widget's InputBindings say for a click
 func (p *PanZoomCanvas) MouseUp(e *desktop.MouseEvent) {
-	p.mousedown = false
-	if e.Button == desktop.MouseButtonSecondary {
-
-		err := p.datum.FitDevice(p.canvas.Size())
-		if err != nil {
-			return
-		}
-		p.bus.PublishAsync("datum:changed", p.datum)
-		p.Refresh()
+	p.mu.Lock()
+	dragged := p.mousedown && e.Button == p.dragbutton
+	if dragged {
+		p.mousedown = false
+	}
+	moved := e.Position != p.mousedownpoint
+	kinetic := p.animation.Kinetic
+	p.mu.Unlock()
+	if !dragged {
+		p.perform(p.action(MouseInput(e.Button, e.Modifier)), e.Position)
+		return
+	}
+	if moved && !(kinetic && p.fling()) {
+		p.mu.Lock()
+		p.rememberView("drag")
+		p.mu.Unlock()
 	}
 	p.Refresh()
 }
 
+// starts a drag, if the button and modifiers are bound to ActionPan
 func (p *PanZoomCanvas) MouseDown(e *desktop.MouseEvent) {
-	if e.Button == desktop.MouseButtonPrimary {
-		p.bus.Publish("text:status", "Mouse Down")
-		p.mousedown = true
-		pt, err := p.datum.TransformDeviceToFullImage(e.Position)
-		if err != nil {
-			return
-		}
-		p.mousedownpoint = e.Position
-		p.mousedownimagepoint = *pt
+	p.requestFocus()
+	if p.action(MouseInput(e.Button, e.Modifier)) != ActionPan {
+		return
 	}
+	p.bus.Publish("text:status", "Mouse Down")
+	p.stopMoving()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.datum == nil {
+		return
+	}
+	p.mover.drag = nil
+	pt, err := p.datum.DeviceToImage(e.Position)
+	if err != nil {
+		return
+	}
+	p.mousedown = true
+	p.dragbutton = e.Button
+	p.mousedownpoint = e.Position
+	p.mousedownimagepoint = pt
 }
 
+// zooms about the mouse a tick at a time, or pans by the distance scrolled, as the widget's InputBindings say for the
+// modifiers held
 func (p *PanZoomCanvas) Scrolled(e *fyne.ScrollEvent) {
-	if p.busy {
-		return
+	switch p.action(WheelInput(p.modifiers())) {
+	case ActionZoom:
+		if e.Scrolled.DY != 0 {
+			p.changeView("zoom", func(d *Datum) error { return d.ScaleByTick(e.Position, e.Scrolled.DY) })
+		}
+	case ActionWheelPan:
+		p.panBy(e.Scrolled.DX, e.Scrolled.DY) // scrolling up shows more of the top, as a scroll bar would
 	}
-	go func(p *PanZoomCanvas) {
-		p.busy = true
-		defer func() {
-			p.busy = false
-		}()
-		p.datum.ScaleByTick(e.Position, e.Scrolled.DY)
-		p.Refresh()
-		p.bus.PublishAsync("datum:changed", p.datum)
-		// p.DatumChanged()
-
-	}(p)
 }
 
 func (p *PanZoomCanvas) Cursor() desktop.Cursor {
 	return desktop.CrosshairCursor
 }
 
-func (p *PanZoomCanvas) TypedRune(r rune) {
-	switch r {
-	case '2':
-		if p.busy {
-			return
-		}
-		p.busy = true
-		go func(p *PanZoomCanvas) {
-			defer func() { p.busy = false }()
-			p.datum.ChangeScale(2.0)
-			p.Refresh()
-
-		}(p)
-
-	case '1':
-		if p.busy {
-			return
-		}
-		p.busy = true
-		go func(p *PanZoomCanvas) {
-			defer func() { p.busy = false }()
-			p.datum.ChangeScale(0.5)
-			p.Refresh()
-
-		}(p)
+// limits how far the image can be zoomed and panned. The view is brought within the limits at once
+func (p *PanZoomCanvas) SetViewConstraints(c ViewConstraints) {
+	p.stopMoving()
+	p.mu.Lock()
+	if p.datum == nil {
+		p.mu.Unlock()
+		return
+	}
+	p.datum.SetConstraints(c)
+	p.mu.Unlock()
+	p.changed()
+}
 
+// sets how the view is drawn from the pixels of a level: nearest for pixel-peeping, or bilinear or bicubic for a
+// smooth view
+func (p *PanZoomCanvas) SetInterpolation(i ViewInterpolation) {
+	p.mu.Lock()
+	p.interpolation = i
+	if p.datum != nil {
+		p.datum.Interpolation = i
 	}
+	p.mu.Unlock()
+	p.Refresh()
 }
 
-func (p *PanZoomCanvas) TypedKey(event *fyne.KeyEvent) {
+// turns or mirrors the image about the centre of the widget, then redraws and tells other widgets
+func (p *PanZoomCanvas) reorient(change func(d *Datum, centre fyne.Position)) {
+	p.stopMoving()
+	size := p.canvas.Size()
+	p.mu.Lock()
+	if p.datum == nil || p.datum.Scale < 0 {
+		p.mu.Unlock()
+		return
+	}
+	change(p.datum, fyne.NewPos(size.Width/2, size.Height/2))
+	p.rememberView("turn")
+	p.mu.Unlock()
+	p.changed()
 }
@@ -0,0 +1,131 @@
+package fynewidgets
+
+import (
+	"image"
+	"math"
+
+	"fyne.io/fyne/v2"
+)
+
+// Projection maps between three sets of coordinates: device positions, in fyne's device units; full-resolution image
+// positions; and positions in the pixels of a pyramid level, where level n is the full image halved n times. An image
+// position is shown at a device position, and everything else follows from the scale and orientation about that
+// anchor. Positions are kept to a fraction of a pixel; round them with ImagePoint.Pixel or VisibleRect.
+//
+// Datum.Projection gives the projection a datum shows, and the datum's own transforms are made with it.
+type Projection struct {
+	Image      ImagePoint    // full-resolution image position shown at Device
+	Device     fyne.Position // device position of Image
+	Scale      float32       // device units per full-resolution image pixel, which must be positive
+	Rotation   float64       // degrees clockwise that the image is turned on the device
+	Mirrored   bool          // image is flipped left to right before it is turned
+	PixelRatio float32       // physical pixels per device unit, or 0 for 1
+}
+
+// the full-resolution image position shown at a device position
+func (p Projection) DeviceToImage(device fyne.Position) ImagePoint {
+	scale := float64(p.Scale)
+	x, y := p.toImageAxes((float64(device.X)-float64(p.Device.X))/scale, (float64(device.Y)-float64(p.Device.Y))/scale) // shift device point to origin, scale, and turn back to the image's axes
+	return ImagePoint{x + p.Image.X, y + p.Image.Y}                                                                     // translate origin to image point
+}
+
+// the device position at which a full-resolution image position is shown
+func (p Projection) ImageToDevice(image ImagePoint) fyne.Position {
+	scale := float64(p.Scale)
+	x, y := p.toDeviceAxes(image.X-p.Image.X, image.Y-p.Image.Y)
+	return fyne.NewPos(float32(x*scale+float64(p.Device.X)), float32(y*scale+float64(p.Device.Y)))
+}
+
+// the position in a level's pixels shown at a device position
+func (p Projection) DeviceToLevel(device fyne.Position, level int) ImagePoint {
+	return p.DeviceToImage(device).ToLevel(level)
+}
+
+// the device position at which a position in a level's pixels is shown
+func (p Projection) LevelToDevice(point ImagePoint, level int) fyne.Position {
+	return p.ImageToDevice(point.FromLevel(level))
+}
+
+// the same position in the pixels of a pyramid level
+func (i ImagePoint) ToLevel(level int) ImagePoint {
+	power := math.Ldexp(1, level) // each level's dimensions are half those of the one before
+	return ImagePoint{i.X / power, i.Y / power}
+}
+
+// the full-resolution position of a position in the pixels of a pyramid level
+func (i ImagePoint) FromLevel(level int) ImagePoint {
+	power := math.Ldexp(1, level)
+	return ImagePoint{i.X * power, i.Y * power}
+}
+
+// top left and bottom right corners, in a level's pixels, of the smallest upright area that holds everything a device
+// of the given size shows
+func (p Projection) VisibleCorners(size fyne.Size, level int) (ImagePoint, ImagePoint) {
+	tl := ImagePoint{math.Inf(1), math.Inf(1)}
+	br := ImagePoint{math.Inf(-1), math.Inf(-1)}
+	for _, corner := range []fyne.Position{{}, {X: size.Width}, {Y: size.Height}, {X: size.Width, Y: size.Height}} {
+		q := p.DeviceToLevel(corner, level)
+		tl = ImagePoint{min(tl.X, q.X), min(tl.Y, q.Y)}
+		br = ImagePoint{max(br.X, q.X), max(br.Y, q.Y)}
+	}
+	return tl, br
+}
+
+// the whole pixels of a level that a device of the given size shows. It may reach beyond the level's bounds.
+func (p Projection) VisibleRect(size fyne.Size, level int) image.Rectangle {
+	return coveringRect(p.VisibleCorners(size, level))
+}
+
+// the level of a pyramid of some height with about one pixel per physical pixel of the device. A level is used until
+// the view is about a quarter larger than it, then the next finer one.
+func (p Projection) Level(height int) int {
+	level := -int(math.Log2(float64(p.Scale*p.pixelRatio())) + .31)
+	return min(max(level, 0), height-1)
+}
+
+func (p Projection) pixelRatio() float32 {
+	if p.PixelRatio <= 0 {
+		return 1
+	}
+	return p.PixelRatio
+}
+
+// true if the image is neither turned nor mirrored
+func (p Projection) Upright() bool {
+	return p.Rotation == 0 && !p.Mirrored
+}
+
+// cosine and sine of the rotation, exact for quarter turns
+func (p Projection) rotation() (float64, float64) {
+	switch p.Rotation {
+	case 0:
+		return 1, 0
+	case 90:
+		return 0, 1
+	case 180:
+		return -1, 0
+	case 270:
+		return 0, -1
+	}
+	rad := p.Rotation * math.Pi / 180
+	return math.Cos(rad), math.Sin(rad)
+}
+
+// turns a distance along the image's axes into one along the device's, mirroring and then rotating it
+func (p Projection) toDeviceAxes(x, y float64) (float64, float64) {
+	if p.Mirrored {
+		x = -x
+	}
+	c, s := p.rotation()
+	return c*x - s*y, s*x + c*y // clockwise, as y is down
+}
+
+// turns a distance along the device's axes into one along the image's, undoing the rotation and then the mirroring
+func (p Projection) toImageAxes(x, y float64) (float64, float64) {
+	c, s := p.rotation()
+	x, y = c*x+s*y, -s*x+c*y
+	if p.Mirrored {
+		x = -x
+	}
+	return x, y
+}
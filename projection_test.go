@@ -0,0 +1,166 @@
+package fynewidgets
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-3
+}
+
+func TestProjectionConversions(t *testing.T) {
+	cos30, sin30 := math.Cos(math.Pi/6), math.Sin(math.Pi/6)
+	tests := []struct {
+		name       string
+		projection Projection
+		image      ImagePoint
+		device     fyne.Position
+	}{
+		{"upright", Projection{Image: ImagePoint{100, 50}, Device: fyne.NewPos(10, 20), Scale: 2}, ImagePoint{110, 60}, fyne.NewPos(30, 40)},
+		{"reduced", Projection{Image: ImagePoint{100, 50}, Device: fyne.NewPos(10, 20), Scale: .25}, ImagePoint{140, 10}, fyne.NewPos(20, 10)},
+		{"fraction", Projection{Image: ImagePoint{.5, .5}, Scale: 3}, ImagePoint{1.25, 2}, fyne.NewPos(2.25, 4.5)},
+		{"quarter turn", Projection{Scale: 1, Rotation: 90}, ImagePoint{1, 0}, fyne.NewPos(0, 1)},
+		{"half turn", Projection{Image: ImagePoint{10, 10}, Device: fyne.NewPos(100, 100), Scale: 2, Rotation: 180}, ImagePoint{12, 10}, fyne.NewPos(96, 100)},
+		{"mirrored", Projection{Image: ImagePoint{10, 10}, Scale: 1, Mirrored: true}, ImagePoint{12, 11}, fyne.NewPos(-2, 1)},
+		{"mirrored and turned", Projection{Scale: 1, Rotation: 90, Mirrored: true}, ImagePoint{1, 0}, fyne.NewPos(0, -1)},
+		{"30 degrees", Projection{Scale: 1, Rotation: 30}, ImagePoint{10, 0}, fyne.NewPos(float32(10*cos30), float32(10*sin30))},
+		{"pixel ratio", Projection{Image: ImagePoint{100, 50}, Device: fyne.NewPos(10, 20), Scale: 2, PixelRatio: 2}, ImagePoint{110, 60}, fyne.NewPos(30, 40)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.projection.ImageToDevice(tt.image); !near(float64(got.X), float64(tt.device.X)) || !near(float64(got.Y), float64(tt.device.Y)) {
+				t.Errorf("ImageToDevice(%v) = %v, want %v", tt.image, got, tt.device)
+			}
+			if got := tt.projection.DeviceToImage(tt.device); !near(got.X, tt.image.X) || !near(got.Y, tt.image.Y) {
+				t.Errorf("DeviceToImage(%v) = %v, want %v", tt.device, got, tt.image)
+			}
+			for level := 0; level < 4; level++ {
+				want := ImagePoint{tt.image.X / math.Exp2(float64(level)), tt.image.Y / math.Exp2(float64(level))}
+				if got := tt.projection.DeviceToLevel(tt.device, level); !near(got.X, want.X) || !near(got.Y, want.Y) {
+					t.Errorf("DeviceToLevel(%v, %d) = %v, want %v", tt.device, level, got, want)
+				}
+				if got := tt.projection.LevelToDevice(want, level); !near(float64(got.X), float64(tt.device.X)) || !near(float64(got.Y), float64(tt.device.Y)) {
+					t.Errorf("LevelToDevice(%v, %d) = %v, want %v", want, level, got, tt.device)
+				}
+			}
+		})
+	}
+}
+
+func TestImagePointLevels(t *testing.T) {
+	tests := []struct {
+		point ImagePoint
+		level int
+		want  ImagePoint
+	}{
+		{ImagePoint{100, 60}, 0, ImagePoint{100, 60}},
+		{ImagePoint{100, 60}, 1, ImagePoint{50, 30}},
+		{ImagePoint{100, 60}, 3, ImagePoint{12.5, 7.5}},
+		{ImagePoint{-8, 4.5}, 2, ImagePoint{-2, 1.125}},
+	}
+	for _, tt := range tests {
+		if got := tt.point.ToLevel(tt.level); got != tt.want {
+			t.Errorf("%v.ToLevel(%d) = %v, want %v", tt.point, tt.level, got, tt.want)
+		}
+		if got := tt.want.FromLevel(tt.level); got != tt.point {
+			t.Errorf("%v.FromLevel(%d) = %v, want %v", tt.want, tt.level, got, tt.point)
+		}
+	}
+}
+
+func TestProjectionVisibleRect(t *testing.T) {
+	tests := []struct {
+		name       string
+		projection Projection
+		size       fyne.Size
+		level      int
+		want       image.Rectangle
+	}{
+		{"full resolution", Projection{Scale: 1}, fyne.NewSize(100, 50), 0, image.Rect(0, 0, 100, 50)},
+		{"level 1", Projection{Scale: 1}, fyne.NewSize(100, 50), 1, image.Rect(0, 0, 50, 25)},
+		{"zoomed in", Projection{Image: ImagePoint{10, 10}, Scale: 2}, fyne.NewSize(100, 50), 0, image.Rect(10, 10, 60, 35)},
+		{"part pixels", Projection{Scale: 3}, fyne.NewSize(10, 10), 0, image.Rect(0, 0, 4, 4)},
+		{"off the image", Projection{Image: ImagePoint{-5.5, 0}, Scale: 1}, fyne.NewSize(10, 10), 0, image.Rect(-6, 0, 5, 10)},
+		{"quarter turn", Projection{Device: fyne.NewPos(50, 50), Scale: 1, Rotation: 90}, fyne.NewSize(100, 60), 0, image.Rect(-50, -50, 10, 50)},
+		{"45 degrees", Projection{Device: fyne.NewPos(50, 50), Scale: 1, Rotation: 45}, fyne.NewSize(100, 100), 0, image.Rect(-71, -71, 71, 71)},
+	}
+	for _, tt := range tests {
+		if got := tt.projection.VisibleRect(tt.size, tt.level); got != tt.want {
+			t.Errorf("%s: VisibleRect = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProjectionLevel(t *testing.T) {
+	tests := []struct {
+		scale, ratio float32
+		height, want int
+	}{
+		{4, 0, 5, 0},
+		{1, 0, 5, 0},
+		{.5, 0, 5, 0}, // a level is kept until the view is about a quarter larger than it
+		{.4, 0, 5, 1},
+		{.25, 0, 5, 1},
+		{.2, 0, 5, 2},
+		{.01, 0, 5, 4},
+		{.4, 2, 5, 0},
+		{.125, 2, 5, 1},
+		{.2, 0, 1, 0},
+	}
+	for _, tt := range tests {
+		if got := (Projection{Scale: tt.scale, PixelRatio: tt.ratio}).Level(tt.height); got != tt.want {
+			t.Errorf("Level at scale %g, ratio %g, height %d = %d, want %d", tt.scale, tt.ratio, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestDatumProjection(t *testing.T) {
+	d, err := NewDatum(testImage(800, 600), image.Pt(20, 20), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.FitDevice(fyne.NewSize(200, 100))
+	d.Rotate(fyne.NewPos(30, 40), 30)
+	p := d.Projection()
+	for _, at := range []fyne.Position{{}, {X: 200, Y: 100}, {X: 31.5, Y: 7.25}} {
+		got, _ := d.DeviceToImage(at)
+		if want := p.DeviceToImage(at); got != want {
+			t.Errorf("datum shows %v at %v, its projection %v", got, at, want)
+		}
+	}
+	if got, want := d.levelForScale(d.Scale), p.Level(d.Pyramid.Height()); got != want {
+		t.Errorf("datum chose level %d, its projection %d", got, want)
+	}
+}
+
+func TestDeprecatedTransforms(t *testing.T) {
+	d2 := Datum2D{ImageDatum: image.Pt(100, 50), DeviceDatum: fyne.NewPos(10, 20), Scale: 2}
+	pt := PyramidTransform{ImageCentre: &image.Point{100, 50}, DeviceCentre: &fyne.Position{X: 10, Y: 20}, LayerScale: 2}
+	from, err := pt.FromDevice(fyne.NewPos(31, 41))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := pt.ToDevice(image.Pt(110, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		got, want image.Point
+	}{
+		{"Datum2D.ToImage", d2.ToImage(&fyne.Position{X: 30, Y: 40}), image.Pt(110, 60)},
+		{"Datum2D.ToImage truncates", d2.ToImage(&fyne.Position{X: 31, Y: 41}), image.Pt(110, 60)},
+		{"Datum2D.ToDevice", d2.ToDevice(&fyne.Position{X: 110, Y: 60}), image.Pt(30, 40)},
+		{"PyramidTransform.FromDevice rounds", *from, image.Pt(111, 61)},
+		{"PyramidTransform.ToDevice", image.Pt(int(to.X), int(to.Y)), image.Pt(30, 40)},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
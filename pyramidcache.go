@@ -0,0 +1,361 @@
+package fynewidgets
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"github.com/pkg/errors"
+)
+
+const DefaultPyramidCacheLimit int64 = 2 << 30 // bytes on disk before the least recently opened pyramids are removed
+
+const pyramidmanifest = "pyramid.json"
+
+// PyramidCache keeps the tiles of reduced pyramid levels on disk, so that opening the same file again does not
+// mean decoding it and rebuilding its levels.
+//
+// Entries are keyed by the file's path, size and modification time, so an edited file is never shown from stale tiles.
+// Full-resolution tiles are stored too for files that are streamed (see NewPyramidFromFile), as they are not kept in
+// memory and would otherwise need the whole file decoded again. They are stored uncompressed, so they take the
+// image's width times height times the bytes of its pixel format: 400MB for a 100-megapixel colour image, and a
+// third as much again for the reduced levels. They are only stored when all of that fits within the cache's limit.
+// Files decoded whole, and tiled TIFFs, read full-resolution pixels from the file instead.
+type PyramidCache struct {
+	dir   string
+	mu    sync.Mutex
+	limit int64 // bytes on disk before trimming
+	used  int64 // bytes on disk, as far as this cache knows
+}
+
+// describes a cached pyramid, and is stored alongside its tiles
+type pyramidManifest struct {
+	Path     string
+	Size     int64
+	ModTime  time.Time
+	Settings string            // tiling and resampling that produced the tiles
+	Format   PixelFormat       // type of image the tiles are kept in
+	Bounds   []image.Rectangle // extent of each level
+}
+
+// a single pyramid's entry in a PyramidCache
+type pyramidStore struct {
+	cache *PyramidCache
+	dir   string
+	full  atomic.Bool // set when this pyramid alone would pass the cache's limit, after which tiles are no longer saved
+}
+
+// creates a cache in a directory, which is created if necessary.
+//
+//	limit  bytes on disk before the least recently opened pyramids are removed
+func NewPyramidCache(dir string, limit int64) (*PyramidCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "f: NewPyramidCache")
+	}
+	c := &PyramidCache{dir: dir, limit: limit}
+	used, err := c.Size()
+	if err != nil {
+		return nil, errors.Wrap(err, "f: NewPyramidCache")
+	}
+	c.used = used
+	return c, nil
+}
+
+// creates a cache in the "pyramids" folder of the current app's storage root
+func NewAppPyramidCache(limit int64) (*PyramidCache, error) {
+	if fyne.CurrentApp() == nil {
+		return nil, errors.New("f: NewAppPyramidCache - no app")
+	}
+	root := fyne.CurrentApp().Storage().RootURI()
+	return NewPyramidCache(filepath.Join(root.Path(), "pyramids"), limit)
+}
+
+// folder holding the cache
+func (c *PyramidCache) Dir() string { return c.dir }
+
+// bytes on disk allowed before the least recently opened pyramids are removed
+func (c *PyramidCache) Limit() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
+
+// changes the size limit, trimming the cache straight away if necessary
+func (c *PyramidCache) SetLimit(limit int64) error {
+	c.mu.Lock()
+	c.limit = limit
+	c.mu.Unlock()
+	return c.trim("")
+}
+
+// bytes currently used on disk by the cache
+func (c *PyramidCache) Size() (int64, error) {
+	return dirSize(c.dir)
+}
+
+// removes every cached pyramid
+func (c *PyramidCache) Purge() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return errors.Wrap(err, "f: Purge")
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(c.dir, e.Name())); err != nil {
+			return errors.Wrap(err, "f: Purge")
+		}
+	}
+	c.mu.Lock()
+	c.used = 0
+	c.mu.Unlock()
+	return nil
+}
+
+// removes the cached pyramid of a file, if there is one
+func (c *PyramidCache) Remove(uri fyne.URI) error {
+	if !cacheable(uri) {
+		return nil
+	}
+	key, _, err := c.key(uri)
+	if err != nil {
+		return errors.Wrap(err, "f: Remove")
+	}
+	if err := os.RemoveAll(filepath.Join(c.dir, key)); err != nil {
+		return errors.Wrap(err, "f: Remove")
+	}
+	return c.recount()
+}
+
+// true if a URI names a local file, whose path, size and modification time can key an entry. Pyramids of anything
+// else are simply not cached.
+func cacheable(uri fyne.URI) bool {
+	return uri.Scheme() == "file"
+}
+
+// key of a file's entry, from its path, size and modification time
+func (c *PyramidCache) key(uri fyne.URI) (string, os.FileInfo, error) {
+	info, err := os.Stat(uri.Path())
+	if err != nil {
+		return "", nil, err
+	}
+	h := sha1.Sum([]byte(fmt.Sprintf("%s|%d|%d", uri.Path(), info.Size(), info.ModTime().UnixNano())))
+	return hex.EncodeToString(h[:]), info, nil
+}
+
+// finds the cached pyramid of a file made with the given settings, returning its manifest, or nil if there is none.
+// The returned store is ready to receive tiles either way.
+func (c *PyramidCache) open(uri fyne.URI, settings string) (*pyramidStore, *pyramidManifest, error) {
+	key, _, err := c.key(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	store := &pyramidStore{cache: c, dir: filepath.Join(c.dir, key)}
+	b, err := os.ReadFile(filepath.Join(store.dir, pyramidmanifest))
+	if err != nil {
+		return store, nil, nil
+	}
+	m := &pyramidManifest{}
+	if err := json.Unmarshal(b, m); err != nil || m.Settings != settings {
+		os.RemoveAll(store.dir) // unreadable, or made differently - start again
+		c.recount()
+		return store, nil, nil
+	}
+	now := time.Now()
+	os.Chtimes(filepath.Join(store.dir, pyramidmanifest), now, now) // marks the entry as recently used
+	return store, m, nil
+}
+
+// records a new pyramid, after which its tiles can be saved
+func (s *pyramidStore) create(uri fyne.URI, settings string, format PixelFormat, bounds []image.Rectangle) error {
+	_, info, err := s.cache.key(uri)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(pyramidManifest{Path: uri.Path(), Size: info.Size(), ModTime: info.ModTime(), Settings: settings, Format: format, Bounds: bounds})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, pyramidmanifest), b); err != nil {
+		return err
+	}
+	s.cache.grow(int64(len(b)), s.dir)
+	return nil
+}
+
+func (s *pyramidStore) tilepath(key tileKey) string {
+	return filepath.Join(s.dir, fmt.Sprint(key.level), fmt.Sprintf("%d_%d.tile", key.col, key.row))
+}
+
+// reads a tile of the given format, returning nil if it is not on disk
+func (s *pyramidStore) load(key tileKey, format PixelFormat) image.Image {
+	f, err := os.Open(s.tilepath(key))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var header [5]int32
+	if err := binary.Read(f, binary.LittleEndian, &header); err != nil {
+		return nil
+	}
+	r := image.Rect(int(header[1]), int(header[2]), int(header[3]), int(header[4]))
+	if PixelFormat(header[0]) != format || r.Empty() || r.Dx() > 1<<16 || r.Dy() > 1<<16 {
+		return nil
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil
+	}
+	tile, err := tileFromBytes(format, r, b)
+	if err != nil {
+		return nil
+	}
+	return tile
+}
+
+// writes a tile. Failures are not fatal, as the tile can always be made again
+func (s *pyramidStore) save(key tileKey, tile image.Image) {
+	if s.full.Load() {
+		return
+	}
+	r := tile.Bounds()
+	b := make([]byte, 20)
+	binary.LittleEndian.PutUint32(b[0:], uint32(pixelFormatOf(tile)))
+	binary.LittleEndian.PutUint32(b[4:], uint32(r.Min.X))
+	binary.LittleEndian.PutUint32(b[8:], uint32(r.Min.Y))
+	binary.LittleEndian.PutUint32(b[12:], uint32(r.Max.X))
+	binary.LittleEndian.PutUint32(b[16:], uint32(r.Max.Y))
+	b = append(b, tileBytes(tile)...)
+	path := s.tilepath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	if err := writeFileAtomic(path, b); err != nil {
+		return
+	}
+	if s.cache.grow(int64(len(b)), s.dir) {
+		s.full.Store(true)
+	}
+}
+
+// adds to the bytes in use, trimming other entries if the limit is passed. Returns true if the limit is still passed
+func (c *PyramidCache) grow(bytes int64, keep string) bool {
+	c.mu.Lock()
+	c.used += bytes
+	over := c.used > c.limit
+	c.mu.Unlock()
+	if !over {
+		return false
+	}
+	c.trim(keep)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used > c.limit
+}
+
+// removes the least recently opened entries, other than the one named keep, until the cache is within its limit
+func (c *PyramidCache) trim(keep string) error {
+	if err := c.recount(); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		dir  string
+		used time.Time
+	}
+	list := make([]entry, 0, len(entries))
+	for _, e := range entries {
+		dir := filepath.Join(c.dir, e.Name())
+		if dir == keep {
+			continue
+		}
+		used := time.Time{} // entries without a manifest go first
+		if info, err := os.Stat(filepath.Join(dir, pyramidmanifest)); err == nil {
+			used = info.ModTime()
+		}
+		list = append(list, entry{dir, used})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].used.Before(list[j].used) })
+	for _, e := range list {
+		c.mu.Lock()
+		over := c.used > c.limit
+		c.mu.Unlock()
+		if !over {
+			break
+		}
+		size, err := dirSize(e.dir)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(e.dir); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.used -= size
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// measures the cache on disk again
+func (c *PyramidCache) recount() error {
+	used, err := c.Size()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.used = used
+	c.mu.Unlock()
+	return nil
+}
+
+// bytes used by the files in a folder and its subfolders
+func dirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // removed while walking
+			}
+			return err
+		}
+		if !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// writes through a temporary file, so a reader never sees half a file
+func writeFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
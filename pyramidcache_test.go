@@ -0,0 +1,80 @@
+package fynewidgets
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fyne.io/fyne/v2/storage"
+)
+
+func TestPyramidCacheReopen(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.png")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, testImage(600, 400))
+	f.Close()
+	uri := storage.NewFileURI(file)
+
+	cache, err := NewPyramidCache(filepath.Join(dir, "cache"), DefaultPyramidCacheLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit, Cache: cache}
+
+	first, err := NewPyramidFromFile(uri, image.Pt(20, 20), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := first.Region(2, first.Bounds(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := cache.Size(); size == 0 {
+		t.Fatal("nothing was written to the cache")
+	}
+
+	second, err := NewPyramidFromFile(uri, image.Pt(20, 20), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := second.Region(2, second.Bounds(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.source != nil {
+		t.Error("the file was decoded although its reduced levels were cached")
+	}
+	if !bytes.Equal(got.Pix, want.Pix) {
+		t.Error("cached level differs from the one first made")
+	}
+
+	if err := cache.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := cache.Size(); size != 0 {
+		t.Errorf("%d bytes left after purging", size)
+	}
+
+	other, err := storage.ParseURI("other://" + filepath.ToSlash(file)) // not a local file, so not cached
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPyramidFromFile(other, image.Pt(20, 20), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if size, _ := cache.Size(); size != 0 {
+		t.Errorf("%d bytes cached for a URI that is not a file", size)
+	}
+	if err := cache.Remove(other); err != nil {
+		t.Error(err)
+	}
+}
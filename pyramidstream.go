@@ -0,0 +1,154 @@
+package fynewidgets
+
+import (
+	"context"
+	"image"
+	"image/draw"
+
+	"fyne.io/fyne/v2"
+)
+
+// rows of a level received during a streaming build that are still needed to make the level above
+type streamLevel struct {
+	rows draw.Image // in the level's own coordinates
+	next int        // next row of tiles to make of the level above
+}
+
+// makes every level of the pyramid from rows of its full-resolution image as they are decoded, so that only a few
+// rows of tiles of each level are held at once, rather than the whole image. Each level is made exactly as it would
+// be from tiles, and its tiles are cached and saved to the pyramid's store. Full-resolution tiles are saved too, if
+// the store has room for them, so that zooming right in later does not need the file to be decoded whole.
+//
+//	progress  if not nil, is called after each band of rows with the rows decoded so far and the height of the image
+func (p *Pyramid) stream(ctx context.Context, dec rowDecoder, progress func(done, total int)) error {
+	T := p.tilesize
+	W, H := p.bounds[0].Dx(), p.bounds[0].Dy()
+	savefull := false // full-resolution tiles would squeeze the reduced levels out of a small cache
+	if p.store != nil {
+		savefull = int64(W)*int64(H)*int64(p.format.pixelBytes())*4/3 < p.store.cache.Limit()
+	}
+
+	overview := p.newOverview()
+	line := make([]float32, overview.codec.channels()*W)
+	levels := make([]streamLevel, p.Height())
+	for y := 0; y < H; y += T {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		band := p.format.newImage(image.Rect(0, y, W, min(y+T, H)))
+		if err := dec.read(band); err != nil {
+			return err
+		}
+		read := overview.codec.reader(band)
+		for i := 0; i < band.Bounds().Dy(); i++ {
+			read(i, line)
+			overview.add(y+i, line)
+		}
+		if savefull {
+			for col := 0; col*T < W; col++ {
+				r := p.tileRect(0, col, y/T)
+				tile := p.format.newImage(r)
+				blit(tile, r, band, r.Min)
+				p.store.save(tileKey{0, col, y / T}, tile)
+			}
+		}
+		if err := p.feed(ctx, levels, 0, band); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(band.Bounds().Max.Y, H)
+		}
+	}
+	p.keepOverview(overview.image(), tileKey{level: -1})
+	p.memory.touch(p)
+	return nil
+}
+
+// adds a band of new rows of a level, and makes every row of tiles of the level above that they complete, which are
+// fed on in turn. The coarsest level is not made here, as it comes from the overview.
+func (p *Pyramid) feed(ctx context.Context, levels []streamLevel, level int, band draw.Image) error {
+	above := level + 1
+	if above >= p.Height()-1 {
+		return nil
+	}
+	l := &levels[level]
+	l.rows = appendRows(l.rows, band, p.format)
+	have := l.rows.Bounds()
+	T := p.tilesize
+	for l.next*T < p.bounds[above].Dy() {
+		row := p.bounds[above].Intersect(image.Rect(0, l.next*T, p.bounds[above].Dx(), (l.next+1)*T))
+		if p.reduceSource(above, row).Max.Y > have.Max.Y {
+			return nil // wait for more rows
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		made := p.format.newImage(row)
+		for col := 0; col*T < row.Dx(); col++ {
+			r := p.tileRect(above, col, l.next)
+			s := p.reduceSource(above, r)
+			finer := p.format.newImage(s.Sub(s.Min))
+			blit(finer, finer.Bounds(), l.rows, s.Min)
+			tile := p.halve(finer, s, r)
+			p.keep(tileKey{above, col, l.next}, tile)
+			blit(made, r, tile, r.Min)
+		}
+		l.next++
+		if err := p.feed(ctx, levels, above, made); err != nil {
+			return err
+		}
+		below := p.reduceSource(above, image.Rect(0, l.next*T, 1, l.next*T+1)).Min.Y // the first row the next row of tiles needs
+		l.rows = dropRows(l.rows, below, p.format)
+	}
+	return nil
+}
+
+// rows followed by a band of the rows after them, in a new image if there were rows already
+func appendRows(rows, band draw.Image, format PixelFormat) draw.Image {
+	if rows == nil || rows.Bounds().Empty() {
+		return band
+	}
+	r := rows.Bounds().Union(band.Bounds())
+	joined := format.newImage(r)
+	blit(joined, rows.Bounds(), rows, rows.Bounds().Min)
+	blit(joined, band.Bounds(), band, band.Bounds().Min)
+	return joined
+}
+
+// rows from y down, in a new image if any were dropped
+func dropRows(rows draw.Image, y int, format PixelFormat) draw.Image {
+	b := rows.Bounds()
+	if y <= b.Min.Y {
+		return rows
+	}
+	r := image.Rect(b.Min.X, min(y, b.Max.Y), b.Max.X, b.Max.Y)
+	kept := format.newImage(r)
+	blit(kept, r, rows, r.Min)
+	return kept
+}
+
+// makes a pyramid by streaming a file, saving it in the store if there is one. Returns nil and no error if the file
+// cannot be streamed, or is too small to be worth it, so has to be decoded whole instead.
+func streamPyramid(ctx context.Context, uri fyne.URI, smallestsize image.Point, options PyramidOptions, store *pyramidStore, progress func(done, total int)) (*Pyramid, error) {
+	dec, err := openRowDecoder(uri)
+	if err != nil {
+		return nil, nil // decoding the whole file reports any real problem with it
+	}
+	defer dec.close()
+	p, err := newPyramid(dec.size(), dec.format(), smallestsize, options)
+	if err != nil {
+		return nil, err
+	}
+	if p.Height() < 2 {
+		return nil, nil
+	}
+	p.store = store
+	if err := p.stream(ctx, dec, progress); err != nil {
+		p.Close()
+		return nil, err
+	}
+	if store != nil && store.create(uri, options.settings(), p.format, p.bounds) != nil {
+		p.store = nil
+	}
+	return p, nil
+}
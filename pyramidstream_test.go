@@ -0,0 +1,190 @@
+package fynewidgets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fyne.io/fyne/v2/storage"
+	"golang.org/x/image/tiff"
+)
+
+func TestPyramidStream(t *testing.T) {
+	grey := image.NewGray16(image.Rect(0, 0, 530, 370))
+	for y := 0; y < 370; y++ {
+		for x := 0; x < 530; x++ {
+			grey.SetGray16(x, y, color.Gray16{uint16(x*97 + y*31)})
+		}
+	}
+	dir := t.TempDir()
+	files := []struct {
+		name  string
+		img   image.Image
+		write func(f *os.File, img image.Image) error
+	}{
+		{"rgba.png", testImage(530, 370), func(f *os.File, img image.Image) error { return png.Encode(f, img) }},
+		{"grey16.png", grey, func(f *os.File, img image.Image) error { return png.Encode(f, img) }},
+		{"rgba.tif", testImage(530, 370), func(f *os.File, img image.Image) error { return tiff.Encode(f, img, nil) }},
+		{"grey16.tif", grey, func(f *os.File, img image.Image) error {
+			return tiff.Encode(f, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+		}},
+		{"ycbcr.jpg", testImage(530, 370), func(f *os.File, img image.Image) error { return jpeg.Encode(f, img, nil) }},
+		{"grey.jpg", image.NewGray(image.Rect(0, 0, 530, 370)), func(f *os.File, img image.Image) error {
+			draw.Draw(img.(*image.Gray), img.Bounds(), testImage(530, 370), image.Point{}, draw.Src)
+			return jpeg.Encode(f, img, &jpeg.Options{Quality: 95})
+		}},
+		{"restarts.jpg", testImage(530, 370), func(f *os.File, img image.Image) error {
+			var b bytes.Buffer
+			if err := jpeg.Encode(&b, img, nil); err != nil {
+				return err
+			}
+			return withRestarts(f, b.Bytes(), 7)
+		}},
+	}
+	cache, err := NewPyramidCache(filepath.Join(dir, "cache"), DefaultPyramidCacheLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := file.write(f, file.img); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		options := PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit, Cache: cache, Filter: FilterLanczos}
+		rows := 0
+		streamed, err := NewPyramidFromFileContext(context.Background(), storage.NewFileURI(path), image.Pt(20, 20), options, func(done, total int) { rows = done })
+		if err != nil {
+			t.Fatalf("%s: %v", file.name, err)
+		}
+		if rows != 370 || streamed.source != nil {
+			t.Errorf("%s: was not streamed", file.name)
+		}
+		options.Cache = nil
+		img, err := loadImage(context.Background(), storage.NewFileURI(path)) // as a JPEG decodes, rather than as it was
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := NewPyramidWithOptions(img, image.Pt(20, 20), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if streamed.Format() != decoded.Format() {
+			t.Fatalf("%s: streamed as %v, decoded as %v", file.name, streamed.Format(), decoded.Format())
+		}
+		for level := 0; level < decoded.Height(); level++ {
+			want, _ := decoded.Pixels(level, decoded.Bounds(level))
+			got, err := streamed.Pixels(level, streamed.Bounds(level))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tileBytes(got), tileBytes(want)) {
+				t.Errorf("%s: streamed level %d differs from the decoded one", file.name, level)
+			}
+		}
+		if streamed.source != nil {
+			t.Errorf("%s: full-resolution tiles were not read from the cache", file.name)
+		}
+		streamed.Close()
+		decoded.Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = NewPyramidFromFileContext(ctx, storage.NewFileURI(filepath.Join(dir, "rgba.png")), image.Pt(20, 20), PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit}, func(done, total int) { cancel() })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("streaming returned %v after cancelling", err)
+	}
+
+	// the compressed rows are intact, so only the CRC of the last IDAT chunk, before IEND, gives this away
+	data, err := os.ReadFile(filepath.Join(dir, "rgba.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[bytes.LastIndex(data, []byte("IEND"))-5] ^= 1
+	path := filepath.Join(dir, "corrupt.png")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPyramidFromFileContext(context.Background(), storage.NewFileURI(path), image.Pt(20, 20), PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit}, nil); err == nil {
+		t.Error("a corrupted PNG was streamed without error")
+	}
+
+	// imaging turns a JPEG to suit its Exif orientation, so one that needs turning is decoded whole
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, testImage(530, 370), nil); err != nil {
+		t.Fatal(err)
+	}
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	app1 := append([]byte{0xff, 0xe1, 0, byte(len(exif) + 2)}, exif...)
+	path = filepath.Join(dir, "turned.jpg")
+	if err := os.WriteFile(path, append(append([]byte{0xff, 0xd8}, app1...), b.Bytes()[2:]...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rows := 0
+	turned, err := NewPyramidFromFileContext(context.Background(), storage.NewFileURI(path), image.Pt(20, 20), PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit}, func(done, total int) { rows = done })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 || turned.Bounds(0).Size() != image.Pt(370, 530) {
+		t.Errorf("a JPEG to be turned was streamed as %v", turned.Bounds(0).Size())
+	}
+	turned.Close()
+}
+
+// writes a baseline JPEG again with a restart marker after every n MCUs
+func withRestarts(f *os.File, data []byte, n int) error {
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if _, err := f.Seek(2, io.SeekStart); err != nil {
+		return err
+	}
+	d, err := newJPEGRows(f)
+	if err != nil {
+		return err
+	}
+	out := &bytes.Buffer{}
+	d.header(out, d.h)
+	header := out.Bytes()
+	sos := bytes.LastIndex(header, []byte{0xff, 0xda})
+	rewritten := append(append(bytes.Clone(header[:sos]), 0xff, 0xdd, 0, 4, 0, byte(n)), header[sos:]...)
+	out = bytes.NewBuffer(rewritten)
+	w := &jpegBitWriter{out: out}
+	for mcu := 0; mcu < d.across*((d.h+d.mcuh-1)/d.mcuh); mcu++ {
+		if mcu > 0 && mcu%n == 0 {
+			w.flush()
+			out.Write([]byte{0xff, byte(0xd0 + (mcu/n-1)%8)})
+			for _, c := range d.scan {
+				d.comps[c].out = 0
+			}
+		}
+		for _, c := range d.scan {
+			for i := 0; i < d.comps[c].h*d.comps[c].v; i++ {
+				if err := d.block(&d.comps[c], w); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	w.flush()
+	out.Write([]byte{0xff, 0xd9})
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(out.Bytes(), 0)
+	return err
+}
@@ -0,0 +1,67 @@
+package fynewidgets
+
+import (
+	"context"
+	"sync"
+)
+
+// renderScheduler runs a widget's renders one at a time in the background. Requests made while a render is running
+// are coalesced into one more render, of the latest state, once it finishes, and the context of a render overtaken by
+// a request is cancelled, so that it gives up on work whose result would only be thrown away.
+type renderScheduler struct {
+	mu        sync.Mutex
+	requested uint64 // requests made so far
+	running   bool
+	cancel    context.CancelFunc // cancels the render running
+	wait      *sync.Cond         // signalled when the scheduler goes idle
+	render    func(ctx context.Context)
+}
+
+func newRenderScheduler(render func(ctx context.Context)) *renderScheduler {
+	s := &renderScheduler{render: render}
+	s.wait = sync.NewCond(&s.mu)
+	return s
+}
+
+// asks for a render of the latest state. Never blocks.
+func (s *renderScheduler) request() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requested++
+	if s.running {
+		if s.cancel != nil {
+			s.cancel() // the render running is stale now
+		}
+		return
+	}
+	s.running = true
+	go s.run()
+}
+
+func (s *renderScheduler) run() {
+	s.mu.Lock()
+	for {
+		generation := s.requested
+		ctx, cancel := context.WithCancel(context.Background())
+		s.cancel = cancel
+		s.mu.Unlock()
+		s.render(ctx)
+		cancel()
+		s.mu.Lock()
+		if s.requested == generation {
+			break
+		}
+	}
+	s.running, s.cancel = false, nil
+	s.wait.Broadcast()
+	s.mu.Unlock()
+}
+
+// waits until every render asked for so far has been done or overtaken
+func (s *renderScheduler) idle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.running {
+		s.wait.Wait()
+	}
+}
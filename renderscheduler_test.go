@@ -0,0 +1,153 @@
+package fynewidgets
+
+import (
+	"context"
+	"image"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/test"
+	eventbus "github.com/dtomasi/go-event-bus/v3"
+)
+
+func TestRenderScheduler(t *testing.T) {
+	var state, seen atomic.Int64
+	var running, renders atomic.Int32
+	s := newRenderScheduler(func(ctx context.Context) {
+		if running.Add(1) > 1 {
+			t.Error("renders overlapped")
+		}
+		renders.Add(1)
+		seen.Store(state.Load())
+		for i := 0; i < 1000 && ctx.Err() == nil; i++ { // a render that gives up when overtaken
+		}
+		running.Add(-1)
+	})
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				state.Add(1)
+				s.request()
+			}
+		}()
+	}
+	wg.Wait()
+	s.idle()
+	if seen.Load() != state.Load() {
+		t.Errorf("last render saw state %d, want %d", seen.Load(), state.Load())
+	}
+	if n := renders.Load(); n < 1 || n > 4000 {
+		t.Errorf("%d renders for 4000 requests", n)
+	}
+}
+
+func TestStaleRenderGivesUp(t *testing.T) {
+	d, err := NewDatum(testImage(800, 600), image.Pt(20, 20), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := fyne.NewSize(400, 300)
+	d.FitDevice(size)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, _, err := d.availableImage(ctx, size, d.Level()); err == nil {
+		t.Error("a view was drawn for a render that was already overtaken")
+	}
+	if _, _, _, err := d.availableImage(context.Background(), size, d.Level()); err != nil {
+		t.Error(err)
+	}
+}
+
+// a widget showing a 1001 x 701 test image in a 400 x 300 test window, once its first render is done. The widget and
+// the window are closed when the test ends. The caller makes the app.
+func newTestPanZoom(t *testing.T, bus *eventbus.EventBus) (*PanZoomCanvas, fyne.Window) {
+	t.Helper()
+	p, err := NewPanZoomCanvasFromImage(testImage(1001, 701), image.Pt(20, 20), bus, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ExtendBaseWidget(p)
+	w := test.NewWindow(p)
+	t.Cleanup(func() {
+		p.stopMoving()
+		p.render.idle()
+		p.Close()
+		w.Close()
+	})
+	w.Resize(fyne.NewSize(400, 300))
+	p.Resize(fyne.NewSize(400, 300))
+	p.render.idle()
+	return p, w
+}
+
+// the image position a widget shows at a device position, and its ticks
+func shownAt(p *PanZoomCanvas, at fyne.Position) (ImagePoint, int) {
+	s, _ := p.Snapshot()
+	return s.Projection().DeviceToImage(at), s.Ticks
+}
+
+// calls each function n times, with 0 to n-1, each in its own goroutine, and waits for them all
+func stress(n int, fns ...func(i int)) {
+	wg := sync.WaitGroup{}
+	for _, f := range fns {
+		wg.Add(1)
+		go func(f func(int)) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				f(i)
+			}
+		}(f)
+	}
+	wg.Wait()
+}
+
+// step i of a drag with the primary button that starts every 10 steps, moving a pixel at a time
+func dragStep(p *PanZoomCanvas, i int) {
+	at := fyne.NewPos(float32(100+i), float32(80+i/2))
+	switch i % 10 {
+	case 0:
+		p.MouseDown(&desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: at}, Button: desktop.MouseButtonPrimary})
+	case 9:
+		p.MouseUp(&desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: at}, Button: desktop.MouseButtonPrimary})
+	default:
+		p.MouseMoved(&desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: at}})
+	}
+}
+
+func TestPanZoomCanvasStress(t *testing.T) {
+	test.NewTempApp(t)
+	p, _ := newTestPanZoom(t, eventbus.NewEventBus())
+
+	s, _ := p.Snapshot()
+	start := s.Ticks
+	centre := fyne.NewPos(200, 150)
+	for i := 0; i < 8; i++ { // every tick counts, however fast they come
+		p.Scrolled(&fyne.ScrollEvent{PointEvent: fyne.PointEvent{Position: centre}, Scrolled: fyne.Delta{DY: 1}})
+	}
+	if s, _ = p.Snapshot(); s.Ticks != start+8 {
+		t.Errorf("8 scroll ticks took the datum from %d to %d ticks", start, s.Ticks)
+	}
+
+	p.SetViewAnimation(DefaultViewAnimation())
+	stress(100, func(i int) {
+		p.Scrolled(&fyne.ScrollEvent{PointEvent: fyne.PointEvent{Position: centre}, Scrolled: fyne.Delta{DY: float32(i%3 - 1)}})
+	}, func(i int) {
+		dragStep(p, i)
+	}, func(i int) {
+		p.TypedRune([]rune("r]12hv0")[i%7])
+		if i%20 == 0 {
+			p.Back()
+			p.Forward()
+		}
+	}, func(int) {
+		p.Refresh()
+		p.ViewState()
+		p.HistoryStatus()
+	})
+}
@@ -0,0 +1,278 @@
+package fynewidgets
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// PyramidFilter selects the resampling filter used to make each pyramid level from the one below
+type PyramidFilter int
+
+const (
+	FilterGaussian   PyramidFilter = iota // soft, with no ringing. The default
+	FilterBox                             // plain average of the pixels covered
+	FilterLanczos                         // sharp, with slight ringing at hard edges
+	FilterCatmullRom                      // sharp cubic
+	FilterMitchell                        // cubic between sharp and smooth (Mitchell-Netravali)
+)
+
+func (f PyramidFilter) String() string {
+	switch f {
+	case FilterBox:
+		return "box"
+	case FilterLanczos:
+		return "lanczos"
+	case FilterCatmullRom:
+		return "catmullrom"
+	case FilterMitchell:
+		return "mitchell"
+	}
+	return "gaussian"
+}
+
+// the imaging filter for this choice
+func (f PyramidFilter) resampleFilter() imaging.ResampleFilter {
+	switch f {
+	case FilterBox:
+		return imaging.Box
+	case FilterLanczos:
+		return imaging.Lanczos
+	case FilterCatmullRom:
+		return imaging.CatmullRom
+	case FilterMitchell:
+		return imaging.MitchellNetravali
+	}
+	return imaging.Gaussian
+}
+
+var srgbToLinear [256]float32   // 8-bit sRGB value to linear light, 0-1
+var linearToSRGB [1 << 16]uint8 // linear light, quantised to 16 bits, to 8-bit sRGB
+const linearsteps = len(linearToSRGB) - 1
+
+func init() {
+	for i := range srgbToLinear {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			srgbToLinear[i] = float32(c / 12.92)
+		} else {
+			srgbToLinear[i] = float32(math.Pow((c+0.055)/1.055, 2.4))
+		}
+	}
+	for i := range linearToSRGB {
+		c := float64(i) / float64(linearsteps)
+		if c <= 0.0031308 {
+			c *= 12.92
+		} else {
+			c = 1.055*math.Pow(c, 1/2.4) - 0.055
+		}
+		linearToSRGB[i] = uint8(c*255 + .5)
+	}
+}
+
+// contribution of one source pixel to one destination pixel
+type tap struct {
+	index  int
+	weight float32
+}
+
+// the source pixels, and their weights, that make up each of n destination pixels resampled from m source pixels
+func taps(n, m int, filter imaging.ResampleFilter) [][]tap {
+	scale := float64(m) / float64(n)
+	widen := max(scale, 1) // stretch the filter when shrinking, so that it averages over every pixel it replaces
+	support := filter.Support * widen
+	out := make([][]tap, n)
+	for i := range out {
+		centre := (float64(i)+.5)*scale - .5
+		lo := max(int(math.Ceil(centre-support)), 0)
+		hi := min(int(math.Floor(centre+support)), m-1)
+		sum := 0.0
+		for j := lo; j <= hi; j++ {
+			w := filter.Kernel((float64(j) - centre) / widen)
+			if w != 0 {
+				out[i] = append(out[i], tap{j, float32(w)})
+				sum += w
+			}
+		}
+		if sum == 0 { // filter fell between pixels - take the nearest
+			out[i] = []tap{{min(max(int(centre+.5), 0), m-1), 1}}
+			continue
+		}
+		for k := range out[i] {
+			out[i][k].weight /= float32(sum)
+		}
+	}
+	return out
+}
+
+// converts between the pixels of a pyramid format and the float channels that are resampled. 8-bit colour is
+// resampled in sRGB, or in linear light if asked. High bit depth values are resampled as they are, without any gamma,
+// as they are usually measurements. Colour is weighted by alpha.
+type sampleCodec struct {
+	format PixelFormat
+	linear bool
+}
+
+// number of float channels per pixel
+func (c sampleCodec) channels() int {
+	if c.format == FormatGray16 || c.format == FormatGray32f {
+		return 1
+	}
+	return 4
+}
+
+// a function that fills a line with the values of row y of an image, counted from the top of its bounds
+func (c sampleCodec) reader(src image.Image) func(y int, line []float32) {
+	b := src.Bounds()
+	row := c.format.newImage(image.Rect(0, 0, b.Dx(), 1))
+	if c.format == FormatNRGBA {
+		row = image.NewRGBA(row.Bounds()) // premultiplied, so that draw.Draw does the weighting by alpha
+	}
+	return func(y int, line []float32) {
+		if c.format == FormatNRGBA {
+			draw.Draw(row, row.Bounds(), src, image.Pt(b.Min.X, b.Min.Y+y), draw.Src)
+		} else {
+			blit(row, row.Bounds(), src, image.Pt(b.Min.X, b.Min.Y+y))
+		}
+		switch r := row.(type) {
+		case *image.RGBA:
+			for x := 0; x < b.Dx(); x++ {
+				i := 4 * x
+				a := r.Pix[i+3]
+				if a == 0 {
+					line[i], line[i+1], line[i+2], line[i+3] = 0, 0, 0, 0
+					continue
+				}
+				alpha := float32(a) / 255
+				for ch := 0; ch < 3; ch++ {
+					if c.linear {
+						unpremultiplied := min(int(r.Pix[i+ch])*255/int(a), 255)
+						line[i+ch] = srgbToLinear[unpremultiplied] * alpha
+					} else {
+						line[i+ch] = float32(r.Pix[i+ch])
+					}
+				}
+				line[i+3] = alpha
+			}
+		case *image.Gray16:
+			for x := 0; x < b.Dx(); x++ {
+				line[x] = float32(r.Gray16At(x, 0).Y)
+			}
+		case *Gray32f:
+			copy(line, r.Pix)
+		case *image.NRGBA64:
+			for x := 0; x < b.Dx(); x++ {
+				p := r.NRGBA64At(x, 0)
+				alpha := float32(p.A) / 0xffff
+				line[4*x], line[4*x+1], line[4*x+2], line[4*x+3] = float32(p.R)*alpha, float32(p.G)*alpha, float32(p.B)*alpha, alpha
+			}
+		}
+	}
+}
+
+// an image of w x h pixels of the codec's format, from values packed row by row
+func (c sampleCodec) image(values []float32, w, h int) image.Image {
+	dst := c.format.newImage(image.Rect(0, 0, w, h))
+	to8 := func(v float32) uint8 { return uint8(min(max(v, 0), 255) + .5) }
+	to16 := func(v float32) uint16 { return uint16(min(max(v, 0), 0xffff) + .5) }
+	channels := c.channels()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := channels * (y*w + x)
+			switch d := dst.(type) {
+			case *image.NRGBA:
+				alpha := min(max(values[i+3], 0), 1)
+				if alpha == 0 {
+					continue // transparent black
+				}
+				j := d.PixOffset(x, y)
+				for ch := 0; ch < 3; ch++ {
+					if c.linear {
+						v := min(max(values[i+ch]/alpha, 0), 1)
+						d.Pix[j+ch] = linearToSRGB[int(v*float32(linearsteps)+.5)]
+					} else {
+						d.Pix[j+ch] = to8(values[i+ch] / alpha)
+					}
+				}
+				d.Pix[j+3] = uint8(alpha*255 + .5)
+			case *image.Gray16:
+				d.SetGray16(x, y, color.Gray16{to16(values[i])})
+			case *Gray32f:
+				d.SetGray32f(x, y, values[i])
+			case *image.NRGBA64:
+				alpha := min(max(values[i+3], 0), 1)
+				if alpha == 0 {
+					continue
+				}
+				d.SetNRGBA64(x, y, color.NRGBA64{to16(values[i] / alpha), to16(values[i+1] / alpha), to16(values[i+2] / alpha), to16(alpha * 0xffff)})
+			}
+		}
+	}
+	return dst
+}
+
+// resamples rows of an image, which arrive one at a time from the top, to w x h pixels, so that the whole source never
+// has to be held at once. Only the destination, as floats, is kept.
+type rowResizer struct {
+	codec  sampleCodec
+	w      int
+	across [][]tap   // source pixels making up each destination column
+	into   [][]tap   // destination rows that each source row contributes to
+	narrow []float32 // the latest source row, resampled across to w pixels
+	values []float32 // destination rows, summed so far
+}
+
+func newRowResizer(codec sampleCodec, sw, sh, w, h int, filter imaging.ResampleFilter) *rowResizer {
+	r := &rowResizer{codec: codec, w: w, across: taps(w, sw, filter), into: make([][]tap, sh)}
+	for y, t := range taps(h, sh, filter) {
+		for _, k := range t {
+			r.into[k.index] = append(r.into[k.index], tap{y, k.weight})
+		}
+	}
+	r.narrow = make([]float32, codec.channels()*w)
+	r.values = make([]float32, codec.channels()*w*h)
+	return r
+}
+
+// adds source row y, whose values were filled by the codec's reader
+func (r *rowResizer) add(y int, line []float32) {
+	if len(r.into[y]) == 0 {
+		return
+	}
+	channels := r.codec.channels()
+	clear(r.narrow)
+	for x, t := range r.across {
+		for _, k := range t {
+			for c := 0; c < channels; c++ {
+				r.narrow[channels*x+c] += line[channels*k.index+c] * k.weight
+			}
+		}
+	}
+	for _, k := range r.into[y] {
+		sum := r.values[channels*r.w*k.index : channels*r.w*(k.index+1)]
+		for i, v := range r.narrow {
+			sum[i] += v * k.weight
+		}
+	}
+}
+
+// the resampled image, once every source row has been added
+func (r *rowResizer) image() image.Image {
+	return r.codec.image(r.values, r.w, len(r.values)/(r.codec.channels()*r.w))
+}
+
+// resizes an image to w x h a row at a time, in the codec's format
+func resizeSamples(src image.Image, codec sampleCodec, w, h int, filter imaging.ResampleFilter) image.Image {
+	b := src.Bounds()
+	r := newRowResizer(codec, b.Dx(), b.Dy(), w, h, filter)
+	read := codec.reader(src)
+	line := make([]float32, codec.channels()*b.Dx())
+	for y := 0; y < b.Dy(); y++ {
+		read(y, line)
+		r.add(y, line)
+	}
+	return r.image()
+}
@@ -23,7 +23,9 @@ type SynchronisedImageGrid struct {
 	// columnchannel chan int         // requests to change the number of columns in the grid are received on this channel
 	// infochannel   chan interface{} // status updates and progress meter changes are sent from here to the app
 	// datumchannel  chan Datum       // listens to changes in pan and zoom on one widget, and sends it to the others, to keep them synchronised
-	bus *eventbus.EventBus
+	bus      *eventbus.EventBus
+	mu       sync.Mutex
+	panzooms []*PanZoomCanvas // in the grid, kept apart from its objects so that they can be read while it changes
 }
 
 func NewSynchronisedImageGrid(numberofcolumns int, bus *eventbus.EventBus) (*SynchronisedImageGrid, error) {
@@ -66,18 +68,17 @@ func (s *SynchronisedImageGrid) Images() ([]image.Image, error) {
 	if s.grid == nil {
 		return nil, errors.New("no grid yet")
 	}
-	if len(s.grid.Objects) == 0 {
+	members := s.members()
+	if len(members) == 0 {
 		return nil, errors.New("empty grid - no images to return")
 	}
-	images := make([]image.Image, len(s.grid.Objects))
-	for i := range s.grid.Objects {
-		if im, ok := s.grid.Objects[i].(*PanZoomCanvas); ok {
-			im, err := im.CurrentImage()
-			if err != nil {
-				continue
-			}
-			images[i] = im
+	images := make([]image.Image, len(members))
+	for i, p := range members {
+		im, err := p.CurrentImage()
+		if err != nil {
+			continue
 		}
+		images[i] = im
 	}
 	return images, nil
 }
@@ -86,10 +87,23 @@ func (s *SynchronisedImageGrid) RemoveAll() error {
 	if s.grid == nil {
 		return errors.New("no grid to remove items from")
 	}
+	s.closeAll()
 	s.grid.RemoveAll()
+	s.mu.Lock()
+	s.panzooms = nil
+	s.mu.Unlock()
 	return nil
 }
 
+// stops images in the grid from loading, before they are removed
+func (s *SynchronisedImageGrid) closeAll() {
+	for _, o := range s.grid.Objects {
+		if im, ok := o.(*PanZoomCanvas); ok {
+			im.Close()
+		}
+	}
+}
+
 func (s *SynchronisedImageGrid) AddPanZoom(items ...*PanZoomCanvas) error {
 	if s.grid == nil {
 		return errors.New("no grid defined")
@@ -99,6 +113,9 @@ func (s *SynchronisedImageGrid) AddPanZoom(items ...*PanZoomCanvas) error {
 		// pz.SetDatumChannel(s.datumchannel)
 		s.grid.Add(pz)
 	}
+	s.mu.Lock()
+	s.panzooms = append(s.panzooms, items...)
+	s.mu.Unlock()
 	return nil
 }
 
@@ -124,35 +141,39 @@ func (s *SynchronisedImageGrid) AddPanZoom(items ...*PanZoomCanvas) error {
 // 	}()
 // }
 
+// the PanZoomCanvases in the grid
+func (s *SynchronisedImageGrid) members() []*PanZoomCanvas {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*PanZoomCanvas(nil), s.panzooms...)
+}
+
+// shows the view of whichever widget changed on all the others. Each gets its own copy of the view, from a snapshot.
+// A change older than one already shown is dropped, as is one older than a widget's own latest change, so that the
+// widgets end up showing the latest change whatever order they arrive in.
 func (s *SynchronisedImageGrid) monitorDatumChanges() {
 
 	datumchannel := s.bus.Subscribe("datum:changed")
 
 	go func() {
+		var latest uint64
 		for x := range datumchannel {
-			if len(s.grid.Objects) == 0 {
+			e, ok := x.Data.(DatumChanged)
+			if !ok || e.Sequence <= latest {
 				continue
 			}
-			datum := x.Data.(*Datum)
+			latest = e.Sequence
+			members := s.members()
 			wg := &sync.WaitGroup{}
-			wg.Add(len(s.grid.Objects))
-			for i := range s.grid.Objects {
-				go func(i int, wg *sync.WaitGroup) {
+			for _, im := range members {
+				if im == e.Source {
+					continue
+				}
+				wg.Add(1)
+				go func(im *PanZoomCanvas) {
 					defer wg.Done()
-					if im, ok := s.grid.Objects[i].(*PanZoomCanvas); ok {
-						otherdatum := im.Datum()
-						if otherdatum == nil {
-							return
-						}
-						otherdatum.DeviceCoords = datum.DeviceCoords
-						otherdatum.ImageCoords = datum.ImageCoords
-						otherdatum.Scale = datum.Scale
-						otherdatum.Ticks = datum.Ticks
-						otherdatum.Sensitivity = datum.Sensitivity
-						otherdatum.Pyramid.SetLevel(datum.Pyramid.Level())
-						im.Refresh()
-					}
-				}(i, wg)
+					im.follow(e)
+				}(im)
 			}
 			wg.Wait()
 		}
@@ -163,7 +184,11 @@ func (s *SynchronisedImageGrid) monitorDatumChanges() {
 
 func (s *SynchronisedImageGrid) SetImages(uris []fyne.URI) {
 
+	s.closeAll()
 	s.grid.RemoveAll()
+	s.mu.Lock()
+	s.panzooms = nil
+	s.mu.Unlock()
 	for i := range uris {
 		im, err := NewPanZoomCanvasFromFile(uris[i], image.Pt(100, 100), s.bus)
 		if err != nil {
@@ -176,6 +201,9 @@ func (s *SynchronisedImageGrid) SetImages(uris []fyne.URI) {
 		}
 		// im.SetDatumChannel(s.datumchannel)
 		s.grid.Add(im)
+		s.mu.Lock()
+		s.panzooms = append(s.panzooms, im)
+		s.mu.Unlock()
 		s.bus.Publish("text:status", "Loaded image from "+uris[i].Path())
 	}
 	s.Refresh()
@@ -0,0 +1,617 @@
+package fynewidgets
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/tiff/lzw"
+)
+
+// TIFF tags read here
+const (
+	tiffSubfileType     = 254
+	tiffWidth           = 256
+	tiffHeight          = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffPhotometric     = 262
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPlanar          = 284
+	tiffPredictor       = 317
+	tiffTileWidth       = 322
+	tiffTileLength      = 323
+	tiffTileOffsets     = 324
+	tiffTileByteCounts  = 325
+	tiffSubIFDs         = 330
+	tiffExtraSamples    = 338
+	tiffSampleFormat    = 339
+	tiffJPEGTables      = 347
+)
+
+// bytes taken by each value of a TIFF field type
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4, 16: 8, 17: 8, 18: 8}
+
+// one image of a TIFF or BigTIFF file: its size, how its samples are stored, and where its tiles or strips are.
+// Strips are treated as tiles as wide as the image.
+type tiffIFD struct {
+	order        binary.ByteOrder
+	width        int
+	height       int
+	depth        int // bits per sample
+	samples      int
+	compression  int
+	photometric  int
+	predictor    int
+	extra        int // 1 for premultiplied alpha, 2 for straight alpha, 0 for none
+	sampleformat int
+	planar       int
+	subfile      int // NewSubfileType: bit 0 is set for reduced-resolution images
+	tiled        bool
+	tilewidth    int
+	tileheight   int
+	offsets      []int64
+	counts       []int64
+	jpegtables   []byte
+	subifds      []int64
+}
+
+// true if the first bytes of a file are those of a TIFF or BigTIFF
+func isTIFFMagic(magic []byte) bool {
+	if len(magic) < 4 {
+		return false
+	}
+	switch string(magic[:4]) {
+	case "II*\x00", "MM\x00*", "II+\x00", "MM\x00+":
+		return true
+	}
+	return false
+}
+
+// true if a file starts as a TIFF or BigTIFF does, so that it is worth reading its IFDs
+func isTIFFFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return isTIFFMagic(magic)
+}
+
+// reads the header of a TIFF or BigTIFF, returning its byte order, whether it is a BigTIFF, and where its first IFD is
+func readTIFFHeader(r io.ReaderAt) (binary.ByteOrder, bool, int64, error) {
+	header := make([]byte, 16)
+	if _, err := r.ReadAt(header[:8], 0); err != nil {
+		return nil, false, 0, errors.Wrap(err, "reading TIFF")
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, false, 0, errors.New("not a TIFF")
+	}
+	switch order.Uint16(header[2:]) {
+	case 42:
+		return order, false, int64(order.Uint32(header[4:])), nil
+	case 43:
+		if _, err := r.ReadAt(header, 0); err != nil {
+			return nil, false, 0, errors.Wrap(err, "reading BigTIFF")
+		}
+		return order, true, int64(order.Uint64(header[8:])), nil
+	}
+	return nil, false, 0, errors.New("not a TIFF")
+}
+
+// reads the IFD at an offset, returning it and the offset of the next IFD, which is 0 after the last. Nothing the IFD
+// says is longer than the file, of the given size, is read.
+func readTIFFIFD(r io.ReaderAt, filesize int64, order binary.ByteOrder, big bool, offset int64) (*tiffIFD, int64, error) {
+	countsize, entrysize, inline := 2, 12, 4
+	if big {
+		countsize, entrysize, inline = 8, 20, 8
+	}
+	b := make([]byte, countsize)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, 0, errors.Wrap(err, "reading TIFF IFD")
+	}
+	n := int64(order.Uint16(b))
+	if big {
+		n = int64(order.Uint64(b))
+	}
+	if n <= 0 || n > 1<<16 || n*int64(entrysize) > filesize {
+		return nil, 0, errors.New("bad TIFF IFD")
+	}
+	entries := make([]byte, int(n)*entrysize+inline)
+	if _, err := r.ReadAt(entries, offset+int64(countsize)); err != nil {
+		return nil, 0, errors.Wrap(err, "reading TIFF IFD")
+	}
+
+	d := &tiffIFD{order: order, depth: 1, samples: 1, compression: 1, predictor: 1, sampleformat: 1, planar: 1}
+	perstrip := 0
+	var stripoffsets, stripcounts, tileoffsets, tilecounts []int64
+	for i := 0; i < int(n); i++ {
+		e := entries[i*entrysize : (i+1)*entrysize]
+		tag, kind := order.Uint16(e), order.Uint16(e[2:])
+		count := int64(order.Uint32(e[4:]))
+		value := e[8:12]
+		if big {
+			count = int64(order.Uint64(e[4:]))
+			value = e[12:20]
+		}
+		size := tiffTypeSizes[kind]
+		if size == 0 || count < 0 || count > filesize/int64(size) {
+			continue
+		}
+		data := value
+		if int(count)*size > inline {
+			at := int64(order.Uint32(value))
+			if big {
+				at = int64(order.Uint64(value))
+			}
+			data = make([]byte, int(count)*size)
+			if _, err := r.ReadAt(data, at); err != nil {
+				return nil, 0, errors.Wrap(err, "reading TIFF IFD")
+			}
+		}
+		if tag == tiffJPEGTables {
+			d.jpegtables = data[:count]
+			continue
+		}
+		values := make([]int64, 0, count)
+		for j := 0; j < int(count); j++ {
+			switch size {
+			case 1:
+				values = append(values, int64(data[j]))
+			case 2:
+				values = append(values, int64(order.Uint16(data[2*j:])))
+			case 4:
+				values = append(values, int64(order.Uint32(data[4*j:])))
+			case 8:
+				values = append(values, int64(order.Uint64(data[8*j:])))
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		switch tag {
+		case tiffSubfileType:
+			d.subfile = int(values[0])
+		case tiffWidth:
+			d.width = int(values[0])
+		case tiffHeight:
+			d.height = int(values[0])
+		case tiffBitsPerSample:
+			d.depth = int(values[0])
+			for _, v := range values {
+				if int(v) != d.depth {
+					d.depth = 0 // mixed depths are not supported
+				}
+			}
+		case tiffCompression:
+			d.compression = int(values[0])
+		case tiffPhotometric:
+			d.photometric = int(values[0])
+		case tiffStripOffsets:
+			stripoffsets = values
+		case tiffSamplesPerPixel:
+			d.samples = int(values[0])
+		case tiffRowsPerStrip:
+			perstrip = int(values[0])
+		case tiffStripByteCounts:
+			stripcounts = values
+		case tiffPlanar:
+			d.planar = int(values[0])
+		case tiffPredictor:
+			d.predictor = int(values[0])
+		case tiffTileWidth:
+			d.tilewidth = int(values[0])
+		case tiffTileLength:
+			d.tileheight = int(values[0])
+		case tiffTileOffsets:
+			tileoffsets = values
+		case tiffTileByteCounts:
+			tilecounts = values
+		case tiffSubIFDs:
+			d.subifds = values
+		case tiffExtraSamples:
+			d.extra = int(values[0])
+		case tiffSampleFormat:
+			d.sampleformat = int(values[0])
+		}
+	}
+	next := entries[int(n)*entrysize:]
+	nextoffset := int64(order.Uint32(next))
+	if big {
+		nextoffset = int64(order.Uint64(next))
+	}
+
+	if tileoffsets != nil {
+		d.tiled = true
+		d.offsets, d.counts = tileoffsets, tilecounts
+	} else {
+		d.tilewidth, d.tileheight = d.width, perstrip
+		if perstrip <= 0 || perstrip > d.height {
+			d.tileheight = d.height
+		}
+		d.offsets, d.counts = stripoffsets, stripcounts
+	}
+	if d.width <= 0 || d.height <= 0 || d.tilewidth <= 0 || d.tileheight <= 0 || len(d.offsets) == 0 || len(d.offsets) != len(d.counts) {
+		return nil, 0, errors.New("bad TIFF IFD")
+	}
+	for i, count := range d.counts {
+		if count < 0 || count > filesize || d.offsets[i] < 0 || d.offsets[i] > filesize-count {
+			return nil, 0, errors.New("bad TIFF IFD")
+		}
+	}
+	return d, nextoffset, nil
+}
+
+// an error if the image is stored in a way that cannot be read here
+func (d *tiffIFD) supported() error {
+	colour := d.photometric == 2 && (d.samples == 3 || (d.samples == 4 && d.extra != 0))
+	grey := d.photometric <= 1 && d.samples == 1
+	ycbcr := d.photometric == 6 && d.samples == 3 && d.compression == 7 // JPEG does its own colour conversion
+	float := d.depth == 32 && d.sampleformat == 3 && grey
+	switch {
+	case d.planar != 1:
+		return errors.New("TIFF samples are stored in separate planes")
+	case !colour && !grey && !ycbcr:
+		return errors.Errorf("TIFF photometric interpretation %d with %d samples is not supported", d.photometric, d.samples)
+	case !float && ((d.depth != 8 && d.depth != 16) || d.sampleformat != 1):
+		return errors.Errorf("TIFF samples of %d bits, format %d, are not supported", d.depth, d.sampleformat)
+	case d.compression != 1 && d.compression != 5 && d.compression != 7 && d.compression != 8 && d.compression != 32946,
+		d.compression == 7 && d.depth != 8:
+		return errors.Errorf("TIFF compression %d of %d bit samples is not supported", d.compression, d.depth)
+	case d.predictor != 1 && (d.predictor != 2 || float):
+		return errors.Errorf("TIFF predictor %d is not supported", d.predictor)
+	case len(d.offsets) < ((d.width+d.tilewidth-1)/d.tilewidth)*((d.height+d.tileheight-1)/d.tileheight):
+		return errors.New("TIFF has too few tiles")
+	}
+	return nil
+}
+
+// the pyramid format of the image, which for 8 and 16 bit images is what golang.org/x/image/tiff would decode to
+func (d *tiffIFD) format() PixelFormat {
+	switch {
+	case d.depth == 32:
+		return FormatGray32f
+	case d.depth == 16 && d.samples == 1:
+		return FormatGray16
+	case d.depth == 16:
+		return FormatNRGBA64
+	}
+	return FormatNRGBA
+}
+
+// bytes in a row of a tile
+func (d *tiffIFD) rowBytes() int {
+	return d.tilewidth * d.samples * d.depth / 8
+}
+
+// a reader of the decompressed bytes of compressed data, for any compression but JPEG
+func (d *tiffIFD) decompress(r io.Reader) (io.Reader, error) {
+	switch d.compression {
+	case 5:
+		return lzw.NewReader(r, lzw.MSB, 8), nil
+	case 8, 32946:
+		return zlib.NewReader(r)
+	}
+	return r, nil
+}
+
+// area of the image covered by a tile
+func (d *tiffIFD) tileRect(i int) image.Rectangle {
+	across := (d.width + d.tilewidth - 1) / d.tilewidth
+	col, row := i%across, i/across
+	return image.Rect(col*d.tilewidth, row*d.tileheight, (col+1)*d.tilewidth, (row+1)*d.tileheight).Intersect(image.Rect(0, 0, d.width, d.height))
+}
+
+// reads and decodes a tile (or strip), returning it in the image's format with its bounds in the image's coordinates
+func (d *tiffIFD) decodeTile(r io.ReaderAt, i int) (draw.Image, error) {
+	rect := d.tileRect(i)
+	data := make([]byte, d.counts[i])
+	if _, err := r.ReadAt(data, d.offsets[i]); err != nil {
+		return nil, errors.Wrap(err, "reading TIFF tile")
+	}
+	tile := d.format().newImage(rect)
+
+	if d.compression == 7 {
+		if len(d.jpegtables) > 4 && len(data) > 2 { // tables and tile are each a whole JPEG stream - join them, dropping the tables' EOI and the tile's SOI
+			data = append(append([]byte{}, d.jpegtables[:len(d.jpegtables)-2]...), data[2:]...)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding TIFF tile")
+		}
+		blit(tile, rect, img, img.Bounds().Min)
+		return tile, nil
+	}
+
+	pixels, err := d.decompress(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding TIFF tile")
+	}
+	raw := make([]byte, d.rowBytes())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		if _, err := io.ReadFull(pixels, raw); err != nil {
+			return nil, errors.Wrap(err, "decoding TIFF tile")
+		}
+		d.convert(raw, tile, rect.Min.X, y, rect.Dx())
+	}
+	return tile, nil
+}
+
+// a streaming reader of the decompressed bytes of a strip, for reading a row at a time
+func (d *tiffIFD) stripReader(r io.ReaderAt, i int) (io.Reader, error) {
+	return d.decompress(bufio.NewReader(io.NewSectionReader(r, d.offsets[i], d.counts[i])))
+}
+
+// sets n pixels of a row of dst, starting at x0, from the raw samples of a row of a tile, undoing any predictor
+func (d *tiffIFD) convert(s []byte, dst draw.Image, x0, y, n int) {
+	if d.predictor == 2 { // each sample is stored as the difference from the one to its left
+		step := d.samples * d.depth / 8
+		if d.depth == 8 {
+			for i := step; i < len(s); i++ {
+				s[i] += s[i-step]
+			}
+		} else {
+			for i := step; i+1 < len(s); i += 2 {
+				d.order.PutUint16(s[i:], d.order.Uint16(s[i:])+d.order.Uint16(s[i-step:]))
+			}
+		}
+	}
+	if g, ok := dst.(*Gray32f); ok {
+		for x := 0; x < n; x++ {
+			g.SetGray32f(x0+x, y, math.Float32frombits(d.order.Uint32(s[4*x:])))
+		}
+		return
+	}
+	if nrgba, ok := dst.(*image.NRGBA); ok && d.depth == 8 && d.samples == 3 {
+		p := nrgba.Pix[nrgba.PixOffset(x0, y):]
+		for x := 0; x < n; x++ {
+			p[4*x], p[4*x+1], p[4*x+2], p[4*x+3] = s[3*x], s[3*x+1], s[3*x+2], 0xff
+		}
+		return
+	}
+	sample := func(x, c int) uint16 { // as 16 bits
+		if d.depth == 8 {
+			return uint16(s[d.samples*x+c]) * 0x101
+		}
+		return d.order.Uint16(s[2*(d.samples*x+c):])
+	}
+	for x := 0; x < n; x++ {
+		var c color.Color
+		if d.samples == 1 {
+			v := sample(x, 0)
+			if d.photometric == 0 {
+				v = 0xffff - v
+			}
+			c = color.Gray16{v}
+		} else {
+			r, g, b, a := sample(x, 0), sample(x, 1), sample(x, 2), uint16(0xffff)
+			if d.samples == 4 {
+				a = sample(x, 3)
+			}
+			if d.extra == 2 {
+				c = color.NRGBA64{r, g, b, a}
+			} else {
+				c = color.RGBA64{r, g, b, a}
+			}
+		}
+		dst.Set(x0+x, y, c)
+	}
+}
+
+// TIFFTileSource reads a tiled TIFF or BigTIFF, such as a whole-slide image or a GIS raster, a tile at a time straight
+// from the file. Reduced-resolution images in the file, later in its chain of images or in SubIFDs, are used for the
+// levels they match; levels the file lacks are made by the pyramid from the level below.
+type TIFFTileSource struct {
+	file   *os.File
+	ifds   []*tiffIFD // by level, nil where the file has no image for the level
+	bounds []image.Rectangle
+}
+
+func NewTIFFTileSource(path string) (*TIFFTileSource, error) {
+	return openTIFFTileSource(context.Background(), path)
+}
+
+// NewTIFFTileSource, giving up if the context is cancelled while the file's IFDs are read
+func openTIFFTileSource(ctx context.Context, path string) (*TIFFTileSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "f: NewTIFFTileSource")
+	}
+	s, err := newTIFFTileSource(ctx, f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "f: NewTIFFTileSource")
+	}
+	return s, nil
+}
+
+func newTIFFTileSource(ctx context.Context, f *os.File) (*TIFFTileSource, error) {
+	order, big, first, err := readTIFFHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var images []*tiffIFD
+	seen := make(map[int64]bool) // a damaged file could loop
+	var read func(offset int64) error
+	read = func(offset int64) error {
+		for offset != 0 && !seen[offset] && len(seen) < 256 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			seen[offset] = true
+			ifd, next, err := readTIFFIFD(f, info.Size(), order, big, offset)
+			if err != nil {
+				return err
+			}
+			images = append(images, ifd)
+			for _, sub := range ifd.subifds {
+				if err := read(sub); err != nil {
+					return err
+				}
+			}
+			offset = next
+		}
+		return nil
+	}
+	if err := read(first); err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, errors.New("f: newTIFFTileSource - no images in file")
+	}
+	base := images[0]
+	if !base.tiled {
+		return nil, errors.New("TIFF is not tiled")
+	}
+	if err := base.supported(); err != nil {
+		return nil, err
+	}
+
+	s := &TIFFTileSource{file: f, bounds: []image.Rectangle{image.Rect(0, 0, base.width, base.height)}}
+	for size := image.Pt(base.width, base.height); size.X > 1 || size.Y > 1; {
+		size = image.Pt((size.X+1)/2, (size.Y+1)/2)
+		s.bounds = append(s.bounds, image.Rectangle{Max: size})
+	}
+	s.ifds = make([]*tiffIFD, len(s.bounds))
+	s.ifds[0] = base
+	near := func(have, want int) bool { // reduced images are often a pixel or so off the exact halving
+		return abs(have-want) <= max(1, want/100)
+	}
+	for _, ifd := range images[1:] {
+		if !ifd.tiled || ifd.supported() != nil || ifd.format() != base.format() {
+			continue // eg the label and macro images of a slide
+		}
+		for level := 1; level < len(s.bounds); level++ {
+			want := s.bounds[level].Size()
+			if s.ifds[level] == nil && near(ifd.width, want.X) && near(ifd.height, want.Y) {
+				s.ifds[level] = ifd
+				break
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *TIFFTileSource) Size() image.Point { return s.bounds[0].Size() }
+
+func (s *TIFFTileSource) Levels() int { return len(s.bounds) }
+
+func (s *TIFFTileSource) TileSize() int { return max(s.ifds[0].tilewidth, 16) }
+
+// the pixel format of the file's images
+func (s *TIFFTileSource) Format() PixelFormat { return s.ifds[0].format() }
+
+// true if the file has an image for a level
+func (s *TIFFTileSource) HasLevel(level int) bool {
+	return level >= 0 && level < len(s.ifds) && s.ifds[level] != nil
+}
+
+func (s *TIFFTileSource) Close() error { return s.file.Close() }
+
+// reads the tile from every tile of the level's image that it touches. Images a pixel smaller than the level have
+// their last column or row repeated.
+func (s *TIFFTileSource) Tile(ctx context.Context, level, col, row int) (image.Image, error) {
+	if !s.HasLevel(level) {
+		return nil, errors.Errorf("TIFF has no image for level %d", level)
+	}
+	ifd := s.ifds[level]
+	T := s.TileSize()
+	r := image.Rect(col*T, row*T, (col+1)*T, (row+1)*T).Intersect(s.bounds[level])
+	if r.Empty() {
+		return nil, errors.Errorf("tile %d,%d is outside level %d", col, row, level)
+	}
+	extent := image.Rect(0, 0, ifd.width, ifd.height)
+	have := image.Rect(min(r.Min.X, extent.Max.X-1), min(r.Min.Y, extent.Max.Y-1), min(r.Max.X, extent.Max.X), min(r.Max.Y, extent.Max.Y))
+	tile := ifd.format().newImage(r)
+	got := tile
+	if have != r {
+		got = ifd.format().newImage(have)
+	}
+	across := (ifd.width + ifd.tilewidth - 1) / ifd.tilewidth
+	for y := have.Min.Y / ifd.tileheight; y*ifd.tileheight < have.Max.Y; y++ {
+		for x := have.Min.X / ifd.tilewidth; x*ifd.tilewidth < have.Max.X; x++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			part, err := ifd.decodeTile(s.file, y*across+x)
+			if err != nil {
+				return nil, err
+			}
+			overlap := part.Bounds().Intersect(have)
+			blit(got, overlap, part, overlap.Min)
+		}
+	}
+	if have != r {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				tile.Set(x, y, got.At(min(max(x, have.Min.X), have.Max.X-1), min(max(y, have.Min.Y), have.Max.Y-1)))
+			}
+		}
+	}
+	return tile, nil
+}
+
+// decodes the first image of a TIFF or BigTIFF whole, for files golang.org/x/image/tiff cannot read, such as BigTIFFs,
+// float rasters and JPEG-compressed tiles
+func decodeTIFF(ctx context.Context, path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	order, big, first, err := readTIFFHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	ifd, _, err := readTIFFIFD(f, info.Size(), order, big, first)
+	if err != nil {
+		return nil, err
+	}
+	if err := ifd.supported(); err != nil {
+		return nil, err
+	}
+	img := ifd.format().newImage(image.Rect(0, 0, ifd.width, ifd.height))
+	across, down := (ifd.width+ifd.tilewidth-1)/ifd.tilewidth, (ifd.height+ifd.tileheight-1)/ifd.tileheight
+	for i := 0; i < across*down; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		part, err := ifd.decodeTile(f, i)
+		if err != nil {
+			return nil, err
+		}
+		blit(img, part.Bounds(), part, part.Bounds().Min)
+	}
+	return img, nil
+}
@@ -0,0 +1,369 @@
+package fynewidgets
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"fyne.io/fyne/v2/storage"
+	"github.com/disintegration/imaging"
+)
+
+func TestTIFFTileSource(t *testing.T) {
+	rgb := testImage(1001, 701)
+	grey := image.NewGray16(image.Rect(0, 0, 1001, 701))
+	for y := 0; y < 701; y++ {
+		for x := 0; x < 1001; x++ {
+			grey.SetGray16(x, y, color.Gray16{uint16(x*61 + y*29)})
+		}
+	}
+	greyquarter := image.NewGray16(image.Rect(0, 0, 250, 175)) // a pixel short of level 2, as some writers round down
+	for y := 0; y < 175; y++ {
+		for x := 0; x < 250; x++ {
+			greyquarter.SetGray16(x, y, grey.Gray16At(4*x, 4*y))
+		}
+	}
+	dir := t.TempDir()
+	files := []struct {
+		name    string
+		big     bool
+		deflate bool
+		images  []image.Image
+	}{
+		{"rgb.tif", false, true, []image.Image{rgb, imaging.Resize(rgb, 251, 176, imaging.Box), imaging.Resize(rgb, 100, 30, imaging.Box)}},
+		{"grey16.btf", true, false, []image.Image{grey, greyquarter}},
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		if err := writeTiledTIFF(path, file.big, file.deflate, 64, file.images...); err != nil {
+			t.Fatal(err)
+		}
+		source, err := NewTIFFTileSource(path)
+		if err != nil {
+			t.Fatalf("%s: %v", file.name, err)
+		}
+		if !source.HasLevel(0) || source.HasLevel(1) || !source.HasLevel(2) || source.HasLevel(3) {
+			t.Errorf("%s: wrong levels found in the file", file.name)
+		}
+		source.Close()
+
+		p, err := NewPyramidFromFile(storage.NewFileURI(path), image.Pt(20, 20), PyramidOptions{TileSize: 256, CacheLimit: DefaultTileCacheLimit})
+		if err != nil {
+			t.Fatalf("%s: %v", file.name, err)
+		}
+		if p.remote == nil || p.TileSize() != 64 || p.Format() != pixelFormatOf(file.images[0]) {
+			t.Fatalf("%s: not read through its tiles", file.name)
+		}
+		if p.Bounds(1) != image.Rect(0, 0, 501, 351) || p.Bounds(2) != image.Rect(0, 0, 251, 176) {
+			t.Errorf("%s: levels are %v and %v", file.name, p.Bounds(1), p.Bounds(2))
+		}
+		r := image.Rect(100, 90, 700, 400)
+		got, err := p.Pixels(0, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameImage(got, crop(file.images[0], r)) {
+			t.Errorf("%s: full resolution differs from the file", file.name)
+		}
+		got, err = p.Pixels(2, p.Bounds(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := file.images[1].Bounds()
+		if !sameImage(crop(got, stored), file.images[1]) {
+			t.Errorf("%s: level 2 differs from the file's reduced image", file.name)
+		}
+		for level := 1; level < p.Height(); level += 2 { // made from the level below
+			if _, err := p.Pixels(level, p.Bounds(level)); err != nil {
+				t.Errorf("%s: level %d: %v", file.name, level, err)
+			}
+		}
+		p.Close()
+
+		whole, err := LoadImage(storage.NewFileURI(path))
+		if err != nil {
+			t.Fatalf("%s: %v", file.name, err)
+		}
+		if !sameImage(*whole, file.images[0]) {
+			t.Errorf("%s: loaded image differs", file.name)
+		}
+	}
+}
+
+func TestTiledTIFFCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "flat.tif")
+	if err := writeTiledTIFF(path, false, true, 64, testImage(1001, 701)); err != nil { // no reduced images
+		t.Fatal(err)
+	}
+	cache, err := NewPyramidCache(filepath.Join(dir, "cache"), DefaultPyramidCacheLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := PyramidOptions{TileSize: 256, CacheLimit: DefaultTileCacheLimit, Cache: cache}
+	uri := storage.NewFileURI(path)
+
+	first, err := NewPyramidFromFile(uri, image.Pt(20, 20), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := first.Height() - 1
+	want, err := first.Region(top, first.Bounds(top))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	second, err := NewPyramidFromFile(uri, image.Pt(20, 20), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.remote.(*TIFFTileSource).file.Close() // any tile read from the file now fails
+	got, err := second.Region(top, second.Bounds(top))
+	if err != nil {
+		t.Fatalf("coarsest level not read from the cache: %v", err)
+	}
+	if !bytes.Equal(got.Pix, want.Pix) {
+		t.Error("cached level differs from the one first made")
+	}
+
+	notiff := filepath.Join(dir, "image.tif") // named as a TIFF, but a PNG
+	f, err := os.Create(notiff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, testImage(300, 200))
+	f.Close()
+	p, err := NewPyramidFromFile(storage.NewFileURI(notiff), image.Pt(20, 20), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.remote != nil {
+		t.Error("a PNG was read as a tiled TIFF")
+	}
+	p.Close()
+}
+
+func TestMalformedTIFF(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "good.tif")
+	if err := writeTiledTIFF(path, false, false, 64, testImage(300, 200)); err != nil {
+		t.Fatal(err)
+	}
+	good, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sets the count, or the first value, of an entry of the first IFD
+	entry := func(tag uint16, value bool, v uint32) []byte {
+		b := bytes.Clone(good)
+		ifd := binary.LittleEndian.Uint32(b[4:])
+		for i := 0; i < int(binary.LittleEndian.Uint16(b[ifd:])); i++ {
+			e := b[ifd+2+uint32(i)*12:]
+			if binary.LittleEndian.Uint16(e) != tag {
+				continue
+			}
+			at := e[4:]
+			if value {
+				at = b[binary.LittleEndian.Uint32(e[8:]):]
+			}
+			binary.LittleEndian.PutUint32(at, v)
+		}
+		return b
+	}
+	files := map[string][]byte{
+		"noimages.tif":  []byte("II*\x00\x00\x00\x00\x00"),
+		"longcount.tif": entry(tiffTileOffsets, false, 1<<27),
+		"longtile.tif":  entry(tiffTileByteCounts, true, 1<<30),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if s, err := NewTIFFTileSource(path); err == nil {
+			s.Close()
+			t.Errorf("%s: opened", name)
+		}
+		runtime.ReadMemStats(&after)
+		if after.TotalAlloc-before.TotalAlloc > 1<<24 {
+			t.Errorf("%s: %d bytes allocated", name, after.TotalAlloc-before.TotalAlloc)
+		}
+		if p, err := NewPyramidFromFile(storage.NewFileURI(path), image.Pt(20, 20), PyramidOptions{TileSize: 64, CacheLimit: DefaultTileCacheLimit}); err == nil {
+			p.Close()
+			t.Errorf("%s: made a pyramid", name)
+		}
+	}
+}
+
+func crop(img image.Image, r image.Rectangle) image.Image {
+	return img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(r)
+}
+
+// writes a tiled TIFF, or BigTIFF, of 8-bit RGB or 16-bit grey images, one IFD each, in tiles of T pixels that are
+// deflated with a horizontal predictor if asked
+func writeTiledTIFF(path string, big, deflate bool, T int, images ...image.Image) error {
+	order := binary.LittleEndian
+	out := &bytes.Buffer{}
+	u16 := func(v int) { out.Write(order.AppendUint16(nil, uint16(v))) }
+	u32 := func(v int) { out.Write(order.AppendUint32(nil, uint32(v))) }
+	u64 := func(v int) { out.Write(order.AppendUint64(nil, uint64(v))) }
+	pointer := func(v int) { // an offset or count, which is 8 bytes in a BigTIFF
+		if big {
+			u64(v)
+		} else {
+			u32(v)
+		}
+	}
+	patch := func(at, v int) {
+		if big {
+			order.PutUint64(out.Bytes()[at:], uint64(v))
+		} else {
+			order.PutUint32(out.Bytes()[at:], uint32(v))
+		}
+	}
+	out.WriteString("II")
+	if big {
+		u16(43)
+		u16(8)
+		u16(0)
+	} else {
+		u16(42)
+	}
+	next := out.Len()
+	pointer(0)
+
+	for _, img := range images {
+		b := img.Bounds()
+		samples, depth := 3, 8
+		if _, ok := img.(*image.Gray16); ok {
+			samples, depth = 1, 16
+		}
+		step := samples * depth / 8
+		var offsets, counts []int
+		for ty := 0; ty < b.Dy(); ty += T {
+			for tx := 0; tx < b.Dx(); tx += T {
+				raw := make([]byte, 0, T*T*step)
+				for y := ty; y < ty+T; y++ {
+					row := make([]byte, T*step)
+					for x := tx; x < min(tx+T, b.Dx()) && y < b.Dy(); x++ {
+						c := img.At(b.Min.X+x, b.Min.Y+y)
+						if samples == 1 {
+							order.PutUint16(row[2*(x-tx):], color.Gray16Model.Convert(c).(color.Gray16).Y)
+						} else {
+							n := color.NRGBAModel.Convert(c).(color.NRGBA)
+							copy(row[3*(x-tx):], []byte{n.R, n.G, n.B})
+						}
+					}
+					if deflate { // store differences from the left
+						for i := len(row) - 1; i >= step; i-- {
+							row[i] -= row[i-step]
+						}
+					}
+					raw = append(raw, row...)
+				}
+				if deflate {
+					z := &bytes.Buffer{}
+					w := zlib.NewWriter(z)
+					w.Write(raw)
+					w.Close()
+					raw = z.Bytes()
+				}
+				offsets, counts = append(offsets, out.Len()), append(counts, len(raw))
+				out.Write(raw)
+			}
+		}
+
+		type entry struct {
+			tag, kind int
+			values    []int
+		}
+		long := 4
+		if big {
+			long = 16
+		}
+		compression, predictor, photometric := 1, 1, 1
+		if deflate {
+			compression, predictor = 8, 2
+		}
+		if samples == 3 {
+			photometric = 2
+		}
+		depths := []int{depth}
+		if samples == 3 {
+			depths = []int{8, 8, 8}
+		}
+		entries := []entry{
+			{tiffWidth, 4, []int{b.Dx()}},
+			{tiffHeight, 4, []int{b.Dy()}},
+			{tiffBitsPerSample, 3, depths},
+			{tiffCompression, 3, []int{compression}},
+			{tiffPhotometric, 3, []int{photometric}},
+			{tiffSamplesPerPixel, 3, []int{samples}},
+			{tiffPredictor, 3, []int{predictor}},
+			{tiffTileWidth, 3, []int{T}},
+			{tiffTileLength, 3, []int{T}},
+			{tiffTileOffsets, long, offsets},
+			{tiffTileByteCounts, long, counts},
+		}
+		inline := 4
+		if big {
+			inline = 8
+		}
+		size := func(e entry) int { return map[int]int{3: 2, 4: 4, 16: 8}[e.kind] * len(e.values) }
+		put := func(e entry) {
+			for _, v := range e.values {
+				switch e.kind {
+				case 3:
+					u16(v)
+				case 4:
+					u32(v)
+				default:
+					u64(v)
+				}
+			}
+		}
+		at := make([]int, len(entries)) // where values too big for their entry are written
+		for i, e := range entries {
+			if size(e) > inline {
+				at[i] = out.Len()
+				put(e)
+			}
+		}
+		if out.Len()%2 == 1 {
+			out.WriteByte(0)
+		}
+		patch(next, out.Len())
+		if big {
+			u64(len(entries))
+		} else {
+			u16(len(entries))
+		}
+		for i, e := range entries {
+			u16(e.tag)
+			u16(e.kind)
+			pointer(len(e.values))
+			if size(e) > inline {
+				pointer(at[i])
+				continue
+			}
+			put(e)
+			out.Write(make([]byte, inline-size(e)))
+		}
+		next = out.Len()
+		pointer(0)
+	}
+	return os.WriteFile(path, out.Bytes(), 0644)
+}
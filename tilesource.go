@@ -0,0 +1,296 @@
+package fynewidgets
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"math/bits"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TileSource supplies the tiles of an image that has been tiled already, such as a Deep Zoom folder or an IIIF image
+// server, so that it can be shown without ever holding the full image. Level 0 is full resolution, and each level is
+// half the width and height of the one before, rounded up.
+type TileSource interface {
+	Size() image.Point // width and height at full resolution
+	Levels() int       // number of levels available
+	TileSize() int     // width and height of tiles, in pixels of their own level
+	// the tile at a column and row of a level, without any overlap. Its bounds may have any origin
+	Tile(ctx context.Context, level, col, row int) (image.Image, error)
+}
+
+// implemented by tile sources whose tiles are not 8-bit, so that the pyramid keeps their full range
+type formattedTileSource interface {
+	Format() PixelFormat
+}
+
+// implemented by tile sources that lack some of their levels, which the pyramid then makes from the level below
+type partialTileSource interface {
+	HasLevel(level int) bool
+}
+
+// creates a pyramid whose tiles come from a tile source, as they are needed. Levels smaller than smallestsize are
+// ignored. Levels the source lacks, if it has a HasLevel method, are made from the level below, using the filter in
+// the options. Sources with a Format method give tiles in that format, and others 8-bit NRGBA.
+func NewPyramidFromTileSource(source TileSource, smallestsize image.Point, options PyramidOptions) (*Pyramid, error) {
+	if source == nil {
+		return nil, errors.New("f: NewPyramidFromTileSource - nil source")
+	}
+	format := FormatNRGBA
+	if f, ok := source.(formattedTileSource); ok {
+		format = f.Format()
+	}
+	options.TileSize = source.TileSize()
+	p, err := newPyramid(source.Size(), format, smallestsize, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "f: NewPyramidFromTileSource")
+	}
+	size := source.Size()
+	bounds := []image.Rectangle{image.Rect(0, 0, size.X, size.Y)}
+	for len(bounds) < source.Levels() {
+		size = image.Pt((size.X+1)/2, (size.Y+1)/2)
+		if size.X <= smallestsize.X || size.Y <= smallestsize.Y {
+			break
+		}
+		bounds = append(bounds, image.Rect(0, 0, size.X, size.Y))
+	}
+	p.remote = source
+	p.setBounds(bounds)
+	p.memory.touch(p)
+	return p, nil
+}
+
+// true if a level's tiles come from the pyramid's tile source
+func (p *Pyramid) fetches(level int) bool {
+	if p.remote == nil {
+		return false
+	}
+	partial, ok := p.remote.(partialTileSource)
+	return level == 0 || !ok || partial.HasLevel(level)
+}
+
+// fetches a tile from the pyramid's tile source
+func (p *Pyramid) fetch(ctx context.Context, key tileKey, r image.Rectangle) (image.Image, error) {
+	img, err := p.remote.Tile(ctx, key.level, key.col, key.row)
+	if err != nil {
+		return nil, err
+	}
+	if img.Bounds().Size() != r.Size() {
+		return nil, errors.Errorf("tile %d,%d of level %d is %v, expected %v", key.col, key.row, key.level, img.Bounds().Size(), r.Size())
+	}
+	tile := p.format.newImage(r)
+	blit(tile, r, img, img.Bounds().Min)
+	return tile, nil
+}
+
+// DZITileSource reads the tiles of a Deep Zoom Image from a .dzi descriptor and the _files folder beside it, as
+// written by ExportDZI
+type DZITileSource struct {
+	descriptor dziDescriptor
+	folder     string
+}
+
+func NewDZITileSource(path string) (*DZITileSource, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "f: NewDZITileSource")
+	}
+	s := &DZITileSource{folder: dziFolder(path)}
+	if err := xml.Unmarshal(b, &s.descriptor); err != nil {
+		return nil, errors.Wrap(err, "f: NewDZITileSource")
+	}
+	if s.descriptor.Size.Width <= 0 || s.descriptor.Size.Height <= 0 || s.descriptor.TileSize <= 0 {
+		return nil, errors.Errorf("f: NewDZITileSource - %s does not describe an image", path)
+	}
+	return s, nil
+}
+
+func (s *DZITileSource) Size() image.Point {
+	return image.Pt(s.descriptor.Size.Width, s.descriptor.Size.Height)
+}
+
+func (s *DZITileSource) Levels() int { return s.descriptor.levels() }
+
+func (s *DZITileSource) TileSize() int { return s.descriptor.TileSize }
+
+func (s *DZITileSource) Tile(ctx context.Context, level, col, row int) (image.Image, error) {
+	d := s.descriptor
+	dzilevel := d.levels() - 1 - level // Deep Zoom counts up from a single pixel
+	name := filepath.Join(s.folder, fmt.Sprint(dzilevel), fmt.Sprintf("%d_%d.%s", col, row, d.Format))
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, errors.Wrap(err, name)
+	}
+	withoverlap := d.tileRect(dzilevel, col, row)
+	T := d.TileSize
+	r := image.Rect(col*T, row*T, (col+1)*T, (row+1)*T).Intersect(d.bounds(dzilevel))
+	if img.Bounds().Size() != withoverlap.Size() {
+		return nil, errors.Errorf("%s is %v, expected %v", name, img.Bounds().Size(), withoverlap.Size())
+	}
+	tile := image.NewNRGBA(r)
+	blit(tile, r, img, img.Bounds().Min.Add(r.Min.Sub(withoverlap.Min)))
+	return tile, nil
+}
+
+// IIIFTileSource fetches tiles from a server implementing the IIIF Image API, version 2 or 3, asking for the server's
+// own tiles at the scale of a level. Levels run down to the largest scale factor the server lists that is a power of
+// two. A server of compliance level 0, which only serves the tiles it lists, has the levels of its other scale
+// factors made from the level below; other servers are asked for every level. A server that lists no tiles is asked
+// for regions at every scale down to one that fits in a single tile, so that coarse levels are never made by fetching
+// the full-resolution image; level 0 servers without tiles, or without full-resolution tiles, cannot be used.
+type IIIFTileSource struct {
+	base       string // image URI, without /info.json
+	client     *http.Client
+	size       image.Point
+	tilesize   int // width of the server's tiles, and of the pyramid's
+	tileheight int // height of the server's tiles
+	levels     int
+	listed     map[int]bool // levels the server has tiles for, if it only serves those
+	format     string       // file extension of tiles
+}
+
+// the information a IIIF server gives about an image
+type iiifInfo struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	Tiles  []struct {
+		Width        int   `json:"width"`
+		Height       int   `json:"height"` // the width if not given
+		ScaleFactors []int `json:"scaleFactors"`
+	} `json:"tiles"`
+	PreferredFormats []string        `json:"preferredFormats"`
+	Profile          json.RawMessage `json:"profile"` // "level0" or similar in version 3, a list starting with a compliance URI in version 2
+}
+
+// reads the information about an image from a IIIF server.
+//
+//	base    the image's URI, eg https://example.org/iiif/scan1, without /info.json
+//	client  for the requests, or http.DefaultClient if nil
+func NewIIIFTileSource(ctx context.Context, base string, client *http.Client) (*IIIFTileSource, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	s := &IIIFTileSource{base: strings.TrimSuffix(base, "/"), client: client, format: "jpg"}
+	info := iiifInfo{}
+	if err := s.get(ctx, s.base+"/info.json", func(r *http.Response) error { return json.NewDecoder(r.Body).Decode(&info) }); err != nil {
+		return nil, errors.Wrap(err, "f: NewIIIFTileSource")
+	}
+	if info.Width <= 0 || info.Height <= 0 {
+		return nil, errors.New("f: NewIIIFTileSource - server gave no image size")
+	}
+	s.size = image.Pt(info.Width, info.Height)
+	s.tilesize, s.tileheight, s.levels = 512, 512, 1
+	level0 := strings.Contains(string(info.Profile), "level0")
+	if len(info.Tiles) == 0 || info.Tiles[0].Width <= 0 {
+		if level0 {
+			return nil, errors.New("f: NewIIIFTileSource - server offers neither tiles nor regions")
+		}
+		for size := max(info.Width, info.Height); size > s.tilesize; size = (size + 1) / 2 { // regions at any scale, down to a single tile
+			s.levels++
+		}
+	} else {
+		s.tilesize, s.tileheight = info.Tiles[0].Width, info.Tiles[0].Height
+		if s.tileheight <= 0 {
+			s.tileheight = s.tilesize
+		}
+		listed := make(map[int]bool)
+		for _, f := range info.Tiles[0].ScaleFactors {
+			if level := bits.TrailingZeros(uint(f)); f > 0 && f == 1<<level { // other scales are no level of the pyramid
+				listed[level] = true
+				s.levels = max(s.levels, level+1)
+			}
+		}
+		if level0 {
+			if !listed[0] {
+				return nil, errors.New("f: NewIIIFTileSource - server offers no full-resolution tiles")
+			}
+			s.listed = listed
+		}
+	}
+	for _, f := range info.PreferredFormats {
+		if f == "jpg" || f == "png" {
+			s.format = f
+			break
+		}
+	}
+	return s, nil
+}
+
+func (s *IIIFTileSource) Size() image.Point { return s.size }
+
+func (s *IIIFTileSource) Levels() int { return s.levels }
+
+func (s *IIIFTileSource) TileSize() int { return s.tilesize }
+
+// true if the server has tiles for a level, or serves any region
+func (s *IIIFTileSource) HasLevel(level int) bool {
+	return level >= 0 && level < s.levels && (s.listed == nil || s.listed[level])
+}
+
+// the pyramid's square tile, from every tile of the server's that it touches, as the server's may be shorter or taller
+func (s *IIIFTileSource) Tile(ctx context.Context, level, col, row int) (image.Image, error) {
+	scale := 1 << level
+	levelsize := image.Pt((s.size.X+scale-1)/scale, (s.size.Y+scale-1)/scale)
+	r := image.Rect(col*s.tilesize, row*s.tilesize, (col+1)*s.tilesize, (row+1)*s.tilesize).Intersect(image.Rectangle{Max: levelsize})
+	if r.Empty() {
+		return nil, errors.Errorf("tile %d,%d is outside level %d", col, row, level)
+	}
+	if s.tileheight == s.tilesize {
+		return s.serverTile(ctx, scale, r)
+	}
+	tile := image.NewNRGBA(r)
+	for y := r.Min.Y / s.tileheight * s.tileheight; y < r.Max.Y; y += s.tileheight {
+		part := image.Rect(r.Min.X, y, r.Max.X, y+s.tileheight).Intersect(image.Rectangle{Max: levelsize})
+		img, err := s.serverTile(ctx, scale, part)
+		if err != nil {
+			return nil, err
+		}
+		blit(tile, part, img, img.Bounds().Min)
+	}
+	return tile, nil
+}
+
+// fetches the part of a level in a rectangle, in the level's pixels
+func (s *IIIFTileSource) serverTile(ctx context.Context, scale int, r image.Rectangle) (image.Image, error) {
+	full := image.Rect(r.Min.X*scale, r.Min.Y*scale, r.Max.X*scale, r.Max.Y*scale).Intersect(image.Rectangle{Max: s.size})
+	uri := fmt.Sprintf("%s/%d,%d,%d,%d/%d,%d/0/default.%s", s.base, full.Min.X, full.Min.Y, full.Dx(), full.Dy(), r.Dx(), r.Dy(), s.format)
+	var img image.Image
+	err := s.get(ctx, uri, func(r *http.Response) error {
+		var err error
+		img, _, err = image.Decode(r.Body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// requests a URI, handing a successful response to read
+func (s *IIIFTileSource) get(ctx context.Context, uri string, read func(*http.Response) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s: %s", uri, resp.Status)
+	}
+	return errors.Wrap(read(resp), uri)
+}
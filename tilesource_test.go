@@ -0,0 +1,222 @@
+package fynewidgets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestDZITileSource(t *testing.T) {
+	src := testImage(701, 500)
+	path := filepath.Join(t.TempDir(), "scan.dzi")
+	if err := ExportImageDZI(context.Background(), src, path, DZIOptions{TileSize: 128, Overlap: 2, Format: "png"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewDZITileSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPyramidFromTileSource(source, image.Pt(20, 20), DefaultPyramidOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.TileSize() != 128 || p.Bounds(1).Size() != image.Pt(351, 250) {
+		t.Fatalf("tile size %d and level 1 %v", p.TileSize(), p.Bounds(1).Size())
+	}
+	r := image.Rect(100, 120, 400, 300) // spans several tiles, each with overlap to remove
+	got, err := p.Region(0, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := imaging.Crop(src, r)
+	if !sameImage(got, want) {
+		t.Error("region fetched from Deep Zoom tiles differs from the source")
+	}
+}
+
+// a IIIF server for an image at /iiif/scan, describing it with info, which the size and a png format are added to.
+// A level 0 server with tiles refuses requests for anything but its tiles.
+// Region requests are counted.
+func iiifServer(src image.Image, info map[string]any, requests *atomic.Int32) *httptest.Server {
+	info["@context"] = "http://iiif.io/api/image/3/context.json"
+	info["width"], info["height"] = src.Bounds().Dx(), src.Bounds().Dy()
+	info["preferredFormats"] = []string{"png"}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/iiif/scan/info.json" {
+			json.NewEncoder(w).Encode(info)
+			return
+		}
+		var x, y, rw, rh, sw, sh int
+		if _, err := fmt.Sscanf(r.URL.Path, "/iiif/scan/%d,%d,%d,%d/%d,%d/0/default.png", &x, &y, &rw, &rh, &sw, &sh); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if tiles, ok := info["tiles"].([]map[string]any); ok && info["profile"] == "level0" && !iiifTile(tiles[0], src.Bounds().Size(), x, y, rw, rh, sw, sh) {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		region := imaging.Crop(src, image.Rect(x, y, x+rw, y+rh))
+		png.Encode(w, imaging.Resize(region, sw, sh, imaging.Box))
+	}))
+}
+
+// true if a request is for one of the tiles a IIIF server lists
+func iiifTile(tiles map[string]any, size image.Point, x, y, rw, rh, sw, sh int) bool {
+	w, h := tiles["width"].(int), tiles["height"].(int)
+	for _, s := range tiles["scaleFactors"].([]int) {
+		if x%(w*s) == 0 && y%(h*s) == 0 && rw == min(w*s, size.X-x) && rh == min(h*s, size.Y-y) && sw == (rw+s-1)/s && sh == (rh+s-1)/s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIIIFTileSource(t *testing.T) {
+	src := testImage(900, 700)
+	requests := atomic.Int32{}
+	server := iiifServer(src, map[string]any{"tiles": []map[string]any{{"width": 256, "scaleFactors": []int{1, 2, 4, 8}}}}, &requests)
+	defer server.Close()
+
+	source, err := NewIIIFTileSource(context.Background(), server.URL+"/iiif/scan", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Size() != image.Pt(900, 700) || source.Levels() != 4 || source.TileSize() != 256 {
+		t.Fatalf("size %v, %d levels, %d pixel tiles", source.Size(), source.Levels(), source.TileSize())
+	}
+	d, err := NewDatumFromTileSource(source, image.Pt(20, 20), 5, DefaultPyramidOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Pyramid.Close()
+
+	r := image.Rect(270, 300, 370, 400) // inside a single tile
+	got, err := d.Pyramid.Region(0, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(got, imaging.Crop(src, r)) {
+		t.Error("full-resolution region differs from the source")
+	}
+	if requests.Load() != 1 {
+		t.Errorf("%d tiles fetched for a region inside one tile", requests.Load())
+	}
+
+	top := d.Pyramid.Height() - 1
+	small, err := d.Pyramid.Region(top, d.Pyramid.Bounds(top))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small.Bounds().Size() != image.Pt(113, 88) {
+		t.Errorf("level %d is %v, want 113 x 88", top, small.Bounds().Size())
+	}
+}
+
+func TestIIIFTileSourceWithoutTiles(t *testing.T) {
+	src := testImage(1500, 1100)
+	requests := atomic.Int32{}
+	server := iiifServer(src, map[string]any{"profile": "level1"}, &requests)
+	defer server.Close()
+	source, err := NewIIIFTileSource(context.Background(), server.URL+"/iiif/scan", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Levels() != 3 { // 1500, 750 and 375 pixels across, the last in a single 512 pixel tile
+		t.Fatalf("%d levels, want 3", source.Levels())
+	}
+	p, err := NewPyramidFromTileSource(source, image.Pt(20, 20), DefaultPyramidOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	top := p.Height() - 1
+	if _, err := p.Region(top, p.Bounds(top)); err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("%d requests to show the image whole, want 1", requests.Load())
+	}
+
+	level0 := iiifServer(src, map[string]any{"profile": "level0"}, &requests)
+	defer level0.Close()
+	if _, err := NewIIIFTileSource(context.Background(), level0.URL+"/iiif/scan", level0.Client()); err == nil {
+		t.Error("a level 0 server without tiles was accepted")
+	}
+}
+
+func TestIIIFTileSourceScaleFactors(t *testing.T) {
+	src := testImage(900, 700)
+	requests := atomic.Int32{}
+	tiles := []map[string]any{{"width": 256, "height": 128, "scaleFactors": []int{1, 2, 4, 16}}}
+	server := iiifServer(src, map[string]any{"profile": "level0", "tiles": tiles}, &requests)
+	defer server.Close()
+	source, err := NewIIIFTileSource(context.Background(), server.URL+"/iiif/scan", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Levels() != 5 || source.TileSize() != 256 || !source.HasLevel(4) || source.HasLevel(3) {
+		t.Fatalf("%d levels of %d pixel tiles", source.Levels(), source.TileSize())
+	}
+	p, err := NewPyramidFromTileSource(source, image.Pt(20, 20), DefaultPyramidOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	r := image.Rect(200, 100, 600, 400) // across tiles of both sizes
+	got, err := p.Region(0, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(got, imaging.Crop(src, r)) {
+		t.Error("full-resolution region differs from the source")
+	}
+	for level := 1; level < p.Height(); level++ { // level 3 is made from level 2, as the server has no tiles for it
+		if _, err := p.Region(level, p.Bounds(level)); err != nil {
+			t.Errorf("level %d: %v", level, err)
+		}
+	}
+
+	for _, scales := range [][]int{{2, 4, 8}, {1, 2, 4, 16}} {
+		tiles := []map[string]any{{"width": 256, "height": 256, "scaleFactors": scales}}
+		server := iiifServer(src, map[string]any{"profile": "level2", "tiles": tiles}, &requests)
+		source, err := NewIIIFTileSource(context.Background(), server.URL+"/iiif/scan", server.Client())
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[int]int{8: 4, 16: 5}[scales[len(scales)-1]]; source.Levels() != want || !source.HasLevel(3) {
+			t.Errorf("scale factors %v give %d levels, want %d, all served", scales, source.Levels(), want)
+		}
+	}
+	tiles = []map[string]any{{"width": 256, "height": 256, "scaleFactors": []int{2, 4, 8}}}
+	level0 := iiifServer(src, map[string]any{"profile": "level0", "tiles": tiles}, &requests)
+	defer level0.Close()
+	if _, err := NewIIIFTileSource(context.Background(), level0.URL+"/iiif/scan", level0.Client()); err == nil {
+		t.Error("a level 0 server without full-resolution tiles was accepted")
+	}
+}
+
+func sameImage(a, b image.Image) bool {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return false
+	}
+	for y := 0; y < a.Bounds().Dy(); y++ {
+		for x := 0; x < a.Bounds().Dx(); x++ {
+			if !sameColour(a.At(a.Bounds().Min.X+x, a.Bounds().Min.Y+y), b.At(b.Bounds().Min.X+x, b.Bounds().Min.Y+y)) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package fynewidgets
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+)
+
+// ViewAnimation says how a PanZoomCanvas moves between views. The zero value jumps straight to each new view.
+type ViewAnimation struct {
+	Duration time.Duration // time taken to zoom, or fit, to a new view, or 0 to jump there
+	Kinetic  bool          // a fast drag carries on after the mouse is released, slowing to a stop
+}
+
+// a short eased zoom, and kinetic panning
+func DefaultViewAnimation() ViewAnimation {
+	return ViewAnimation{Duration: 150 * time.Millisecond, Kinetic: true}
+}
+
+const (
+	flingMinSpeed = 200.0 // device pixels a second a drag must be moving at to carry on
+	flingEndSpeed = 20.0  // speed at which a fling stops
+	flingDecay    = 0.3   // seconds for a fling's speed to fall by a factor of e
+)
+
+// what a datum shows, apart from its pyramid
+type datumView struct {
+	image    ImagePoint
+	device   fyne.Position
+	scale    float32
+	ticks    int
+	rotation float64
+	mirrored bool
+}
+
+func (d *Datum) view() datumView {
+	return datumView{*d.ImageCoords, *d.DeviceCoords, d.Scale, d.Ticks, d.Rotation, d.Mirrored}
+}
+
+// a datum showing the view, for its transforms only: its level is not set
+func (v datumView) datum(p *Pyramid) *Datum {
+	image, device := v.image, v.device
+	return &Datum{Pyramid: p, ImageCoords: &image, DeviceCoords: &device, Scale: v.scale, Ticks: v.ticks, Rotation: v.rotation, Mirrored: v.mirrored}
+}
+
+func (d *Datum) setView(v datumView) {
+	image, device := v.image, v.device
+	d.ImageCoords, d.DeviceCoords = &image, &device
+	d.Scale, d.Ticks, d.Rotation, d.Mirrored = v.scale, v.ticks, v.rotation, v.mirrored
+	d.level = d.levelForScale(d.Scale)
+}
+
+// sets the datum part of the way, t from 0 to 1, from one view to another. The scale changes geometrically about the
+// device point that shows the same image point in both views, so zooming about the mouse keeps the same point under it
+// all the way; views at the same scale slide across. Ticks are those of the end view, so that further zooming carries
+// on from there. Views turned differently are not blended: the datum jumps to the end.
+func (d *Datum) setBetween(from, to datumView, t float64) {
+	if t >= 1 || from.rotation != to.rotation || from.mirrored != to.mirrored || from.scale <= 0 || to.scale <= 0 {
+		d.setView(to)
+		return
+	}
+	start, end := from.datum(d.Pyramid), to.datum(d.Pyramid)
+	s0, s1 := float64(from.scale), float64(to.scale)
+	now := to
+	now.scale = float32(s0 * math.Pow(s1/s0, t))
+	if k := 1 - s0/s1; math.Abs(k) < 1e-6 {
+		p, _ := start.DeviceToImage(to.device) // what the end's anchor shows at the start
+		now.image = ImagePoint{p.X + (to.image.X-p.X)*t, p.Y + (to.image.Y-p.Y)*t}
+	} else {
+		a0, _ := start.DeviceToImage(fyne.Position{})
+		a1, _ := end.DeviceToImage(fyne.Position{})
+		fixed := ImagePoint{a0.X + (a1.X-a0.X)/k, a0.Y + (a1.Y-a0.Y)/k}
+		f, _ := start.ImageToDevice(fixed)
+		now.image, now.device = fixed, f
+	}
+	d.setView(now)
+}
+
+// how far, as a multiple of its starting speed, a fling has carried the image after a number of seconds
+func flingTravel(seconds float64) float64 {
+	return flingDecay * (1 - math.Exp(-seconds/flingDecay))
+}
+
+// a position of the mouse during a drag
+type dragSample struct {
+	position fyne.Position
+	at       time.Time
+}
+
+// moves a PanZoomCanvas's datum over time
+type viewMover struct {
+	mu        sync.Mutex
+	animation *fyne.Animation
+	drag      []dragSample // latest positions of the mouse while dragging
+}
+
+// sets how the widget moves between views: the zero value jumps, DefaultViewAnimation glides
+func (p *PanZoomCanvas) SetViewAnimation(a ViewAnimation) {
+	p.mu.Lock()
+	p.animation = a
+	p.mu.Unlock()
+}
+
+// changes the datum, gliding to the new view if the widget is animated, and redraws and tells other widgets. The new
+// view is remembered in the widget's history, unless the reason for the change is empty.
+func (p *PanZoomCanvas) changeView(reason string, change func(d *Datum) error) {
+	p.stopMoving()
+	p.mu.Lock()
+	if p.datum == nil || p.datum.Scale < 0 {
+		p.mu.Unlock()
+		return
+	}
+	from := p.datum.view()
+	if err := change(p.datum); err != nil {
+		p.mu.Unlock()
+		return
+	}
+	to := p.datum.view()
+	if reason != "" {
+		p.rememberView(reason)
+	}
+	duration := p.animation.Duration
+	animate := duration > 0 && from != to
+	if animate {
+		p.datum.setView(from)
+	}
+	p.mu.Unlock()
+	if !animate {
+		p.changed()
+		return
+	}
+	p.move(duration, fyne.AnimationEaseOut, func(t float32) {
+		p.datum.setBetween(from, to, float64(t))
+	})
+}
+
+// runs an animation that changes the datum, redrawing and telling other widgets, such as the rest of a
+// SynchronisedImageGrid, after each step. Steps run with the widget locked. Any animation already running is stopped.
+func (p *PanZoomCanvas) move(d time.Duration, curve fyne.AnimationCurve, step func(t float32)) {
+	p.stopMoving()
+	a := fyne.NewAnimation(d, func(t float32) {
+		p.mu.Lock()
+		step(t)
+		p.mu.Unlock()
+		p.changed()
+	})
+	a.Curve = curve
+	p.mover.mu.Lock()
+	p.mover.animation = a
+	p.mover.mu.Unlock()
+	a.Start()
+}
+
+// stops any animation of the view where it is
+func (p *PanZoomCanvas) stopMoving() {
+	p.mover.mu.Lock()
+	defer p.mover.mu.Unlock()
+	if p.mover.animation != nil {
+		p.mover.animation.Stop()
+		p.mover.animation = nil
+	}
+}
+
+// keeps the latest positions of a drag, to find its speed when it ends. Called with the widget locked.
+func (p *PanZoomCanvas) trackDrag(position fyne.Position) {
+	now := time.Now()
+	drag := append(p.mover.drag, dragSample{position, now})
+	for len(drag) > 1 && now.Sub(drag[0].at) > 100*time.Millisecond {
+		drag = drag[1:]
+	}
+	p.mover.drag = drag
+}
+
+// carries on a fast drag after the mouse is released, slowing exponentially to a stop, and remembers where it stops.
+// Reports whether the drag was fast enough.
+func (p *PanZoomCanvas) fling() bool {
+	p.mu.Lock()
+	drag := p.mover.drag
+	p.mover.drag = nil
+	start := p.datum.view()
+	p.mu.Unlock()
+	if len(drag) < 2 || time.Since(drag[len(drag)-1].at) > 50*time.Millisecond { // the mouse had stopped
+		return false
+	}
+	first, last := drag[0], drag[len(drag)-1]
+	seconds := last.at.Sub(first.at).Seconds()
+	if seconds <= 0 {
+		return false
+	}
+	vx := float64(last.position.X-first.position.X) / seconds
+	vy := float64(last.position.Y-first.position.Y) / seconds
+	speed := math.Hypot(vx, vy)
+	if speed < flingMinSpeed {
+		return false
+	}
+	duration := flingDecay * math.Log(speed/flingEndSpeed)
+	p.move(time.Duration(duration*float64(time.Second)), fyne.AnimationLinear, func(t float32) {
+		travel := flingTravel(float64(t) * duration)
+		now := start
+		now.device = start.device.AddXY(float32(vx*travel), float32(vy*travel))
+		p.datum.setView(now)
+		p.datum.Constrain()
+		if t == 1 {
+			p.rememberView("drag")
+		}
+	})
+	return true
+}
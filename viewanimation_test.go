@@ -0,0 +1,46 @@
+package fynewidgets
+
+import (
+	"math"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+func TestDatumBetween(t *testing.T) {
+	d := testDatum(t)
+	mouse := fyne.NewPos(401.5, 123.25)
+	under, _ := d.DeviceToImage(mouse)
+	from := d.view()
+	for i := 0; i < 12; i++ {
+		d.ScaleByTick(mouse, 1)
+	}
+	to := d.view()
+	for _, step := range []float64{0, .3, .7, 1} {
+		d.setBetween(from, to, step)
+		got, _ := d.DeviceToImage(mouse)
+		if math.Abs(got.X-under.X) > 1e-3 || math.Abs(got.Y-under.Y) > 1e-3 {
+			t.Errorf("zooming %v of the way moved the point under the mouse from %v to %v", step, under, got)
+		}
+		want := float64(from.scale) * math.Pow(float64(to.scale/from.scale), step)
+		if math.Abs(float64(d.Scale)-want) > 1e-6*want || d.Ticks != to.ticks {
+			t.Errorf("zooming %v of the way: scale %v and %d ticks, want %v and %d", step, d.Scale, d.Ticks, want, to.ticks)
+		}
+	}
+	if d.view() != to {
+		t.Errorf("zoom ended at %+v, want %+v", d.view(), to)
+	}
+
+	centre := fyne.NewPos(320, 240)
+	before, _ := d.DeviceToImage(centre)
+	moved := to
+	moved.device = to.device.AddXY(-100, 60)
+	d.setBetween(to, moved, .5)
+	got, _ := d.DeviceToImage(centre.AddXY(-50, 30))
+	if math.Abs(got.X-before.X) > 1e-3 || math.Abs(got.Y-before.Y) > 1e-3 {
+		t.Errorf("half a pan shows %v where %v was expected", got, before)
+	}
+	if travel := flingTravel(10); math.Abs(travel-flingDecay) > 1e-6 {
+		t.Errorf("a fling travels %v times its speed, want %v", travel, flingDecay)
+	}
+}
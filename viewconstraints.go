@@ -0,0 +1,120 @@
+package fynewidgets
+
+import (
+	"math"
+
+	"fyne.io/fyne/v2"
+)
+
+// widest or tallest rectangle of a level, in its pixels, that a view will assemble
+const maxViewPixels = 10000
+
+// ViewConstraints limit how far a Datum can be zoomed and panned. Scrolling, dragging, ChangeScale and the keys of
+// PanZoomCanvas all keep to them. Whatever they say, the scale is also kept where the view can still be drawn: no more
+// than a quarter of the device across one image pixel, and no less than the coarsest level can fill.
+type ViewConstraints struct {
+	MinScale    float32 // smallest scale, in device pixels per image pixel, or 0 for no limit
+	MaxScale    float32 // largest scale, or 0 for no limit
+	MinVisible  float32 // device pixels of the image kept in view along each axis (or all of it, if it is smaller), or 0 to let it go off the device
+	CentreSmall bool    // keep the image centred along an axis of the device that it does not fill
+}
+
+// constraints that stop the image being lost: at most 32 device pixels per image pixel, and at least 32 device
+// pixels of the image kept in view
+func DefaultViewConstraints() ViewConstraints {
+	return ViewConstraints{MaxScale: 32, MinVisible: 32}
+}
+
+// range of ticks, at the datum's sensitivity, that the constraints allow on the device the datum was last fitted to
+func (d *Datum) tickLimits() (int, int) {
+	low, high := float64(d.Constraints.MinScale), float64(d.Constraints.MaxScale)
+	if d.device.Width > 0 && d.device.Height > 0 && d.Pyramid != nil {
+		top := math.Ldexp(1, d.Pyramid.Height()-1)
+		low = max(low, float64(d.device.Width+d.device.Height)/(maxViewPixels*top)) // allowing for the view being turned
+		drawable := float64(min(d.device.Width, d.device.Height)) / 4
+		if high <= 0 || high > drawable {
+			high = drawable
+		}
+	}
+	sensitivity := float64(max(d.Sensitivity, 1))
+	lowest, highest := math.MinInt32, math.MaxInt32
+	if low > 0 {
+		lowest = int(math.Ceil(math.Log2(low)*sensitivity - 1e-9))
+	}
+	if high > 0 {
+		highest = max(int(math.Floor(math.Log2(high)*sensitivity+1e-9)), lowest)
+	}
+	return lowest, highest
+}
+
+// ticks brought within the constraints
+func (d *Datum) constrainTicks(ticks int) int {
+	lowest, highest := d.tickLimits()
+	return min(max(ticks, lowest), highest)
+}
+
+// moves the image, if the constraints need it, to keep enough of it in view on the device the datum was last fitted
+// to. Call it after changing DeviceCoords or ImageCoords directly.
+func (d *Datum) Constrain() {
+	if d.Scale < 0 || d.Pyramid == nil || d.device.Width <= 0 || d.device.Height <= 0 {
+		return
+	}
+	c := d.Constraints
+	if c.MinVisible <= 0 && !c.CentreSmall {
+		return
+	}
+	b := d.Pyramid.Bounds(0)
+	x0, y0 := math.Inf(1), math.Inf(1) // extent of the image on the device
+	x1, y1 := math.Inf(-1), math.Inf(-1)
+	for _, corner := range []ImagePoint{{0, 0}, {float64(b.Dx()), 0}, {0, float64(b.Dy())}, {float64(b.Dx()), float64(b.Dy())}} {
+		P, _ := d.ImageToDevice(corner)
+		x0, y0 = min(x0, float64(P.X)), min(y0, float64(P.Y))
+		x1, y1 = max(x1, float64(P.X)), max(y1, float64(P.Y))
+	}
+	shift := func(low, high, size float64) float64 { // how far to move an axis of the image
+		if c.CentreSmall && high-low <= size {
+			return (size - low - high) / 2
+		}
+		if c.MinVisible <= 0 {
+			return 0
+		}
+		keep := min(float64(c.MinVisible), high-low)
+		switch {
+		case high < keep:
+			return keep - high
+		case low > size-keep:
+			return size - keep - low
+		}
+		return 0
+	}
+	dx := shift(x0, x1, float64(d.device.Width))
+	dy := shift(y0, y1, float64(d.device.Height))
+	if dx != 0 || dy != 0 {
+		moved := d.DeviceCoords.AddXY(float32(dx), float32(dy))
+		d.DeviceCoords = &moved
+	}
+}
+
+// sets the constraints, and keeps to them at once
+func (d *Datum) SetConstraints(c ViewConstraints) {
+	d.Constraints = c
+	if d.Scale < 0 {
+		return
+	}
+	if ticks := d.constrainTicks(d.Ticks); ticks != d.Ticks {
+		d.setTicks(ticks)
+	}
+	d.Constrain()
+}
+
+// sets the scale to a number of ticks, keeping the device anchor
+func (d *Datum) setTicks(ticks int) {
+	d.Ticks = ticks
+	d.Scale = TickScaleToFloatScale(ticks, d.Sensitivity)
+	d.level = d.levelForScale(d.Scale)
+}
+
+// the device the datum was last fitted to
+func (d *Datum) Device() fyne.Size {
+	return d.device
+}
@@ -0,0 +1,78 @@
+package fynewidgets
+
+import (
+	"math"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+func TestViewConstraintsScale(t *testing.T) {
+	d := testDatum(t)
+	size := d.Device()
+	mouse := fyne.NewPos(300, 200)
+	for i := 0; i < 300; i++ {
+		d.ScaleByTick(mouse, 1)
+	}
+	if d.Scale > d.Constraints.MaxScale {
+		t.Errorf("zoomed in to %v, beyond %v", d.Scale, d.Constraints.MaxScale)
+	}
+	if _, _, err := d.GetCurrentImage(size); err != nil {
+		t.Errorf("zoomed right in: %v", err)
+	}
+	d.ChangeScale(1000)
+	if d.Scale > d.Constraints.MaxScale {
+		t.Errorf("scaled to %v, beyond %v", d.Scale, d.Constraints.MaxScale)
+	}
+
+	d.SetConstraints(ViewConstraints{}) // the view must still be drawable
+	for i := 0; i < 600; i++ {
+		d.ScaleByTick(mouse, -1)
+	}
+	if _, _, err := d.GetCurrentImage(size); err != nil {
+		t.Errorf("zoomed right out: %v", err)
+	}
+	for i := 0; i < 600; i++ {
+		d.ScaleByTick(mouse, 1)
+	}
+	if _, _, err := d.GetCurrentImage(size); err != nil {
+		t.Errorf("zoomed right in without constraints: %v", err)
+	}
+
+	d.SetConstraints(ViewConstraints{MinScale: .1, MaxScale: 2})
+	if d.Scale > 2 {
+		t.Errorf("setting constraints left the scale at %v", d.Scale)
+	}
+	for i := 0; i < 100; i++ {
+		d.ScaleByTick(mouse, -1)
+	}
+	if d.Scale < .1 {
+		t.Errorf("zoomed out to %v, beyond .1", d.Scale)
+	}
+}
+
+func TestViewConstraintsPan(t *testing.T) {
+	d := testDatum(t)
+	size := d.Device()
+	d.ChangeProjection(fyne.NewPos(320, 240), 1)
+	far := fyne.NewPos(-5000, 9000) // drag the image far off the bottom left
+	d.DeviceCoords = &far
+	d.Constrain()
+	right, _ := d.ImageToDevice(ImagePoint{3001, 0})
+	top, _ := d.ImageToDevice(ImagePoint{0, 0})
+	if math.Abs(float64(right.X-d.Constraints.MinVisible)) > 1e-3 || math.Abs(float64(top.Y-(size.Height-d.Constraints.MinVisible))) > 1e-3 {
+		t.Errorf("image kept at %v to %v", top, right)
+	}
+
+	d.SetConstraints(ViewConstraints{CentreSmall: true})
+	d.ChangeProjection(fyne.NewPos(10, 10), .05) // much smaller than the device
+	centre, _ := d.ImageToDevice(ImagePoint{1500.5, 1000.5})
+	if math.Abs(float64(centre.X-size.Width/2)) > 1e-3 || math.Abs(float64(centre.Y-size.Height/2)) > 1e-3 {
+		t.Errorf("small image centred at %v", centre)
+	}
+	d.Rotate(fyne.NewPos(0, 0), 90)
+	centre, _ = d.ImageToDevice(ImagePoint{1500.5, 1000.5})
+	if math.Abs(float64(centre.X-size.Width/2)) > 1e-3 || math.Abs(float64(centre.Y-size.Height/2)) > 1e-3 {
+		t.Errorf("turned small image centred at %v", centre)
+	}
+}
@@ -0,0 +1,188 @@
+package fynewidgets
+
+import (
+	"context"
+	"image"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ViewInterpolation selects how a view is drawn from the pixels of a pyramid level
+type ViewInterpolation int
+
+const (
+	InterpolateNearest  ViewInterpolation = iota // each pixel of the level is a crisp square, for pixel-peeping. The default
+	InterpolateBilinear                          // smooth, from the nearest 2x2 pixels
+	InterpolateBicubic                           // smooth and sharp, from the nearest 4x4 pixels (Catmull-Rom)
+)
+
+func (i ViewInterpolation) String() string {
+	switch i {
+	case InterpolateBilinear:
+		return "bilinear"
+	case InterpolateBicubic:
+		return "bicubic"
+	}
+	return "nearest"
+}
+
+// the first pixel, and the weight of each pixel from there, that a level position x contributes from along one axis
+func (i ViewInterpolation) taps(x float64, weights []float64) (int, []float64) {
+	switch i {
+	case InterpolateBilinear:
+		f := math.Floor(x - .5)
+		t := x - .5 - f
+		return int(f), append(weights[:0], 1-t, t)
+	case InterpolateBicubic:
+		f := math.Floor(x - .5)
+		t := x - .5 - f
+		return int(f) - 1, append(weights[:0], catmullRom(t+1), catmullRom(t), catmullRom(1-t), catmullRom(2-t))
+	}
+	return int(math.Floor(x)), append(weights[:0], 1)
+}
+
+// Catmull-Rom cubic at a distance from a pixel centre
+func catmullRom(d float64) float64 {
+	d = math.Abs(d)
+	if d < 1 {
+		return 1.5*d*d*d - 2.5*d*d + 1
+	}
+	if d < 2 {
+		return -.5*d*d*d + 2.5*d*d - 4*d + 2
+	}
+	return 0
+}
+
+const viewStripRows int = 32 // rows of a view drawn between looks at whether it is still wanted
+
+// interpolates a region r of a level for each pixel of a w x h image, whose pixel i,j shows the level at
+// origin + (i+.5)u + (j+.5)v. Points outside the region take its nearest edge. Strips of rows are drawn in parallel.
+func sampleView(region *image.NRGBA, r image.Rectangle, origin, u, v ImagePoint, w, h int, interpolation ViewInterpolation) *image.NRGBA {
+	out, _ := sampleViewContext(context.Background(), region, r, origin, u, v, w, h, interpolation)
+	return out
+}
+
+// sampleView, giving up between strips if the context is cancelled
+func sampleViewContext(ctx context.Context, region *image.NRGBA, r image.Rectangle, origin, u, v ImagePoint, w, h int, interpolation ViewInterpolation) (*image.NRGBA, error) {
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if region.Rect.Dx() < 1 || region.Rect.Dy() < 1 {
+		return out, nil
+	}
+	s := viewSampler{region: region, r: r, origin: origin, u: u, v: v, out: out, interpolation: interpolation}
+	strips := (h + viewStripRows - 1) / viewStripRows
+	var next atomic.Int64 // strips are taken in turn by the workers
+	wg := sync.WaitGroup{}
+	for k := 0; k < min(runtime.GOMAXPROCS(0), strips); k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := int(next.Add(1)-1) * viewStripRows; j < h && ctx.Err() == nil; j = int(next.Add(1)-1) * viewStripRows {
+				s.rows(j, min(j+viewStripRows, h))
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// draws a view from a region of a level
+type viewSampler struct {
+	region        *image.NRGBA
+	r             image.Rectangle // of the level, that the region holds
+	origin, u, v  ImagePoint
+	out           *image.NRGBA
+	interpolation ViewInterpolation
+}
+
+// offset in the region's pixels of column x and row y of the level, clamped to the region
+func (s *viewSampler) column(x int) int {
+	return 4 * min(max(x-s.r.Min.X, 0), s.region.Rect.Dx()-1)
+}
+
+func (s *viewSampler) row(y int) int {
+	return s.region.PixOffset(s.region.Rect.Min.X, s.region.Rect.Min.Y+min(max(y-s.r.Min.Y, 0), s.region.Rect.Dy()-1))
+}
+
+// draws rows j0 up to j1 of the view
+func (s *viewSampler) rows(j0, j1 int) {
+	w := s.out.Rect.Dx()
+	if s.u.Y == 0 && s.v.X == 0 { // upright, so each column of the view reads the same columns of the level
+		columns := make([][]int, w)
+		cweights := make([][]float64, w)
+		for i := range columns {
+			first, weights := s.interpolation.taps(s.origin.X+(float64(i)+.5)*s.u.X, nil)
+			cweights[i] = weights
+			for k := range weights {
+				columns[i] = append(columns[i], s.column(first+k))
+			}
+		}
+		var rweights []float64
+		var rows []int
+		for j := j0; j < j1; j++ {
+			first, weights := s.interpolation.taps(s.origin.Y+(float64(j)+.5)*s.v.Y, rweights)
+			rweights, rows = weights, rows[:0]
+			for k := range weights {
+				rows = append(rows, s.row(first+k))
+			}
+			dst := s.out.Pix[s.out.PixOffset(0, j):]
+			for i := 0; i < w; i++ {
+				s.blend(dst[4*i:4*i+4], rows, rweights, columns[i], cweights[i])
+			}
+		}
+		return
+	}
+	var xweights, yweights []float64
+	var rows, columns []int
+	for j := j0; j < j1; j++ {
+		x := s.origin.X + (float64(j)+.5)*s.v.X + .5*s.u.X
+		y := s.origin.Y + (float64(j)+.5)*s.v.Y + .5*s.u.Y
+		dst := s.out.Pix[s.out.PixOffset(0, j):]
+		for i := 0; i < w; i++ {
+			var xfirst, yfirst int
+			xfirst, xweights = s.interpolation.taps(x, xweights)
+			yfirst, yweights = s.interpolation.taps(y, yweights)
+			columns, rows = columns[:0], rows[:0]
+			for k := range xweights {
+				columns = append(columns, s.column(xfirst+k))
+			}
+			for k := range yweights {
+				rows = append(rows, s.row(yfirst+k))
+			}
+			s.blend(dst[4*i:4*i+4], rows, yweights, columns, xweights)
+			x += s.u.X
+			y += s.u.Y
+		}
+	}
+}
+
+// sets a pixel to the weighted sum of region pixels at the offsets of rows plus columns. Colours are weighted by
+// alpha, so that transparent pixels lend no colour to their neighbours.
+func (s *viewSampler) blend(dst []byte, rows []int, rweights []float64, columns []int, cweights []float64) {
+	pix := s.region.Pix
+	if len(rows) == 1 && len(columns) == 1 {
+		copy(dst, pix[rows[0]+columns[0]:rows[0]+columns[0]+4])
+		return
+	}
+	var r, g, b, a float64
+	for m, row := range rows {
+		for n, column := range columns {
+			p := pix[row+column : row+column+4]
+			wa := rweights[m] * cweights[n] * float64(p[3])
+			r += wa * float64(p[0])
+			g += wa * float64(p[1])
+			b += wa * float64(p[2])
+			a += wa
+		}
+	}
+	if a <= 0 {
+		dst[0], dst[1], dst[2], dst[3] = 0, 0, 0, 0
+		return
+	}
+	clamp := func(c float64) byte { return byte(min(max(c+.5, 0), 255)) }
+	dst[0], dst[1], dst[2], dst[3] = clamp(r/a), clamp(g/a), clamp(b/a), clamp(a)
+}
@@ -0,0 +1,38 @@
+package fynewidgets
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestSampleViewInterpolation(t *testing.T) {
+	r := image.Rect(100, 200, 160, 260) // of a level
+	region := image.NewNRGBA(image.Rect(0, 0, 60, 60))
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 60; x++ {
+			region.SetNRGBA(x, y, color.NRGBA{uint8(x + 2*y), 0, 0, 255})
+		}
+	}
+	ramp := func(p ImagePoint) float64 { return p.X - .5 - 100 + 2*(p.Y-.5-200) } // what smooth interpolation gives
+	for _, view := range []struct{ origin, u, v ImagePoint }{
+		{ImagePoint{110.3, 207.9}, ImagePoint{.37, 0}, ImagePoint{0, .37}}, // upright and magnified
+		{ImagePoint{120, 205}, ImagePoint{.3, .2}, ImagePoint{-.2, .3}},    // turned
+	} {
+		for _, interpolation := range []ViewInterpolation{InterpolateBilinear, InterpolateBicubic} {
+			out := sampleView(region, r, view.origin, view.u, view.v, 70, 90, interpolation)
+			for _, pixel := range []image.Point{{0, 0}, {33, 17}, {69, 89}, {5, 71}} {
+				i, j := float64(pixel.X)+.5, float64(pixel.Y)+.5
+				at := ImagePoint{view.origin.X + i*view.u.X + j*view.v.X, view.origin.Y + i*view.u.Y + j*view.v.Y}
+				if got, want := float64(out.NRGBAAt(pixel.X, pixel.Y).R), ramp(at); math.Abs(got-want) > 1 {
+					t.Errorf("%s: pixel %v shows %v of the ramp, want %.1f", interpolation, pixel, got, want)
+				}
+			}
+		}
+	}
+	nearest := sampleView(region, r, ImagePoint{110.3, 207.9}, ImagePoint{.37, 0}, ImagePoint{0, .37}, 70, 90, InterpolateNearest)
+	if got := nearest.NRGBAAt(33, 17).R; got != 22+2*14 { // level pixel 122,214 {
+		t.Errorf("nearest pixel is %d, want 50", got)
+	}
+}
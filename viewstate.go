@@ -0,0 +1,120 @@
+package fynewidgets
+
+import (
+	"fyne.io/fyne/v2"
+	"github.com/pkg/errors"
+)
+
+// ViewState is what a view shows, small enough to save as JSON and restore later. Device positions are fractions of
+// the device's width and height, so a state can be restored in a window of another size: the same image point is put
+// at the same relative place, at the same scale.
+type ViewState struct {
+	Image       string     `json:"image,omitempty"` // URI of the image, if it came from one
+	Width       int        `json:"width"`           // size of the full-resolution image, to check it is the same one
+	Height      int        `json:"height"`
+	Anchor      ImagePoint `json:"anchor"`             // full-resolution image position shown at DeviceX, DeviceY
+	DeviceX     float64    `json:"x"`                  // device position of the anchor, as a fraction of the device's width
+	DeviceY     float64    `json:"y"`                  // and of its height
+	Ticks       int        `json:"ticks"`              // scale, as a number of ticks
+	Sensitivity int        `json:"sensitivity"`        // ticks per octave
+	Rotation    float64    `json:"rotation,omitempty"` // degrees clockwise
+	Mirrored    bool       `json:"mirrored,omitempty"` // flipped left to right before turning
+}
+
+// the state of the view on the device the datum was last fitted to, anchored at the centre of the device
+func (d *Datum) ViewState() (ViewState, error) {
+	if d.Scale < 0 || d.Pyramid == nil {
+		return ViewState{}, errors.New("f: ViewState - no datum has been set yet")
+	}
+	if d.device.Width <= 0 || d.device.Height <= 0 {
+		return ViewState{}, errors.New("f: ViewState - datum has not been fitted to a device")
+	}
+	centre, err := d.DeviceToImage(fyne.NewPos(d.device.Width/2, d.device.Height/2))
+	if err != nil {
+		return ViewState{}, errors.Wrap(err, "f: ViewState")
+	}
+	b := d.Pyramid.Bounds(0)
+	return ViewState{Width: b.Dx(), Height: b.Dy(), Anchor: centre, DeviceX: .5, DeviceY: .5,
+		Ticks: d.Ticks, Sensitivity: d.Sensitivity, Rotation: d.Rotation, Mirrored: d.Mirrored}, nil
+}
+
+// shows a saved state on the device the datum was last fitted to. The scale is converted to the datum's own
+// sensitivity, and kept within its constraints.
+func (d *Datum) SetViewState(s ViewState) error {
+	if d.Scale < 0 || d.Pyramid == nil {
+		return errors.New("f: SetViewState - no datum has been set yet")
+	}
+	if d.device.Width <= 0 || d.device.Height <= 0 {
+		return errors.New("f: SetViewState - datum has not been fitted to a device")
+	}
+	if b := d.Pyramid.Bounds(0); s.Width != b.Dx() || s.Height != b.Dy() {
+		return errors.Errorf("f: SetViewState - state is for a %dx%d image, not %dx%d", s.Width, s.Height, b.Dx(), b.Dy())
+	}
+	ticks := s.Ticks
+	if s.Sensitivity > 0 && s.Sensitivity != d.Sensitivity {
+		ticks = FloatScaleToTicks(TickScaleToFloatScale(s.Ticks, s.Sensitivity), d.Sensitivity)
+	}
+	anchor := s.Anchor
+	device := fyne.NewPos(float32(s.DeviceX)*d.device.Width, float32(s.DeviceY)*d.device.Height)
+	d.ImageCoords, d.DeviceCoords = &anchor, &device
+	d.Rotation, d.Mirrored = s.Rotation, s.Mirrored
+	d.setTicks(d.constrainTicks(ticks))
+	d.Constrain()
+	return nil
+}
+
+// what the widget shows, with the URI of its image if it has one
+func (p *PanZoomCanvas) ViewState() (ViewState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.viewState()
+}
+
+// called with the widget locked
+func (p *PanZoomCanvas) viewState() (ViewState, error) {
+	if p.datum == nil {
+		return ViewState{}, errors.New("f: ViewState - image has not loaded yet")
+	}
+	s, err := p.datum.ViewState()
+	if err != nil {
+		return ViewState{}, err
+	}
+	if p.uri != nil {
+		s.Image = p.uri.String()
+	}
+	return s, nil
+}
+
+// shows a saved state, redrawing and telling other widgets. If the image is still loading, the state is shown as
+// soon as it has loaded. A state saved from another image is refused.
+func (p *PanZoomCanvas) SetViewState(s ViewState) error {
+	if s.Image != "" && p.uri != nil && s.Image != p.uri.String() {
+		return errors.Errorf("f: SetViewState - state is for %s, not %s", s.Image, p.uri)
+	}
+	p.stopMoving()
+	p.mu.Lock()
+	if p.datum == nil || p.datum.Scale < 0 || p.datum.device.Width <= 0 || p.datum.device.Height <= 0 {
+		p.pending = &s
+		p.mu.Unlock()
+		return nil
+	}
+	p.pending = nil
+	err := p.datum.SetViewState(s)
+	if err == nil {
+		p.rememberView("state")
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	p.changed()
+	return nil
+}
+
+// shows a state that was set while the image was loading, once the datum has a device. Called with the widget locked.
+func (p *PanZoomCanvas) showPending() {
+	if s := p.pending; s != nil && p.datum != nil && p.datum.device.Width > 0 && p.datum.device.Height > 0 {
+		p.pending = nil
+		p.datum.SetViewState(*s)
+	}
+}
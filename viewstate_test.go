@@ -0,0 +1,49 @@
+package fynewidgets
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+func TestViewStateRoundTrip(t *testing.T) {
+	d := testDatum(t)
+	d.ChangeProjection(fyne.NewPos(100, 300), TickScaleToFloatScale(4, d.Sensitivity))
+	d.Rotate(fyne.NewPos(320, 240), 90)
+	saved, err := d.ViewState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := json.Marshal(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s ViewState
+	if err := json.Unmarshal(text, &s); err != nil {
+		t.Fatal(err)
+	}
+	centre, _ := d.DeviceToImage(fyne.NewPos(320, 240))
+
+	e := testDatum(t) // a bigger window, and finer ticks
+	e.Sensitivity = 10
+	if err := e.FitDevice(fyne.NewSize(1280, 960)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetViewState(s); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := e.DeviceToImage(fyne.NewPos(640, 480))
+	if math.Abs(got.X-centre.X) > 1e-3 || math.Abs(got.Y-centre.Y) > 1e-3 {
+		t.Errorf("centre shows %v, want %v", got, centre)
+	}
+	if e.Scale != d.Scale || e.Ticks != 8 || e.Rotation != 90 {
+		t.Errorf("restored scale %v, %d ticks, turned %v, want %v, 8 and 90", e.Scale, e.Ticks, e.Rotation, d.Scale)
+	}
+
+	s.Width++
+	if err := e.SetViewState(s); err == nil {
+		t.Error("state of another image was accepted")
+	}
+}